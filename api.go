@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/events"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	"github.com/huxcrux/docker-manager/pkg/version"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// apiPrefix is the path of the versioned JSON API
+const apiPrefix = "/api/v1"
+
+// Error codes of API error objects
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeNotAcceptable    = "not_acceptable"
+	codeUnsupportedMedia = "unsupported_media_type"
+	codeTooManyRequests  = "too_many_requests"
+	codeInternal         = "internal_error"
+)
+
+// apiError is the body of every failed API request
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// registerAPI registers the /api/v1 routes. Every response is JSON, failures are an error
+// object with a code and message.
+func registerAPI(mux *http.ServeMux, reconciler *reconcile.Reconciler, store *state.Store, auditLog *audit.Log, bus *events.Bus) {
+	routes := []struct {
+		pattern string
+		role    role
+		handler http.Handler
+	}{
+		{"GET /version", roleRead, apiVersion()},
+		{"GET /status", roleRead, apiStatus(reconciler)},
+		{"POST /reconcile", roleAdmin, rateLimit(apiReconcile(reconciler))},
+		{"POST /reload", roleAdmin, rateLimit(apiReload())},
+		{"GET /config", roleAdmin, apiConfig()},
+		{"PUT /config", roleAdmin, rateLimit(apiPutConfig(auditLog))},
+		{"POST /pause", roleAdmin, apiPause(reconciler)},
+		{"POST /resume", roleAdmin, apiResume(reconciler)},
+		{"GET /deferred", roleRead, apiDeferred(reconciler)},
+		{"GET /audit", roleRead, apiAudit(auditLog)},
+		{"POST /images/prefetch", roleAdmin, rateLimit(apiPrefetch(reconciler))},
+		{"GET /containers", roleRead, apiContainers(reconciler)},
+		{"GET /drift", roleRead, apiDrift(reconciler)},
+		{"GET /plan", roleRead, apiPlan(reconciler)},
+		{"GET /containers/{name}/image", roleRead, apiContainerImage(store)},
+		{"POST /containers/{name}/freeze", roleAdmin, apiFreeze(reconciler)},
+		{"POST /containers/{name}/unfreeze", roleAdmin, apiUnfreeze(reconciler)},
+		{"POST /containers/{name}/rollback", roleAdmin, apiRollback(reconciler)},
+		{"POST /containers/{name}/pin", roleAdmin, apiPin(reconciler)},
+		{"POST /containers/{name}/unpin", roleAdmin, apiUnpin(reconciler)},
+		{"POST /containers/{name}/start", roleAdmin, containerAction("start", reconciler.Start)},
+		{"POST /containers/{name}/stop", roleAdmin, containerAction("stop", reconciler.Stop)},
+		{"POST /containers/{name}/restart", roleAdmin, containerAction("restart", reconciler.Restart)},
+		{"POST /containers/{name}/exec", roleAdmin, requireAdminTokens(execContainer(reconciler))},
+	}
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route.pattern, " ")
+		mux.Handle(method+" "+apiPrefix+path, requireToken(route.role, negotiate(route.handler)))
+	}
+	// logs and events are streamed and exec sessions run over WebSocket, they bypass JSON
+	// negotiation
+	mux.Handle("GET "+apiPrefix+"/events", requireToken(roleRead, streamEvents(bus)))
+	mux.Handle("GET "+apiPrefix+"/containers/{name}/logs", requireToken(roleRead, containerLogs(reconciler)))
+	mux.Handle("GET "+apiPrefix+"/containers/{name}/exec", requireToken(roleAdmin, requireAdminTokens(execSession(reconciler))))
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No endpoint %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// negotiate rejects requests that don't accept JSON responses or send a body that isn't JSON
+func negotiate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r.Header.Get("Accept")) {
+			writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "Responses are application/json")
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "" && r.ContentLength != 0 {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "Request bodies must be application/json")
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// acceptsJSON reports whether an Accept header allows application/json, a missing header
+// accepts anything
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// wantsJSON reports whether a request to the API, which may be one the token middleware
+// rejects before negotiation, should get a JSON error object
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Path == apiPrefix || strings.HasPrefix(r.URL.Path, apiPrefix+"/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error encoding response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// writeReconcileError maps errors of the reconciler to their status, anything unknown is
+// logged and returned as an internal error
+func writeReconcileError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, reconcile.ErrNotConfigured), errors.Is(err, reconcile.ErrNoPreviousImage), errors.Is(err, reconcile.ErrNoContainer):
+		respondError(w, r, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, reconcile.ErrReconcileInProgress), errors.Is(err, reconcile.ErrPaused), errors.Is(err, reconcile.ErrNotRunning):
+		respondError(w, r, http.StatusConflict, codeConflict, err.Error())
+	default:
+		log.Errorf("Error %s: %v", action, err)
+		respondError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error %s: %v", action, err))
+	}
+}
+
+func apiVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, version.Get())
+	}
+}
+
+func apiStatus(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := readStatus(r.Context(), reconciler)
+		if err != nil {
+			writeReconcileError(w, r, err, "reading status")
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// apiReconcile reconciles and returns the result per container, with status 500 if any failed
+func apiReconcile(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := detach(audit.WithRequester(r.Context(), r.RemoteAddr))
+		defer cancel()
+		report, err := reconciler.Reconcile(ctx, currentConfig())
+		if err != nil {
+			writeReconcileError(w, r, err, "reconciling containers")
+			return
+		}
+
+		status := http.StatusOK
+		if len(report.Failed()) > 0 {
+			log.Errorf("Reconcile finished with errors: %v", report.Err())
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, summarize(report))
+	}
+}
+
+func apiReload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := updateConfig(); err != nil {
+			log.Errorf("Error reloading config: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error reloading config: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+	}
+}
+
+// apiConfig serves the loaded config with secrets redacted, with the keys of the YAML file
+func apiConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := configJSON(*currentConfig())
+		if err != nil {
+			log.Errorf("Error encoding config: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding config")
+			return
+		}
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// apiPutConfig replaces the config with a JSON document shaped like the one apiConfig serves
+func apiPutConfig(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading config: %v", err))
+			return
+		}
+
+		// JSON is valid YAML, the config is parsed like the config file
+		err = replaceConfig(data, r.RemoteAddr, auditLog)
+		switch {
+		case errors.Is(err, errInvalidConfig):
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		case err != nil:
+			log.Errorf("Error replacing config: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error replacing config: %v", err))
+			return
+		}
+		body, err := configJSON(*currentConfig())
+		if err != nil {
+			log.Errorf("Error encoding config: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding config")
+			return
+		}
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// configJSON returns cfg redacted and keyed like the YAML file, the config types have no JSON
+// tags
+func configJSON(cfg config.Config) (any, error) {
+	data, err := yaml.Marshal(config.Redact(cfg))
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]any
+	if err := yaml.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func apiPause(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := reconciler.Pause(); err != nil {
+			writeReconcileError(w, r, err, "pausing reconciliation")
+			return
+		}
+		log.Info("Reconciliation paused")
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+	}
+}
+
+func apiResume(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := reconciler.Resume(); err != nil {
+			writeReconcileError(w, r, err, "resuming reconciliation")
+			return
+		}
+		log.Info("Reconciliation resumed")
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+	}
+}
+
+func apiDeferred(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, reconciler.Deferred())
+	}
+}
+
+func apiAudit(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			log.Errorf("Error reading audit log: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error reading audit log: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// apiPrefetch pulls the images of the containers in the optional {"containers": [...]} body,
+// or of all configured containers
+func apiPrefetch(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Containers []string `json:"containers"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid body: %v", err))
+				return
+			}
+		}
+
+		ctx := audit.WithRequester(r.Context(), r.RemoteAddr)
+		results, err := reconciler.Prefetch(ctx, currentConfig(), body.Containers)
+		if err != nil {
+			writeReconcileError(w, r, err, "prefetching images")
+			return
+		}
+		status := http.StatusOK
+		for _, result := range results {
+			if result.Error != "" {
+				status = http.StatusInternalServerError
+				break
+			}
+		}
+		writeJSON(w, status, results)
+	}
+}
+
+func apiContainers(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containers, err := reconciler.Containers(r.Context(), currentConfig())
+		if err != nil {
+			writeReconcileError(w, r, err, "listing containers")
+			return
+		}
+		writeJSON(w, http.StatusOK, containers)
+	}
+}
+
+func apiDrift(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		drift, err := reconciler.Drift(r.Context(), currentConfig())
+		if err != nil {
+			writeReconcileError(w, r, err, "detecting drift")
+			return
+		}
+		writeJSON(w, http.StatusOK, drift)
+	}
+}
+
+func apiPlan(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changes, err := reconciler.Plan(r.Context(), currentConfig())
+		if err != nil {
+			writeReconcileError(w, r, err, "planning reconcile")
+			return
+		}
+		writeJSON(w, http.StatusOK, changes)
+	}
+}
+
+func apiContainerImage(store *state.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		record, err := store.Image(name)
+		if err != nil {
+			log.Errorf("Error reading image of container %s: %v", name, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error reading image of container %s: %v", name, err))
+			return
+		}
+		if record == nil {
+			writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No image recorded for container %s", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+func apiFreeze(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !configuredContainer(name) {
+			writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Container %s is not configured", name))
+			return
+		}
+		if err := reconciler.Freeze(name); err != nil {
+			writeReconcileError(w, r, err, "freezing container "+name)
+			return
+		}
+		log.Infof("Container %s frozen", name)
+		writeJSON(w, http.StatusOK, map[string]any{"container": name, "frozen": true})
+	}
+}
+
+func apiUnfreeze(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := reconciler.Unfreeze(name); err != nil {
+			writeReconcileError(w, r, err, "unfreezing container "+name)
+			return
+		}
+		log.Infof("Container %s unfrozen", name)
+		writeJSON(w, http.StatusOK, map[string]any{"container": name, "frozen": false})
+	}
+}
+
+func apiRollback(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		ctx, cancel := detach(audit.WithRequester(r.Context(), r.RemoteAddr))
+		defer cancel()
+		image, err := reconciler.Rollback(ctx, currentConfig(), name)
+		if err != nil {
+			writeReconcileError(w, r, err, "rolling back container "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"container": name, "image": image})
+	}
+}
+
+func apiPin(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		digest, err := reconciler.Pin(r.Context(), currentConfig(), name)
+		if err != nil {
+			writeReconcileError(w, r, err, "pinning container "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"container": name, "digest": digest})
+	}
+}
+
+func apiUnpin(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := reconciler.Unpin(name); err != nil {
+			writeReconcileError(w, r, err, "unpinning container "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"container": name, "pinned": false})
+	}
+}
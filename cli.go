@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	"github.com/huxcrux/docker-manager/pkg/version"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// listenFlag is the --listen flag of serve
+var listenFlag string
+
+// client flags of commands querying a running manager
+var (
+	serverFlag string
+	tokenFlag  string
+)
+
+func main() {
+	root := newRootCommand()
+	root.SetArgs(legacyArgs(os.Args[1:]))
+	if err := root.Execute(); err != nil {
+		os.Exit(exitError)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "docker-manager",
+		Short: "Run the containers of a config file and keep them in sync with it",
+		Long: "docker-manager runs the containers of its config file and keeps them in sync with it.\n" +
+			"Without a command it serves the API like docker-manager serve.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serve()
+			return nil
+		},
+	}
+	root.PersistentFlags().StringVarP(&config.File, "config", "f", config.File, "config file")
+	root.Flags().StringVar(&listenFlag, "listen", "", "address to serve the API on, overrides $DOCKER_MANAGER_LISTEN_ADDRESS and app_config.listen_address")
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the API, reconcile every reconcile_interval and heal containers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serve()
+			return nil
+		},
+	}
+	serveCmd.Flags().StringVar(&listenFlag, "listen", "", "address to serve the API on, overrides $DOCKER_MANAGER_LISTEN_ADDRESS and app_config.listen_address")
+
+	root.AddCommand(
+		serveCmd,
+		newApplyCommand(),
+		newRollbackCommand(),
+		newPlanCommand(),
+		newValidateCommand(),
+		newStatusCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+func newApplyCommand() *cobra.Command {
+	var once, failOnDrift bool
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Reconcile without serving the API",
+		Long: "Reconcile the containers of the config without serving the API: right away and then every\n" +
+			"reconcile_interval until SIGINT or SIGTERM, or a single time with --once, e.g. from cron or CI.\n" +
+			"--once exits with 0 when nothing changed, 2 when changes were applied (or deferred to a\n" +
+			"maintenance window) and 1 on errors. With --fail-on-drift any change exits with 1, to verify\n" +
+			"that a host matches its config.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if failOnDrift && !once {
+				return fmt.Errorf("--fail-on-drift requires --once")
+			}
+			m, err := localManager()
+			if err != nil {
+				return err
+			}
+			if once {
+				code := runOnce(m.reconciler, failOnDrift)
+				m.store.Close()
+				os.Exit(code)
+			}
+			defer m.store.Close()
+			return applyLoop(m.reconciler)
+		},
+	}
+	cmd.Flags().BoolVar(&once, "once", false, "reconcile a single time and exit with 0 (no changes), 2 (changes applied) or 1 (error)")
+	cmd.Flags().BoolVar(&failOnDrift, "fail-on-drift", false, "with --once, exit with 1 when anything had to change")
+	return cmd
+}
+
+func newRollbackCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback <container>",
+		Short: "Roll a container back to the image it ran before",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := localManager()
+			if err != nil {
+				return err
+			}
+			code := runRollback(m.reconciler, args[0])
+			m.store.Close()
+			os.Exit(code)
+			return nil
+		},
+	}
+}
+
+func newPlanCommand() *cobra.Command {
+	var noColor bool
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show the changes a reconcile would make, without changing anything",
+		Long: "Print the containers a reconcile would create, recreate, reconfigure, start or remove, like\n" +
+			"GET /plan, with the settings that differ. Without --server the Docker host is inspected\n" +
+			"directly, which needs the state directory and therefore no manager serving from it.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var changes []reconcile.PlannedChange
+			if serverFlag != "" {
+				if err := getJSON(cmd.Context(), "/api/v1/plan", &changes); err != nil {
+					return err
+				}
+			} else {
+				m, err := localManager()
+				if err != nil {
+					return err
+				}
+				defer m.store.Close()
+				if changes, err = m.reconciler.Plan(cmd.Context(), currentConfig()); err != nil {
+					return fmt.Errorf("error planning reconcile: %v", err)
+				}
+			}
+			printPlan(os.Stdout, changes, !noColor && colorTerminal(os.Stdout))
+			return nil
+		},
+	}
+	addClientFlags(cmd, "")
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "don't colorize the output, also disabled by $NO_COLOR or when not writing to a terminal")
+	return cmd
+}
+
+// ANSI colors of the plan
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+)
+
+// planSymbols are the diff markers and colors of each change
+var planSymbols = map[reconcile.Change]struct{ symbol, color string }{
+	reconcile.ChangeCreate:      {"+", colorGreen},
+	reconcile.ChangeRecreate:    {"-/+", colorYellow},
+	reconcile.ChangeReconfigure: {"~", colorYellow},
+	reconcile.ChangeStart:       {"~", colorYellow},
+	reconcile.ChangeRemove:      {"-", colorRed},
+	reconcile.ChangeFrozen:      {"#", colorCyan},
+}
+
+// printPlan renders changes as a diff: one line per container with its differing settings
+// below, followed by a summary
+func printPlan(w io.Writer, changes []reconcile.PlannedChange, color bool) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes, the containers match the config")
+		return
+	}
+
+	counts := make(map[reconcile.Change]int)
+	for _, change := range changes {
+		counts[change.Change]++
+		marker := planSymbols[change.Change]
+		symbol, reset := marker.symbol, ""
+		if color {
+			symbol, reset = marker.color+symbol, colorReset
+		}
+		fmt.Fprintf(w, "%s %s%s (%s)\n", symbol, change.Container, reset, change.Change)
+		for _, d := range change.Drift {
+			if d.Field == "exists" {
+				continue
+			}
+			fmt.Fprintf(w, "    %s: %v -> %v\n", d.Field, d.Actual, d.Desired)
+		}
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to recreate, %d to reconfigure, %d to start, %d to remove",
+		counts[reconcile.ChangeCreate], counts[reconcile.ChangeRecreate], counts[reconcile.ChangeReconfigure],
+		counts[reconcile.ChangeStart], counts[reconcile.ChangeRemove])
+	if frozen := counts[reconcile.ChangeFrozen]; frozen > 0 {
+		fmt.Fprintf(w, ", %d frozen", frozen)
+	}
+	fmt.Fprintln(w)
+}
+
+// colorTerminal reports whether f is a terminal and $NO_COLOR is unset
+func colorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config for problems without connecting to Docker",
+		Long: "Check the config offline, e.g. from a pre-commit hook or CI: unknown keys, values of the\n" +
+			"wrong type and everything a reload would reject. Every problem is printed on its own line\n" +
+			"and the command exits with 1 if there are any.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(config.File)
+			if err != nil {
+				return fmt.Errorf("error reading config: %v", err)
+			}
+			err = config.Check(data)
+			if err == nil {
+				fmt.Printf("%s is valid\n", config.File)
+				return nil
+			}
+
+			problems := []error{err}
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				problems = joined.Unwrap()
+			}
+			for _, problem := range problems {
+				fmt.Printf("%s: %v\n", config.File, problem)
+			}
+			return fmt.Errorf("found %d problems in %s", len(problems), config.File)
+		},
+	}
+}
+
+func newStatusCommand() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of a running manager",
+		Long: "Print the containers of a running manager as a table with their state, image, drift and\n" +
+			"available update, or the complete /status JSON with --json.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if asJSON {
+				var status json.RawMessage
+				if err := getJSON(cmd.Context(), "/api/v1/status", &status); err != nil {
+					return err
+				}
+				out, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+
+			var status managerStatus
+			if err := getJSON(cmd.Context(), "/api/v1/status", &status); err != nil {
+				return err
+			}
+			return printStatus(os.Stdout, status)
+		},
+	}
+	addClientFlags(cmd, "http://localhost"+defaultListenAddress)
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the status as JSON")
+	return cmd
+}
+
+// printStatus renders the status as a summary of the manager followed by a table with a row
+// per container
+func printStatus(w io.Writer, status managerStatus) error {
+	fmt.Fprintf(w, "docker-manager %s", status.Version.Version)
+	if status.Paused {
+		fmt.Fprint(w, ", reconciling paused")
+	}
+	if last := status.LastReconcile; last != nil {
+		fmt.Fprintf(w, ", last reconcile %s at %s", last.Result, last.Finished.Local().Format(time.DateTime))
+	}
+	fmt.Fprint(w, "\n\n")
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CONTAINER\tSTATE\tIMAGE\tDRIFT\tUPDATE AVAILABLE")
+	for _, container := range status.Containers {
+		state, image := "missing", container.Desired.Image
+		if actual := container.Actual; actual != nil {
+			state, image = actual.State, actual.Image
+			if actual.Health != "" {
+				state += " (" + actual.Health + ")"
+			}
+		}
+		switch {
+		case container.Frozen:
+			state += ", frozen"
+		case container.Stopped:
+			state += ", stopped"
+		}
+
+		drift := "-"
+		if len(container.Drift) > 0 {
+			fields := make([]string, 0, len(container.Drift))
+			for _, d := range container.Drift {
+				fields = append(fields, d.Field)
+			}
+			drift = strings.Join(fields, ", ")
+		}
+		update := "-"
+		if available := container.UpdateAvailable; available != nil {
+			// the same tag may have a new image, the ID tells them apart
+			update = available.Image
+			if id := strings.TrimPrefix(available.LatestImage, "sha256:"); id != "" {
+				update += " (" + id[:min(len(id), 12)] + ")"
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", container.Name, state, image, drift, update)
+	}
+	return table.Flush()
+}
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of this build",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			info := version.Get()
+			fmt.Printf("docker-manager %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.Date, info.GoVersion)
+		},
+	}
+}
+
+// addClientFlags adds the flags of commands talking to a running manager
+func addClientFlags(cmd *cobra.Command, defaultServer string) {
+	cmd.Flags().StringVar(&serverFlag, "server", defaultServer, "URL of a running manager, such as http://host:8082")
+	cmd.Flags().StringVar(&tokenFlag, "token", os.Getenv("DOCKER_MANAGER_TOKEN"), "API token of the manager, $DOCKER_MANAGER_TOKEN by default")
+}
+
+// getJSON decodes the JSON response of the running manager at --server to path
+func getJSON(ctx context.Context, path string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(serverFlag, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if tokenFlag != "" {
+		req.Header.Set("Authorization", "Bearer "+tokenFlag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body apiError
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if err := json.Unmarshal(data, &body); err == nil && body.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+// legacyArgs translates the flags of earlier releases, -once, -rollback <name> and
+// -listen <address>, to the commands replacing them
+func legacyArgs(args []string) []string {
+	var once bool
+	var rollback, listen string
+	var found bool
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") {
+			return args
+		}
+		switch name {
+		case "once":
+			once = !hasValue || value == "true"
+		case "rollback", "listen":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return args
+				}
+				i++
+				value = args[i]
+			}
+			if name == "rollback" {
+				rollback = value
+			} else {
+				listen = value
+			}
+		default:
+			return args
+		}
+		found = true
+	}
+	if !found {
+		return args
+	}
+
+	var translated []string
+	switch {
+	case once:
+		translated = []string{"apply", "--once"}
+	case rollback != "":
+		translated = []string{"rollback", rollback}
+	default:
+		translated = []string{"serve", "--listen", listen}
+	}
+	log.Warnf("%s is deprecated, use docker-manager %s", strings.Join(args, " "), strings.Join(translated, " "))
+	return translated
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+)
+
+func TestLegacyArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-once"}, []string{"apply", "--once"}},
+		{[]string{"--rollback", "web"}, []string{"rollback", "web"}},
+		{[]string{"-listen=:9000"}, []string{"serve", "--listen", ":9000"}},
+		{[]string{"serve", "--listen", ":9000"}, []string{"serve", "--listen", ":9000"}},
+		{[]string{"-f", "other.yaml"}, []string{"-f", "other.yaml"}},
+		{nil, nil},
+	} {
+		if got := legacyArgs(tc.args); !slices.Equal(got, tc.want) {
+			t.Errorf("legacyArgs(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	changes := []reconcile.PlannedChange{
+		{Container: "web", Change: reconcile.ChangeCreate, Drift: []reconcile.Drift{{Field: "exists", Desired: true, Actual: false}}},
+		{Container: "api", Change: reconcile.ChangeRecreate, Drift: []reconcile.Drift{{Field: "image", Desired: "api:2", Actual: "api:1"}}},
+	}
+
+	var b strings.Builder
+	printPlan(&b, changes, false)
+	expected := "+ web (create)\n-/+ api (recreate)\n    image: api:1 -> api:2\n\nPlan: 1 to create, 1 to recreate, 0 to reconfigure, 0 to start, 0 to remove\n"
+	if b.String() != expected {
+		t.Errorf("Expected plan\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	var status managerStatus
+	status.Version.Version = "1.0.0"
+	status.Containers = []reconcile.ContainerStatus{
+		{Name: "web", Desired: reconcile.DesiredState{Image: "nginx:1"}},
+		{
+			Name:            "api",
+			Actual:          &reconcile.ActualState{State: "running", Image: "api:1"},
+			Drift:           []reconcile.Drift{{Field: "memory"}, {Field: "cmd"}},
+			UpdateAvailable: &reconcile.AvailableUpdate{Image: "api:2", LatestImage: "sha256:0123456789abcdef"},
+		},
+	}
+
+	var b strings.Builder
+	if err := printStatus(&b, status); err != nil {
+		t.Fatal(err)
+	}
+	expected := "docker-manager 1.0.0\n\n" +
+		"CONTAINER  STATE    IMAGE    DRIFT        UPDATE AVAILABLE\n" +
+		"web        missing  nginx:1  -            -\n" +
+		"api        running  api:1    memory, cmd  api:2 (0123456789ab)\n"
+	if b.String() != expected {
+		t.Errorf("Expected status\n%s\ngot\n%s", expected, b.String())
+	}
+}
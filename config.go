@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// maxConfigSize bounds submitted configs
+const maxConfigSize = 1 << 20
+
+// configWriteMu serializes config submissions, so each one validates against the config it
+// replaces
+var configWriteMu sync.Mutex
+
+// errInvalidConfig wraps problems with a submitted config, as opposed to failures to apply it
+var errInvalidConfig = errors.New("invalid config")
+
+// getConfig serves the loaded config as YAML, with secrets redacted
+func getConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := yaml.Marshal(config.Redact(*currentConfig()))
+		if err != nil {
+			log.Errorf("Error encoding config: %v", err)
+			http.Error(w, "Error encoding config", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	}
+}
+
+// putConfig validates a YAML config, writes it to the config file and applies it
+func putConfig(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading config: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = replaceConfig(data, r.RemoteAddr, auditLog)
+		switch {
+		case errors.Is(err, errInvalidConfig):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			log.Errorf("Error replacing config: %v", err)
+			http.Error(w, fmt.Sprintf("Error replacing config: %v", err), http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, "Config applied\n")
+		}
+	}
+}
+
+// replaceConfig validates data, fills in secrets redacted by GET /config from the current
+// config, persists it to the config file and applies it. The previous file is restored when
+// the config can't be applied.
+func replaceConfig(data []byte, requester string, auditLog *audit.Log) (err error) {
+	configWriteMu.Lock()
+	defer configWriteMu.Unlock()
+
+	newcfg, err := config.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	submitted := *newcfg
+	if err := config.Unredact(newcfg, *currentConfig()); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	if err := config.Validate(*newcfg); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	// the submitted file is kept as is, comments included, unless secrets had to be filled in
+	if !reflect.DeepEqual(submitted, *newcfg) {
+		if data, err = yaml.Marshal(newcfg); err != nil {
+			return fmt.Errorf("error encoding config: %v", err)
+		}
+	}
+
+	defer func() {
+		entry := audit.Entry{Action: audit.ActionUpdateConfig, Requester: requester}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if auditLog == nil {
+			return
+		}
+		if err := auditLog.Record(entry); err != nil {
+			log.Errorf("Error writing audit log: %v", err)
+		}
+	}()
+
+	previous, err := os.ReadFile(config.File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading current config file: %v", err)
+	}
+	if err := config.Write(data); err != nil {
+		return err
+	}
+	if err := applyConfig(newcfg); err != nil {
+		if previous != nil {
+			if restoreErr := config.Write(previous); restoreErr != nil {
+				log.Errorf("Error restoring previous config file: %v", restoreErr)
+			}
+		}
+		return err
+	}
+	log.Infof("Config replaced by %s", requester)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/huxcrux/docker-manager/pkg/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// eventsKeepAlive is how often an idle event stream sends a comment, so proxies don't close it
+const eventsKeepAlive = 30 * time.Second
+
+// streamEvents streams the manager's events as Server-Sent Events named by their type, or as
+// JSON text messages when the request is a WebSocket upgrade. The repeatable type and the
+// container query parameters filter the events.
+func streamEvents(bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		types := r.URL.Query()["type"]
+		container := r.URL.Query().Get("container")
+		match := func(event events.Event) bool {
+			return (len(types) == 0 || slices.Contains(types, event.Type)) && (container == "" || event.Container == container)
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			eventsWebSocket(w, r, bus, match)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respondError(w, r, http.StatusInternalServerError, codeInternal, "Streaming is not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		flusher.Flush()
+
+		subscription, unsubscribe := bus.Subscribe()
+		defer unsubscribe()
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case event := <-subscription:
+				if !match(event) {
+					continue
+				}
+				payload, err := json.Marshal(event)
+				if err != nil {
+					log.Errorf("Error encoding %s event: %v", event.Type, err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// eventsWebSocket sends the matching events as JSON text messages until the client disconnects
+func eventsWebSocket(w http.ResponseWriter, r *http.Request, bus *events.Bus, match func(events.Event) bool) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("Error upgrading event stream: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	subscription, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	// Reading is required to notice the client closing the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-subscription:
+			if !match(event) {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/websocket"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	log "github.com/sirupsen/logrus"
+)
+
+// execRequest is the body of POST /containers/{name}/exec
+type execRequest struct {
+	Cmd []string `json:"cmd"`
+	// Timeout such as 30s, docker.DefaultExecTimeout by default
+	Timeout string `json:"timeout"`
+}
+
+// execContainer runs a command in a configured container and returns its exit code and
+// output as JSON
+func execContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		var body execRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid body: %v", err))
+			return
+		}
+		if len(body.Cmd) == 0 {
+			respondError(w, r, http.StatusBadRequest, codeBadRequest, "cmd is required")
+			return
+		}
+		var timeout time.Duration
+		if body.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(body.Timeout); err != nil {
+				respondError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid timeout: %v", err))
+				return
+			}
+		}
+
+		ctx := audit.WithRequester(r.Context(), r.RemoteAddr)
+		result, err := reconciler.Exec(ctx, currentConfig(), name, body.Cmd, timeout)
+		if err != nil {
+			writeReconcileError(w, r, err, "executing command in container "+name)
+			return
+		}
+		log.Infof("Executed %v in container %s, exit code %d", body.Cmd, name, result.ExitCode)
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// upgrader rejects WebSocket connections from pages of other origins, clients that send no
+// Origin such as websocat are accepted
+var upgrader = websocket.Upgrader{}
+
+// execSession runs a command given as repeated cmd query parameters attached to a WebSocket.
+// Messages from the client are written to the command's stdin, its output is sent as binary
+// messages and the connection is closed with the exit code as reason. tty=true allocates a
+// TTY for interactive shells.
+func execSession(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		cmd := r.URL.Query()["cmd"]
+		if len(cmd) == 0 {
+			respondError(w, r, http.StatusBadRequest, codeBadRequest, "cmd is required")
+			return
+		}
+		tty := false
+		if raw := r.URL.Query().Get("tty"); raw != "" {
+			var err error
+			if tty, err = strconv.ParseBool(raw); err != nil {
+				respondError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid tty %q", raw))
+				return
+			}
+		}
+		if !websocket.IsWebSocketUpgrade(r) {
+			respondError(w, r, http.StatusBadRequest, codeBadRequest, "Interactive exec requires a WebSocket, POST runs a command")
+			return
+		}
+
+		// Upgrade before the command starts, a rejected upgrade must not run it
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Errorf("Error upgrading exec of container %s: %v", name, err)
+			return
+		}
+		defer conn.Close()
+
+		// The session outlives the request context once the connection is hijacked
+		ctx, cancel := context.WithCancel(audit.WithRequester(context.Background(), r.RemoteAddr))
+		defer cancel()
+		session, err := reconciler.ExecAttach(ctx, currentConfig(), name, cmd, tty)
+		if err != nil {
+			log.Errorf("Error executing command in container %s: %v", name, err)
+			reason := fmt.Sprintf("Error executing command in container %s: %v", name, err)
+			// control frames carry at most 123 bytes of reason
+			if len(reason) > 123 {
+				reason = reason[:123]
+			}
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, reason), time.Now().Add(time.Second))
+			return
+		}
+		defer session.Conn.Close()
+		log.Infof("Started interactive %v in container %s", cmd, name)
+
+		// Forward the client's messages to stdin until it disconnects
+		go func() {
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					session.Conn.CloseWrite()
+					return
+				}
+				if _, err := session.Conn.Conn.Write(message); err != nil {
+					return
+				}
+			}
+		}()
+
+		out := &wsWriter{conn: conn}
+		if tty {
+			_, err = io.Copy(out, session.Conn.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(out, out, session.Conn.Reader)
+		}
+		if err != nil {
+			log.Debugf("Exec of container %s ended: %v", name, err)
+		}
+
+		reason := "exited"
+		if code, err := reconciler.ExitCode(ctx, session); err == nil {
+			reason = fmt.Sprintf("exit code %d", code)
+		}
+		out.mu.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(time.Second))
+		out.mu.Unlock()
+	}
+}
+
+// wsWriter sends every write as a binary WebSocket message
+type wsWriter struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// readyTimeout bounds the Docker daemon ping of /readyz
+const readyTimeout = 2 * time.Second
+
+// healthz reports that the process is up and serving
+func healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// readyz reports with 200 that a config is loaded and the Docker daemon answers, 503 with
+// the failing checks otherwise
+func readyz(cli *client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{"config": "ok", "docker": "ok"}
+		ready := true
+		if currentConfig() == nil {
+			checks["config"] = "no config loaded"
+			ready = false
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if _, err := cli.Ping(ctx); err != nil {
+			checks["docker"] = err.Error()
+			ready = false
+		}
+
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, map[string]any{"ready": ready, "checks": checks})
+	}
+}
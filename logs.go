@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultLogTail is the number of lines returned without a tail parameter, so a crash looping
+// container doesn't send its whole history
+const defaultLogTail = "100"
+
+// containerLogs returns the logs of a configured container as plain text, or as Server-Sent
+// Events ("log" per line, then "end" or "error") when the client accepts text/event-stream.
+// It takes the tail (a number or all), since (RFC3339 or a duration such as 10m), follow and
+// timestamps query parameters.
+func containerLogs(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		query := r.URL.Query()
+
+		opts := reconcile.LogOptions{Tail: defaultLogTail, Since: query.Get("since")}
+		if tail := query.Get("tail"); tail != "" {
+			if n, err := strconv.Atoi(tail); tail != "all" && (err != nil || n < 0) {
+				respondError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid tail %q, expected a number or all", tail))
+				return
+			}
+			opts.Tail = tail
+		}
+		for param, value := range map[string]*bool{"follow": &opts.Follow, "timestamps": &opts.Timestamps} {
+			if raw := query.Get(param); raw != "" {
+				parsed, err := strconv.ParseBool(raw)
+				if err != nil {
+					respondError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Invalid %s %q", param, raw))
+					return
+				}
+				*value = parsed
+			}
+		}
+
+		logs, tty, err := reconciler.Logs(r.Context(), currentConfig(), name, opts)
+		switch {
+		case errors.Is(err, reconcile.ErrNotConfigured), errors.Is(err, reconcile.ErrNoContainer):
+			respondError(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Container %s: %v", name, err))
+			return
+		case docker.IsInvalidParameter(err):
+			respondError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		case err != nil:
+			log.Errorf("Error reading logs of container %s: %v", name, err)
+			respondError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error reading logs of container %s: %v", name, err))
+			return
+		}
+		defer logs.Close()
+
+		flusher, _ := w.(http.Flusher)
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			streamLogEvents(w, flusher, logs, tty)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		out := &flushWriter{w: w, flusher: flusher}
+		if tty {
+			_, err = io.Copy(out, logs)
+		} else {
+			_, err = stdcopy.StdCopy(out, out, logs)
+		}
+		if err != nil && r.Context().Err() == nil {
+			log.Errorf("Error streaming logs of container %s: %v", name, err)
+		}
+	}
+}
+
+// streamLogEvents sends every log line as a "log" event with its stream
+func streamLogEvents(w http.ResponseWriter, flusher http.Flusher, logs io.Reader, tty bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var mu sync.Mutex
+	send := func(event string, data any) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Errorf("Error encoding %s event: %v", event, err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	line := func(stream string, text string) {
+		send("log", map[string]string{"stream": stream, "line": text})
+	}
+
+	stdout := &lineWriter{stream: "stdout", emit: line}
+	stderr := &lineWriter{stream: "stderr", emit: line}
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		send("error", map[string]string{"error": err.Error()})
+		return
+	}
+	send("end", map[string]string{})
+}
+
+// flushWriter flushes after every write, so followed logs reach the client right away
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// lineWriter splits what is written into lines and emits each one without its newline
+type lineWriter struct {
+	stream string
+	emit   func(stream string, line string)
+	buf    []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.emit(l.stream, strings.TrimSuffix(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Close emits a last line without a trailing newline
+func (l *lineWriter) Close() error {
+	if len(l.buf) > 0 {
+		l.emit(l.stream, string(l.buf))
+		l.buf = nil
+	}
+	return nil
+}
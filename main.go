@@ -1,400 +1,522 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"reflect"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/huxcrux/docker-manager/pkg/audit"
 	"github.com/huxcrux/docker-manager/pkg/config"
 	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/events"
 	"github.com/huxcrux/docker-manager/pkg/metrics"
+	"github.com/huxcrux/docker-manager/pkg/notify"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	"github.com/huxcrux/docker-manager/pkg/verify"
+	"github.com/huxcrux/docker-manager/pkg/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
+// defaultListenAddress is used when no listen address is configured
+const defaultListenAddress = ":8082"
+
 // Global variable
 var (
 	cfg   *config.Config
 	cfgMu sync.RWMutex
+	// adminTokens and readOnlyTokens are the resolved app_config.api tokens, guarded by cfgMu
+	adminTokens    []string
+	readOnlyTokens []string
 )
 
+// updateConfig reads the config file and applies it
 func updateConfig() error {
 	newcfg, err := config.Read()
 	if err != nil {
 		return fmt.Errorf("error reading config: %v", err)
 	}
+	return applyConfig(newcfg)
+}
+
+// applyConfig makes newcfg the current config, unless it is invalid
+func applyConfig(newcfg *config.Config) error {
+	if err := config.Validate(*newcfg); err != nil {
+		return err
+	}
+	registries, err := config.RegistryHosts(*newcfg)
+	if err != nil {
+		return err
+	}
+	if err := metrics.SetDisabledFamilies(newcfg.AppConfig.DisabledMetrics); err != nil {
+		return err
+	}
+	admin, readOnly, err := config.APITokens(newcfg.AppConfig.API)
+	if err != nil {
+		return err
+	}
 
-	log.Debugf("New config: %+v", newcfg)
+	// Don't log registry credentials or API tokens
+	logged := *newcfg
+	logged.Registries = nil
+	logged.AppConfig.API.Tokens = nil
+	logged.AppConfig.API.ReadOnlyTokens = nil
+	log.Debugf("New config: %+v", logged)
 
 	// Use the mutex to prevent race conditions
 	cfgMu.Lock()
 	cfg = newcfg
+	adminTokens = admin
+	readOnlyTokens = readOnly
 	cfgMu.Unlock()
 
+	docker.SetRetryPolicy(config.RetryPolicy(*newcfg))
+	registry.Configure(registries)
+	docker.SetPullConcurrency(newcfg.AppConfig.PullLimits.MaxConcurrent)
+	registry.SetRateLimit(newcfg.AppConfig.PullLimits.RequestsPerSecond, newcfg.AppConfig.PullLimits.Burst)
+	notify.Configure(newcfg.AppConfig.Notifications.Webhooks)
+	verify.SetCosign(newcfg.AppConfig.CosignPath)
+	metrics.SetOneShotStats(newcfg.AppConfig.OneShotStats)
+	metrics.SetPerCPU(newcfg.AppConfig.PerCPUMetrics)
+	metrics.SetLegacyGauges(newcfg.AppConfig.LegacyGaugeMetrics)
+	metrics.SetAggregateNetwork(newcfg.AppConfig.AggregateNetworkMetrics == nil || *newcfg.AppConfig.AggregateNetworkMetrics)
+
 	log.Info("Config reloaded")
 
 	return nil
 }
 
-// isContainerUpToDate checks if a running container is using the latest available image
-func isContainerUpToDate(cli *client.Client, containerID string, config docker.ContainerConfig) (bool, error) {
-	ctx := context.Background()
+func reconcileContainers(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := detach(audit.WithRequester(r.Context(), r.RemoteAddr))
+		defer cancel()
+		report, err := reconciler.Reconcile(ctx, currentConfig())
+		if errors.Is(err, reconcile.ErrReconcileInProgress) {
+			http.Error(w, "Reconcile already in progress", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, reconcile.ErrPaused) {
+			http.Error(w, "Reconciliation is paused", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Errorf("Error reconciling containers: %v", err)
+			http.Error(w, fmt.Sprintf("Error reconciling containers: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-	// Get the running container's image ID
-	inspect, err := cli.ContainerInspect(ctx, containerID)
-	if err != nil {
-		return false, err
+		if len(report.Failed()) > 0 {
+			log.Errorf("Reconcile finished with errors: %v", report.Err())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, report.String())
 	}
-	runningImageID := inspect.Image
+}
 
-	// Pull the latest image
-	reader, err := cli.ImagePull(ctx, config.Image, image.PullOptions{})
-	if err != nil {
-		return false, err
-	}
-	defer reader.Close()
-	// Consume the reader to complete the image pull
-	_, _ = io.Copy(io.Discard, reader)
+// streamReconcile runs a reconcile and streams its progress as Server-Sent Events. Each step
+// is sent as a "progress" event, the per-container results as a final "done" event and
+// errors that prevent the reconcile from running as an "error" event.
+func streamReconcile(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
 
-	// Get the latest image ID
-	images, err := cli.ImageList(ctx, image.ListOptions{})
-	if err != nil {
-		return false, err
-	}
-	var latestImageID string
-	for _, img := range images {
-		for _, tag := range img.RepoTags {
-			if tag == config.Image {
-				latestImageID = img.ID
-				break
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		var mu sync.Mutex
+		send := func(event string, data any) {
+			payload, err := json.Marshal(data)
+			if err != nil {
+				log.Errorf("Error encoding %s event: %v", event, err)
+				return
 			}
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			flusher.Flush()
 		}
-	}
 
-	if latestImageID == "" {
-		return false, fmt.Errorf("could not find the latest image for %s", config.Image)
-	}
+		ctx, cancel := detach(audit.WithRequester(r.Context(), r.RemoteAddr))
+		defer cancel()
+		ctx = reconcile.WithProgress(ctx, func(event reconcile.Event) {
+			send("progress", event)
+		})
 
-	// Compare the image IDs
-	result := runningImageID == latestImageID
-	if result {
-		log.Debugf("Container %s is up to date\n", config.Name)
-	} else {
-		log.Debugf("Container %s is not up to date\n", config.Name)
+		report, err := reconciler.Reconcile(ctx, currentConfig())
+		if err != nil {
+			log.Errorf("Error reconciling containers: %v", err)
+			send("error", map[string]string{"error": err.Error()})
+			return
+		}
+
+		send("done", summarize(report))
 	}
+}
 
-	// Compare the image IDs
-	return result, nil
+// reconcileSummary is the JSON form of a reconcile report
+type reconcileSummary struct {
+	Failed  int               `json:"failed"`
+	Results []reconcileResult `json:"results"`
 }
 
-// ensureContainerConfig checks if a running container matches the given ContainerConfig and recreates it if necessary
-func ensureContainerConfig(cli *client.Client, config docker.ContainerConfig) error {
-	ctx := context.Background()
+type reconcileResult struct {
+	Container string `json:"container"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
 
-	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
-	if err != nil {
-		return err
+func summarize(report *reconcile.Report) reconcileSummary {
+	summary := reconcileSummary{Failed: len(report.Failed()), Results: make([]reconcileResult, 0, len(report.Results))}
+	for _, res := range report.Results {
+		entry := reconcileResult{Container: res.Container, Action: string(res.Action)}
+		if res.Err != nil {
+			entry.Error = res.Err.Error()
+		}
+		summary.Results = append(summary.Results, entry)
 	}
+	return summary
+}
 
-	for _, container := range containers {
-		if container.Names[0] == "/"+config.Name {
-			inspect, err := cli.ContainerInspect(ctx, container.ID)
-			if err != nil {
-				return err
-			}
-
-			// Validate container configuration
-			needsUpdate := false
-
-			// Check environment variables
-			// Some env vars is set by container. We need to match the ones we care about. Unclear how we track vars that is unset over time.
-			// Skipping for now and will return to this later on.
-			//if !reflect.DeepEqual(inspect.Config.Env, config.Env) {
-			//	log.Debugf("Container %s environment does not match\n", config.Name)
-			//	needsUpdate = true
-			//}
-
-			// Check port bindings
-			if !reflect.DeepEqual(inspect.Config.ExposedPorts, config.ExposedPorts) {
-				log.Debugf("Container %s exposed ports do not match\n", config.Name)
-				needsUpdate = true
-			}
-			if !reflect.DeepEqual(inspect.HostConfig.PortBindings, config.PortBindings) {
-				log.Debugf("Container %s port bindings do not match\n", config.Name)
-				needsUpdate = true
-			}
-
-			// Check image
-			if !reflect.DeepEqual(inspect.Config.Image, config.Image) {
-				log.Debugf("Container %s image does not match\n", config.Name)
-				needsUpdate = true
-			}
-
-			// Check command
-			if config.Cmd != nil {
-				if !reflect.DeepEqual(inspect.Config.Cmd, config.Cmd) {
-					log.Debugf("Container %s command does not match\n", config.Name)
-					needsUpdate = true
-				}
-			}
-
-			if needsUpdate {
-				log.Infof("Container %s configuration does not match, recreating it...\n", config.Name)
-
-				err = docker.DeleteContainer(cli, container.ID)
-				if err != nil {
-					return err
-				}
-
-				// create container with the correct configuration
-				err, created := docker.CreateContainer(cli, config)
-				if err != nil {
-					return err
-				}
-				if created {
-					log.Infof("Container %s recreated with the correct configuration\n", config.Name)
-				}
-
-			} else {
-				log.Debugf("Config for container %s already up to date\n", config.Name)
-			}
-			return nil
+func pauseReconcile(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := reconciler.Pause()
+		if err != nil {
+			log.Errorf("Error pausing reconciliation: %v", err)
+			http.Error(w, fmt.Sprintf("Error pausing reconciliation: %v", err), http.StatusInternalServerError)
+			return
 		}
+		log.Info("Reconciliation paused")
+		fmt.Fprint(w, "Reconciliation paused\n")
 	}
+}
 
-	log.Infof("Container %s not found, creating it...\n", config.Name)
-	_, err = cli.ContainerCreate(ctx, &container.Config{
-		Image:        config.Image,
-		ExposedPorts: config.ExposedPorts,
-		Env:          config.Env,
-		Cmd:          config.Cmd,
-	}, &container.HostConfig{
-		PortBindings: config.PortBindings,
-	}, nil, nil, config.Name)
-	if err != nil {
-		return err
+func resumeReconcile(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := reconciler.Resume()
+		if err != nil {
+			log.Errorf("Error resuming reconciliation: %v", err)
+			http.Error(w, fmt.Sprintf("Error resuming reconciliation: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Info("Reconciliation resumed")
+		fmt.Fprint(w, "Reconciliation resumed\n")
 	}
-	return nil
 }
 
-// createContainers creates multiple Docker containers based on the provided configurations
-func ensureContainers(cli *client.Client, desierdContainers []docker.ContainerConfig, updateCheck bool) error {
+// currentConfig returns the config in use
+func currentConfig() *config.Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
 
-	// get running containers
-	runningContainers, err := docker.ListAllContariners(cli)
-	if err != nil {
-		return err
+// configuredContainer reports whether name is a container in the current config
+func configuredContainer(name string) bool {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+
+	for _, container := range cfg.Containers {
+		if container.Name == name {
+			return true
+		}
 	}
+	return false
+}
 
-	for _, container := range desierdContainers {
-		// check if container already exists
-		found := false
-		if len(runningContainers) > 0 {
-			for _, runningContainer := range runningContainers {
-				if runningContainer.Names[0] == "/"+container.Name {
-					log.Debugf("Container %s already exists\n", container.Name)
-					found = true
-					break
-				}
-			}
+func freezeContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !configuredContainer(name) {
+			http.Error(w, fmt.Sprintf("Container %s is not configured", name), http.StatusNotFound)
+			return
 		}
 
-		// Create container if not found
-		var created bool
-		if !found {
-			err, created = docker.CreateContainer(cli, container)
-			if err != nil {
-				return err
-			}
-			if created {
-				log.Infof("Container %s created", container.Name)
-			}
+		err := reconciler.Freeze(name)
+		if err != nil {
+			log.Errorf("Error freezing container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error freezing container %s: %v", name, err), http.StatusInternalServerError)
+			return
 		}
+		log.Infof("Container %s frozen", name)
+		fmt.Fprintf(w, "Container %s frozen\n", name)
+	}
+}
 
-		if !created {
-			err = ensureContainerConfig(cli, container)
-			if err != nil {
-				log.Fatalf("Error ensuring container configuration: %v", err)
-			}
+func rollbackContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		ctx, cancel := detach(audit.WithRequester(r.Context(), r.RemoteAddr))
+		defer cancel()
+
+		image, err := reconciler.Rollback(ctx, currentConfig(), name)
+		switch {
+		case errors.Is(err, reconcile.ErrNotConfigured):
+			http.Error(w, fmt.Sprintf("Container %s is not configured", name), http.StatusNotFound)
+			return
+		case errors.Is(err, reconcile.ErrNoPreviousImage):
+			http.Error(w, fmt.Sprintf("Container %s has no previous image to roll back to", name), http.StatusNotFound)
+			return
+		case errors.Is(err, reconcile.ErrReconcileInProgress):
+			http.Error(w, "Reconcile already in progress", http.StatusConflict)
+			return
+		case err != nil:
+			log.Errorf("Error rolling back container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error rolling back container %s: %v", name, err), http.StatusInternalServerError)
+			return
 		}
+		fmt.Fprintf(w, "Container %s rolled back to image %s\n", name, image)
+	}
+}
+
+func pinContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 
-		// Get cintainer ID from name
-		ctid, err := docker.GetContainerIDByName(cli, container.Name)
+		digest, err := reconciler.Pin(r.Context(), currentConfig(), name)
+		if errors.Is(err, reconcile.ErrNotConfigured) {
+			http.Error(w, fmt.Sprintf("Container %s is not configured", name), http.StatusNotFound)
+			return
+		}
 		if err != nil {
-			return err
+			log.Errorf("Error pinning container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error pinning container %s: %v", name, err), http.StatusInternalServerError)
+			return
 		}
+		fmt.Fprintf(w, "Container %s pinned to %s\n", name, digest)
+	}
+}
 
-		// Check if container is up to date
-		if updateCheck && !created {
-			upToDate, err := isContainerUpToDate(cli, ctid, container)
-			if err != nil {
-				return err
-			}
-			if !upToDate {
-				log.Infof("Container %v is not up to date, recreating ...\n", container.Name)
-				err = docker.DeleteContainer(cli, ctid)
-
-				if err != nil {
-					return err
-				}
-
-				err, _ := docker.CreateContainer(cli, container)
-				if err != nil {
-					return err
-				}
-
-				// Fetch new container ID
-				ctid, err = docker.GetContainerIDByName(cli, container.Name)
-				if err != nil {
-					return err
-				}
-			}
-		}
+func unpinContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 
-		// Ensure container is running
-		err = docker.EnsureRunningContainers(cli, ctid)
+		err := reconciler.Unpin(name)
 		if err != nil {
-			return err
+			log.Errorf("Error unpinning container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error unpinning container %s: %v", name, err), http.StatusInternalServerError)
+			return
 		}
-
-		log.Infof("Container %v ensured\n", container.Name)
+		fmt.Fprintf(w, "Container %s unpinned\n", name)
 	}
+}
 
-	return nil
+// containerAction starts, stops or restarts a configured container
+func containerAction(action string, run func(context.Context, *config.Config, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		ctx := audit.WithRequester(r.Context(), r.RemoteAddr)
+		if err := run(ctx, currentConfig(), name); err != nil {
+			writeReconcileError(w, r, err, fmt.Sprintf("%s container %s", action, name))
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, map[string]string{"container": name, "action": action})
+			return
+		}
+		fmt.Fprintf(w, "Container %s: %s\n", name, action)
+	}
 }
 
-func removeUnwantedContainers(cli *client.Client, configs []docker.ContainerConfig) error {
+func unfreezeContainer(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 
-	// get running containers
-	containers, err := docker.ListAllContariners(cli)
-	if err != nil {
-		return err
+		err := reconciler.Unfreeze(name)
+		if err != nil {
+			log.Errorf("Error unfreezing container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error unfreezing container %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Container %s unfrozen", name)
+		fmt.Fprintf(w, "Container %s unfrozen\n", name)
 	}
+}
 
-	// check if container is not specified in configs
-	for _, container := range containers {
-		found := false
-		for _, config := range configs {
-			if container.Names[0] == "/"+config.Name {
-				found = true
-				break
-			}
+// auditFilter reads the container, action, since and limit query parameters of r
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	filter := audit.Filter{
+		Container: r.URL.Query().Get("container"),
+		Action:    r.URL.Query().Get("action"),
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: %v", err)
 		}
-		if !found {
-			log.Infof("Container %s (%s) not desired, removing ...\n", container.Names[0], container.ID)
-			err = docker.DeleteContainer(cli, container.ID)
-			if err != nil {
-				return err
-			}
-			log.Debug("Container removed\n")
+		filter.Since = t
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return filter, fmt.Errorf("invalid limit: %v", err)
 		}
+		filter.Limit = n
 	}
-
-	return nil
+	return filter, nil
 }
 
-// Handler to update metrics and then serve Prometheus metrics
-func GenerateMetrics(dm *metrics.DockerMetrics, cli *client.Client) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// List all containers
-		containers, err := docker.ListAllContariners(cli)
+func queryAudit(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
 		if err != nil {
-			http.Error(w, "Could not list containers", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var wg sync.WaitGroup
-		statsChan := make(chan types.StatsJSON, len(containers))
-		errChan := make(chan error, len(containers))
-
-		// Fetch stats for each container concurrently
-		for _, container := range containers {
-			wg.Add(1)
-			go func(containerID string) {
-				defer wg.Done()
-				stats, err := cli.ContainerStats(context.Background(), containerID, false)
-				//cli.ContainerStatsOneShot(context.Background(), containerID)
-				if err != nil {
-					errChan <- fmt.Errorf("could not fetch stats for container %s: %v", containerID, err)
-					return
-				}
-				defer stats.Body.Close()
-
-				data, err := io.ReadAll(stats.Body)
-				if err != nil {
-					errChan <- fmt.Errorf("could not read stats for container %s: %v", containerID, err)
-				}
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			log.Errorf("Error reading audit log: %v", err)
+			http.Error(w, fmt.Sprintf("Error reading audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-				var statsJSON types.StatsJSON
-				err = json.Unmarshal(data, &statsJSON)
-				if err != nil {
-					errChan <- fmt.Errorf("could not unmarshal stats for container %s: %v", containerID, err)
-				}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
 
-				log.Infof("Updated metrics for container %s\n", containerID)
+// prefetchImages pulls the images of all configured containers, or of those named by the
+// container query parameter (repeatable), and reports the outcome per image as JSON
+func prefetchImages(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.WithRequester(r.Context(), r.RemoteAddr)
+		results, err := reconciler.Prefetch(ctx, currentConfig(), r.URL.Query()["container"])
+		if errors.Is(err, reconcile.ErrNotConfigured) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Errorf("Error prefetching images: %v", err)
+			http.Error(w, fmt.Sprintf("Error prefetching images: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-				statsChan <- statsJSON
-			}(container.ID)
+		w.Header().Set("Content-Type", "application/json")
+		for _, result := range results {
+			if result.Error != "" {
+				w.WriteHeader(http.StatusInternalServerError)
+				break
+			}
 		}
+		json.NewEncoder(w).Encode(results)
+	}
+}
 
-		// Wait for all goroutines to finish
-		go func() {
-			wg.Wait()
-			close(statsChan)
-			close(errChan)
-		}()
+// managerStatus is the reconciler status with the manager version
+type managerStatus struct {
+	reconcile.Status
+	Version version.Info `json:"version"`
+}
 
-		// Process results
-		for statsJSON := range statsChan {
-			dm.UpdateMetrics(statsJSON)
-		}
+func readStatus(ctx context.Context, reconciler *reconcile.Reconciler) (managerStatus, error) {
+	status, err := reconciler.Status(ctx, currentConfig())
+	return managerStatus{Status: status, Version: version.Get()}, err
+}
 
-		// Handle errors
-		if len(errChan) > 0 {
-			var errorMsgs []string
-			for err := range errChan {
-				errorMsgs = append(errorMsgs, err.Error())
-			}
-			http.Error(w, fmt.Sprintf("Errors occurred: %v", errorMsgs), http.StatusInternalServerError)
+func status(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := readStatus(r.Context(), reconciler)
+		if err != nil {
+			log.Errorf("Error reading status: %v", err)
+			http.Error(w, fmt.Sprintf("Error reading status: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Serve Prometheus metrics
-		promhttp.Handler().ServeHTTP(w, r)
-	})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
 }
 
-func reconcileContainers(cli *client.Client) http.HandlerFunc {
+func listContainers(reconciler *reconcile.Reconciler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		containers, err := reconciler.Containers(r.Context(), currentConfig())
+		if err != nil {
+			log.Errorf("Error listing containers: %v", err)
+			http.Error(w, fmt.Sprintf("Error listing containers: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(containers)
+	}
+}
 
-		containers, err := config.ConfigToDockerConfig(*cfg)
+// driftReport lists the containers whose live state differs from the config
+func driftReport(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		drift, err := reconciler.Drift(r.Context(), currentConfig())
 		if err != nil {
-			log.Fatalf("Error converting config to Docker config: %v", err)
+			log.Errorf("Error detecting drift: %v", err)
+			http.Error(w, fmt.Sprintf("Error detecting drift: %v", err), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drift)
+	}
+}
 
-		// Delete unwanted containers
-		if cfg.AppConfig.RemoveUnwantedContainers {
-			err = removeUnwantedContainers(cli, containers)
-			if err != nil {
-				log.Fatalf("Error when removing unwanted containers: %v", err)
-			}
+// planReport lists the changes the next reconcile would make
+func planReport(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changes, err := reconciler.Plan(r.Context(), currentConfig())
+		if err != nil {
+			log.Errorf("Error planning reconcile: %v", err)
+			http.Error(w, fmt.Sprintf("Error planning reconcile: %v", err), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}
+}
+
+func buildVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(version.Get())
+	}
+}
+
+func deferredActions(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reconciler.Deferred())
+	}
+}
+
+func containerImage(store *state.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 
-		// Create containers and ensure they are up to date
-		err = ensureContainers(cli, containers, cfg.AppConfig.UpdateCheck)
+		record, err := store.Image(name)
 		if err != nil {
-			log.Fatalf("Error ensuring containers: %v", err)
+			log.Errorf("Error reading image of container %s: %v", name, err)
+			http.Error(w, fmt.Sprintf("Error reading image of container %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		if record == nil {
+			http.Error(w, fmt.Sprintf("No image recorded for container %s", name), http.StatusNotFound)
+			return
 		}
 
-		fmt.Fprint(w, "Containers reconciled\n")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
 	}
 }
 
@@ -402,25 +524,171 @@ func reloadConfig() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := updateConfig()
 		if err != nil {
-			log.Fatalf("Error reloading config: %v", err)
+			log.Errorf("Error reloading config: %v", err)
+			http.Error(w, fmt.Sprintf("Error reloading config: %v", err), http.StatusInternalServerError)
+			return
 		}
 		fmt.Fprint(w, "Config reloaded\n")
 	}
 }
 
-func init() {
-	// read config
-	err := updateConfig()
+// Exit codes of the commands, apply --once tells CI whether it changed anything
+const (
+	// exitOK means nothing had to change
+	exitOK = iota
+	// exitError means the command failed: the reconcile could not run (e.g. because it is
+	// paused), a container failed, or it had to change something with --fail-on-drift
+	exitError
+	// exitChanged means containers were changed, or changes deferred, to match the config
+	exitChanged
+)
+
+// runOnce reconciles a single time, prints the report and returns the exit code. With
+// failOnDrift any change the host needed is an error.
+func runOnce(reconciler *reconcile.Reconciler, failOnDrift bool) int {
+	ctx := audit.WithRequester(context.Background(), "once")
+	report, err := reconciler.Reconcile(ctx, currentConfig())
 	if err != nil {
-		log.Fatalf("Error reading config: %v", err)
+		log.Errorf("Error reconciling containers: %v", err)
+		return exitError
+	}
+
+	fmt.Print(report.String())
+	if len(report.Failed()) > 0 {
+		log.Errorf("Reconcile finished with errors: %v", report.Err())
+		return exitError
 	}
+	drifted := len(report.Changed()) + len(report.Deferred())
+	switch {
+	case drifted > 0 && failOnDrift:
+		log.Errorf("%d containers drifted from the config", drifted)
+		return exitError
+	case drifted > 0:
+		return exitChanged
+	}
+	return exitOK
 }
 
-func main() {
+// runRollback rolls a container back to its previous image and returns the exit code
+func runRollback(reconciler *reconcile.Reconciler, name string) int {
+	ctx := audit.WithRequester(context.Background(), "cli")
+	image, err := reconciler.Rollback(ctx, currentConfig(), name)
+	if err != nil {
+		log.Errorf("Error rolling back container %s: %v", name, err)
+		return exitError
+	}
+	fmt.Printf("Container %s rolled back to image %s\n", name, image)
+	return exitOK
+}
+
+// reconcileLoop reconciles every reconcile_interval of the current config, so config reloads
+// change the interval. Without an interval it waits for one to be configured.
+func reconcileLoop(ctx context.Context, reconciler *reconcile.Reconciler) {
+	ctx = audit.WithRequester(ctx, "interval")
+	for {
+		interval := currentConfig().AppConfig.ReconcileInterval
+		wait := interval
+		if wait <= 0 {
+			wait = time.Minute
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if interval <= 0 {
+			continue
+		}
+
+		reconcileCtx, cancel := detach(ctx)
+		report, err := reconciler.Reconcile(reconcileCtx, currentConfig())
+		cancel()
+		switch {
+		case errors.Is(err, reconcile.ErrPaused), errors.Is(err, reconcile.ErrReconcileInProgress):
+			log.Debugf("Skipping periodic reconcile: %v", err)
+		case err != nil:
+			log.Errorf("Error reconciling containers: %v", err)
+		case len(report.Failed()) > 0:
+			log.Errorf("Periodic reconcile finished with errors: %v", report.Err())
+		}
+	}
+}
+
+// loadConfig reads and applies the config file, for commands working on the local host
+func loadConfig() error {
+	if err := updateConfig(); err != nil {
+		return err
+	}
 	// if debug is enabled, set log level to debug
 	if cfg.AppConfig.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	return nil
+}
+
+// manager is what commands reconciling the local Docker host share
+type manager struct {
+	cli        *client.Client
+	store      *state.Store
+	auditLog   *audit.Log
+	bus        *events.Bus
+	reconciler *reconcile.Reconciler
+}
+
+// openManager opens the state store and audit log of the loaded config and creates the
+// reconciler, managerMetrics may be nil
+func openManager(cli *client.Client, managerMetrics *metrics.ManagerMetrics) (*manager, error) {
+	// open state store
+	store, err := state.Open(filepath.Join(cfg.AppConfig.StateDir, "state.db"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening state store: %v", err)
+	}
+
+	// open audit log
+	auditLog, err := audit.Open(filepath.Join(cfg.AppConfig.StateDir, "audit.log"))
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+
+	bus := events.NewBus()
+	reconciler, err := reconcile.New(cli, reconcile.Options{
+		StateDir: cfg.AppConfig.StateDir,
+		Metrics:  managerMetrics,
+		State:    store,
+		Audit:    auditLog,
+		Registry: registry.New(),
+		Events:   bus,
+	})
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("error creating reconciler: %v", err)
+	}
+	if reconciler.Paused() {
+		log.Warn("Reconciliation is paused, POST /resume to resume it")
+	}
+	return &manager{cli: cli, store: store, auditLog: auditLog, bus: bus, reconciler: reconciler}, nil
+}
+
+// localManager loads the config and opens a manager without metrics, for one-shot commands
+func localManager() (*manager, error) {
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+	cli, err := docker.CreateClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %v", err)
+	}
+	return openManager(cli, nil)
+}
+
+// serve runs the manager: it serves the API, reconciles every reconcile_interval and heals
+// containers until SIGINT or SIGTERM
+func serve() {
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
 
 	// Create client
 	cli, err := docker.CreateClient()
@@ -428,13 +696,159 @@ func main() {
 		log.Fatalf("Error creating Docker client: %v", err)
 	}
 
-	// init metrics
-	metrics := metrics.NewDockerMetrics()
+	// init metrics, container stats are gathered in the background and cached for scrapes
+	promRegistry, err := metrics.NewRegistry(cfg.AppConfig.Metrics.RuntimeMetrics)
+	if err != nil {
+		log.Fatalf("Error creating metrics registry: %v", err)
+	}
+	reg := metrics.Wrap(promRegistry, cfg.AppConfig.Metrics.Namespace, cfg.AppConfig.Metrics.ConstLabels)
+	dockerCollector := metrics.NewDockerCollector(cli)
+	diskUsageCollector := metrics.NewDiskUsageCollector(cli)
+	if err := metrics.Register(reg, dockerCollector, diskUsageCollector, metrics.NewDaemonCollector(cli)); err != nil {
+		log.Fatalf("Error initializing metrics: %v", err)
+	}
+	managerMetrics, err := metrics.NewManagerMetrics(reg)
+	if err != nil {
+		log.Fatalf("Error initializing metrics: %v", err)
+	}
+	if err := metrics.RegisterBuildInfo(reg, version.Get()); err != nil {
+		log.Fatalf("Error initializing metrics: %v", err)
+	}
+
+	m, err := openManager(cli, managerMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer m.store.Close()
+	store, auditLog, bus, reconciler := m.store, m.auditLog, m.bus, m.reconciler
+
+	// stop background work on SIGINT and SIGTERM, then shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// restart managed containers that exit unexpectedly
+	go reconciler.AutoHeal(ctx, currentConfig)
+
+	// gather container stats for /metrics
+	go dockerCollector.Run(ctx, func() time.Duration {
+		return currentConfig().AppConfig.StatsInterval
+	})
+	go diskUsageCollector.Run(ctx, func() time.Duration {
+		return currentConfig().AppConfig.DiskUsageInterval
+	})
+
+	// push metrics for managers that can't be scraped
+	if push := cfg.AppConfig.Metrics.Push; push.URL != "" {
+		go metrics.RunPush(ctx, promRegistry, push.URL, push.Job, push.Interval)
+	}
+	stopOTLP := func(context.Context) error { return nil }
+	if otlp := cfg.AppConfig.Metrics.OTLP; otlp.Endpoint != "" {
+		if stopOTLP, err = metrics.StartOTLP(ctx, promRegistry, otlp.Endpoint, otlp.Headers, otlp.Interval); err != nil {
+			log.Fatalf("Error starting OTLP export: %v", err)
+		}
+	}
+
+	// reconcile periodically, update checks run at their own interval
+	go reconcileLoop(ctx, reconciler)
+
+	// profiles for diagnosing leaks in long-running managers
+	if cfg.AppConfig.Pprof.Enabled {
+		go servePprof(cfg.AppConfig.Pprof)
+	}
+
+	// Expose metrics via HTTP, on their own listener if configured
+	mux := http.NewServeMux()
+	metricsHandler := promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{})
+	if cfg.AppConfig.Metrics.ListenAddress != "" {
+		go serveMetrics(cfg.AppConfig.Metrics, metricsHandler, cfg.AppConfig.StateDir)
+	} else {
+		mux.Handle("/metrics", requireToken(roleRead, metricsHandler))
+	}
+	mux.Handle("/update", requireToken(roleAdmin, rateLimit(reconcileContainers(reconciler))))
+	mux.Handle("GET /update/stream", requireToken(roleAdmin, rateLimit(streamReconcile(reconciler))))
+	mux.Handle("POST /pause", requireToken(roleAdmin, pauseReconcile(reconciler)))
+	mux.Handle("POST /resume", requireToken(roleAdmin, resumeReconcile(reconciler)))
+	mux.Handle("POST /containers/{name}/freeze", requireToken(roleAdmin, freezeContainer(reconciler)))
+	mux.Handle("POST /containers/{name}/unfreeze", requireToken(roleAdmin, unfreezeContainer(reconciler)))
+	mux.Handle("POST /containers/{name}/rollback", requireToken(roleAdmin, rollbackContainer(reconciler)))
+	mux.Handle("POST /containers/{name}/pin", requireToken(roleAdmin, pinContainer(reconciler)))
+	mux.Handle("POST /containers/{name}/unpin", requireToken(roleAdmin, unpinContainer(reconciler)))
+	mux.Handle("GET /containers", requireToken(roleRead, listContainers(reconciler)))
+	mux.Handle("GET /drift", requireToken(roleRead, driftReport(reconciler)))
+	mux.Handle("GET /plan", requireToken(roleRead, planReport(reconciler)))
+	mux.Handle("GET /containers/{name}/logs", requireToken(roleRead, containerLogs(reconciler)))
+	mux.Handle("POST /containers/{name}/start", requireToken(roleAdmin, containerAction("start", reconciler.Start)))
+	mux.Handle("POST /containers/{name}/stop", requireToken(roleAdmin, containerAction("stop", reconciler.Stop)))
+	mux.Handle("POST /containers/{name}/restart", requireToken(roleAdmin, containerAction("restart", reconciler.Restart)))
+	mux.Handle("POST /containers/{name}/exec", requireToken(roleAdmin, requireAdminTokens(execContainer(reconciler))))
+	mux.Handle("GET /containers/{name}/exec", requireToken(roleAdmin, requireAdminTokens(execSession(reconciler))))
+	mux.Handle("GET /containers/{name}/image", requireToken(roleRead, containerImage(store)))
+	mux.Handle("GET /status", requireToken(roleRead, status(reconciler)))
+	mux.Handle("POST /images/prefetch", requireToken(roleAdmin, rateLimit(prefetchImages(reconciler))))
+	mux.Handle("GET /events", requireToken(roleRead, streamEvents(bus)))
+	mux.Handle("GET /deferred", requireToken(roleRead, deferredActions(reconciler)))
+	mux.Handle("GET /audit", requireToken(roleRead, queryAudit(auditLog)))
+	mux.Handle("/reload", requireToken(roleAdmin, rateLimit(reloadConfig())))
+	mux.Handle("GET /config", requireToken(roleAdmin, getConfig()))
+	// webhooks are authenticated by their signature, senders can't add API tokens
+	mux.Handle("POST /webhooks/{source}", rateLimit(inboundWebhook(reconciler)))
+	mux.Handle("PUT /config", requireToken(roleAdmin, rateLimit(putConfig(auditLog))))
+	mux.Handle("GET /version", buildVersion())
+	mux.Handle("GET /healthz", healthz())
+	mux.Handle("GET /readyz", readyz(cli))
+
+	// versioned JSON API, the plaintext routes above are kept for existing scripts
+	registerAPI(mux, reconciler, store, auditLog, bus)
+	basePath := normalizeBasePath(cfg.AppConfig.BasePath)
+	registerOpenAPI(mux, basePath)
+
+	// Streams such as /events end when the shutdown starts, reconciles are detached from it
+	server := &http.Server{
+		Addr:        listenAddress(),
+		Handler:     cors(withBasePath(basePath, mux)),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	// the socket has its own server as TLS is only configured for the TCP listener
+	socketServer := &http.Server{Handler: server.Handler, BaseContext: server.BaseContext}
+	socket := cfg.AppConfig.UnixSocket
+	if socket.Path != "" {
+		listener, err := listenUnix(socket)
+		if err != nil {
+			log.Fatalf("Error listening on unix socket %s: %v", socket.Path, err)
+		}
+		go func() {
+			log.Infof("Beginning to serve on unix socket %s", socket.Path)
+			if err := socketServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error serving API on unix socket: %v", err)
+			}
+		}()
+	} else if socket.DisableTCP {
+		log.Fatalf("app_config.unix_socket.disable_tcp needs a socket path, the API would not be served")
+	}
+	if !socket.DisableTCP {
+		go func() {
+			log.Infof("Beginning to serve on %s", server.Addr)
+			if err := listen(server, cfg.AppConfig.TLS, cfg.AppConfig.StateDir); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error serving API: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop()
+	shutdown(reconciler, server, socketServer)
+
+	// export the metrics of the last interval, including those of the final reconcile
+	otlpCtx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+	defer cancel()
+	if err := stopOTLP(otlpCtx); err != nil {
+		log.Warnf("Error flushing OTLP metrics: %v", err)
+	}
+	log.Info("Shut down")
+}
 
-	// Expose metrics via HTTP
-	http.Handle("/metrics", GenerateMetrics(metrics, cli))
-	http.Handle("/update", reconcileContainers(cli))
-	http.Handle("/reload", reloadConfig())
-	fmt.Println("Beginning to serve on port :8082")
-	http.ListenAndServe(":8082", nil)
+// listenAddress returns the API address from the --listen flag, $DOCKER_MANAGER_LISTEN_ADDRESS
+// or app_config.listen_address, in that order
+func listenAddress() string {
+	return cmp.Or(listenFlag, os.Getenv("DOCKER_MANAGER_LISTEN_ADDRESS"), cfg.AppConfig.ListenAddress, defaultListenAddress)
 }
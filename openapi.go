@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec describes the /api/v1 routes, keep it in sync with registerAPI
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// openAPIJSON converts the spec to JSON, for clients that don't read YAML
+func openAPIJSON(data []byte) ([]byte, error) {
+	var spec map[string]any
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return json.Marshal(spec)
+}
+
+// swaggerUI loads Swagger UI from a CDN and points it at the spec
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+  <title>docker-manager API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// registerOpenAPI serves the spec without a token, it only describes the API. Swagger UI is
+// served on /api/v1/docs when app_config.api.swagger_ui is enabled. The server URL of the
+// spec includes basePath.
+func registerOpenAPI(mux *http.ServeMux, basePath string) {
+	spec := bytes.Replace(openAPISpec, []byte("url: "+apiPrefix+"\n"), []byte("url: "+basePath+apiPrefix+"\n"), 1)
+	specJSON, specErr := openAPIJSON(spec)
+
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(spec)
+	})
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if err := specErr; err != nil {
+			log.Errorf("Error converting OpenAPI spec: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error converting OpenAPI spec: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(specJSON)
+	})
+	mux.HandleFunc("GET "+apiPrefix+"/docs", func(w http.ResponseWriter, r *http.Request) {
+		if !currentConfig().AppConfig.API.SwaggerUI {
+			writeError(w, http.StatusNotFound, codeNotFound, "Swagger UI is disabled, enable app_config.api.swagger_ui")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, swaggerUI, basePath+apiPrefix+"/openapi.json")
+	})
+}
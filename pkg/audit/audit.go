@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreate   = "create"
+	ActionRecreate = "recreate"
+	ActionUpdate   = "update"
+	ActionRemove   = "remove"
+	ActionStart    = "start"
+	ActionStop     = "stop"
+	ActionRestart  = "restart"
+	ActionPull     = "pull"
+	ActionRollback = "rollback"
+	ActionAdopt    = "adopt"
+	ActionExec     = "exec"
+	// ActionUpdateConfig is a config submitted through the API
+	ActionUpdateConfig = "update_config"
+
+	ActionCreateNetwork = "create_network"
+	ActionRemoveNetwork = "remove_network"
+	ActionCreateVolume  = "create_volume"
+	ActionRemoveVolume  = "remove_volume"
+	ActionRemoveImage   = "remove_image"
+)
+
+// Entry is a single mutating action performed by the manager
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Container string    `json:"container,omitempty"`
+	// Resource is the network or volume acted on by network and volume actions
+	Resource  string `json:"resource,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	OldImage  string `json:"old_image,omitempty"`
+	NewImage  string `json:"new_image,omitempty"`
+	Requester string `json:"requester,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Filter selects entries when querying the log
+type Filter struct {
+	Container string
+	Action    string
+	Since     time.Time
+	// Limit returns at most the newest Limit entries, 0 returns all
+	Limit int
+}
+
+// Log is an append-only audit log stored as JSON lines
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open prepares the audit log at path, creating its directory if needed
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return &Log{path: path}, nil
+}
+
+// Record appends an entry to the log
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Query returns the entries matching filter, oldest first
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		if filter.Container != "" && entry.Container != filter.Container {
+			continue
+		}
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+type requesterKey struct{}
+
+// WithRequester returns a context carrying who requested the actions performed with it
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// Requester returns the requester stored in ctx, or "system" for actions the manager started itself
+func Requester(ctx context.Context) string {
+	if requester, ok := ctx.Value(requesterKey{}).(string); ok && requester != "" {
+		return requester
+	}
+	return "system"
+}
@@ -1,14 +1,343 @@
 package config
 
+import "time"
+
 type Config struct {
-	AppConfig  AppConfig         `yaml:"app_config"`
-	Containers []ContainerConfig `yaml:"containers"`
+	AppConfig  AppConfig              `yaml:"app_config"`
+	Containers []ContainerConfig      `yaml:"containers"`
+	Groups     map[string]GroupConfig `yaml:"groups"`
+	// Registries holds credentials per registry host, such as ghcr.io or docker.io
+	Registries map[string]RegistryConfig `yaml:"registries"`
+}
+
+// RegistryConfig authenticates to a registry with a username and password (or password file),
+// or with a token, and optionally redirects pulls to a mirror
+type RegistryConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"token_file"`
+	// Mirror is a pull-through mirror used instead of the registry, http:// mirrors are insecure
+	Mirror string `yaml:"mirror"`
+	// Insecure accesses the registry over plain HTTP
+	Insecure bool `yaml:"insecure"`
+	// Verify requires signatures on images from this registry, unless a container configures its own
+	Verify VerifyConfig `yaml:"verify"`
+}
+
+// VerifyConfig requires cosign signatures on image updates, made with a public key or keyless
+// with a certificate identity and OIDC issuer
+type VerifyConfig struct {
+	Key      string `yaml:"key"`
+	Identity string `yaml:"identity"`
+	Issuer   string `yaml:"issuer"`
+}
+
+// MetricsConfig is read at startup, changing it requires a restart
+type MetricsConfig struct {
+	// Namespace prefixes all metric names, e.g. prod turns docker_cpu_usage_total into prod_docker_cpu_usage_total
+	Namespace string `yaml:"namespace"`
+	// ConstLabels are added to all series, e.g. host or environment
+	ConstLabels map[string]string `yaml:"const_labels"`
+	// RuntimeMetrics adds the Go runtime (go_*) and process (process_*) metrics of the manager
+	RuntimeMetrics bool `yaml:"runtime_metrics"`
+	// Push pushes metrics to a Prometheus Pushgateway, for managers that can't be scraped
+	Push PushConfig `yaml:"push"`
+	// OTLP exports metrics to an OpenTelemetry collector
+	OTLP OTLPConfig `yaml:"otlp"`
+	// ListenAddress serves /metrics on its own address such as :9090 instead of the API's,
+	// optionally with BasicAuth and TLS
+	ListenAddress string          `yaml:"listen_address"`
+	BasicAuth     BasicAuthConfig `yaml:"basic_auth"`
+	TLS           TLSConfig       `yaml:"tls"`
+}
+
+// APIConfig requires tokens for the API when any are configured, without tokens anyone
+// reaching the API can use it. Tokens may use every endpoint, read-only tokens only those
+// that don't change anything.
+type APIConfig struct {
+	Tokens []string `yaml:"tokens"`
+	// TokenFile holds more tokens, one per line
+	TokenFile      string   `yaml:"token_file"`
+	ReadOnlyTokens []string `yaml:"read_only_tokens"`
+	// ReadOnlyTokenFile holds more read-only tokens, one per line
+	ReadOnlyTokenFile string `yaml:"read_only_token_file"`
+	// SwaggerUI serves Swagger UI for the OpenAPI spec on /api/v1/docs
+	SwaggerUI bool       `yaml:"swagger_ui"`
+	CORS      CORSConfig `yaml:"cors"`
+	// RateLimit limits reconciles, reloads and prefetches per client
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RateLimitConfig is a token bucket per client, a rate of 0 means unlimited
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// CORSConfig lets browser-based dashboards on AllowedOrigins call the API, cross-origin
+// requests are refused by browsers when no origin is allowed
+type CORSConfig struct {
+	// AllowedOrigins are origins such as https://dashboard.example.com, "*" allows any
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods are GET and POST by default
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders are Authorization, X-API-Key and Content-Type by default
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// UnixSocketConfig serves the API over plain HTTP on a unix socket at Path
+type UnixSocketConfig struct {
+	Path string `yaml:"path"`
+	// Mode is the octal file mode of the socket, 0660 by default
+	Mode string `yaml:"mode"`
+	// DisableTCP only serves the socket, for hosts that must not expose any port
+	DisableTCP bool `yaml:"disable_tcp"`
+}
+
+// PprofConfig serves net/http/pprof and expvar when Enabled, guarded by the admin API tokens
+type PprofConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ListenAddress is 127.0.0.1:6060 by default, so profiles are only reachable from the host
+	ListenAddress string `yaml:"listen_address"`
+}
+
+// BasicAuthConfig requires credentials when Username is set
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// TLSConfig serves HTTPS when Cert is set or SelfSigned is enabled
+type TLSConfig struct {
+	// Cert and Key are PEM files
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// SelfSigned generates a certificate on first start and keeps it in the state directory
+	SelfSigned bool `yaml:"self_signed"`
+}
+
+// OTLPConfig enables the OTLP/HTTP exporter when Endpoint is set
+type OTLPConfig struct {
+	// Endpoint is the collector URL, e.g. http://otel-collector:4318
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string `yaml:"headers"`
+	// Interval between exports, 1m by default
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PushConfig enables pushing metrics when URL is set
+type PushConfig struct {
+	URL string `yaml:"url"`
+	// Job is the job label of the pushed metrics, docker_manager by default
+	Job string `yaml:"job"`
+	// Interval between pushes, 1m by default
+	Interval time.Duration `yaml:"interval"`
+}
+
+// NotificationsConfig posts events such as refused updates to webhooks
+type NotificationsConfig struct {
+	Webhooks []string `yaml:"webhooks"`
+}
+
+// InboundWebhookConfig verifies the HMAC-SHA256 signature of a webhook source
+type InboundWebhookConfig struct {
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	// SignatureHeader holds the hex signature, optionally prefixed with sha256=,
+	// X-Hub-Signature-256 by default
+	SignatureHeader string `yaml:"signature_header"`
+	// TimestampHeader holds the unix time the request was signed at, "<timestamp>.<body>" is
+	// signed then and older requests are rejected
+	TimestampHeader string `yaml:"timestamp_header"`
+	// MaxAge is how old a timestamp may be and how long signatures are remembered to reject
+	// replays, 5m by default. Without TimestampHeader nothing bounds the age of a request, a
+	// captured one is accepted again after MaxAge.
+	MaxAge time.Duration `yaml:"max_age"`
+	// Reload reloads the config before reconciling, e.g. after a GitOps push
+	Reload bool `yaml:"reload"`
+}
+
+// GroupConfig configures rolling updates for containers sharing a group
+type GroupConfig struct {
+	MaxUnavailable int `yaml:"max_unavailable"`
 }
 
 type AppConfig struct {
-	Debug                    bool `yaml:"debug"`
-	UpdateCheck              bool `yaml:"update_check"`
-	RemoveUnwantedContainers bool `yaml:"remove_unwanted_containers"`
+	Debug bool `yaml:"debug"`
+	// ListenAddress is the address the API is served on, :8082 by default. Use e.g.
+	// 127.0.0.1:8082 to only serve on localhost. Read at startup.
+	ListenAddress string `yaml:"listen_address"`
+	// TLS serves the API over HTTPS, read at startup
+	TLS TLSConfig `yaml:"tls"`
+	// BasePath serves every endpoint under a prefix such as /docker-manager, for reverse
+	// proxies routing by path. Read at startup.
+	BasePath string `yaml:"base_path"`
+	// UnixSocket serves the API on a unix socket as well, read at startup
+	UnixSocket UnixSocketConfig `yaml:"unix_socket"`
+	// API requires tokens for the API
+	API APIConfig `yaml:"api"`
+	// Pprof serves runtime profiles on a separate listener, read at startup
+	Pprof       PprofConfig `yaml:"pprof"`
+	UpdateCheck bool        `yaml:"update_check"`
+	// ReconcileInterval reconciles periodically, 0 only reconciles when /update is called
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+	// UpdateCheckInterval is the minimum time between update checks of a container, 0 checks on every reconcile
+	UpdateCheckInterval time.Duration `yaml:"update_check_interval"`
+	// UpdateMode is auto (default) to apply updates or notify to only report them
+	UpdateMode string `yaml:"update_mode"`
+	// Platform such as linux/arm64 images are pulled, run and checked for updates for, the host's by default
+	Platform                 string      `yaml:"platform"`
+	RemoveUnwantedContainers bool        `yaml:"remove_unwanted_containers"`
+	Retry                    RetryConfig `yaml:"retry"`
+	// ShutdownTimeout is how long SIGTERM waits for requests and a running reconcile to finish
+	// before cancelling them, 30s by default
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ConcurrentReconcile decides what happens when a reconcile is requested while one is running
+	ConcurrentReconcile string `yaml:"concurrent_reconcile"`
+	// StateDir holds state that must survive restarts, such as the pause flag
+	StateDir string `yaml:"state_dir"`
+	// ManageAllContainers lets the manager modify and remove containers without its ownership label
+	ManageAllContainers bool `yaml:"manage_all_containers"`
+	// ProtectedContainers are names or regexes of containers that are never removed or recreated
+	ProtectedContainers []string       `yaml:"protected_containers"`
+	AutoHeal            AutoHealConfig `yaml:"auto_heal"`
+	// GarbageCollection removes networks and volumes the manager created once nothing uses them
+	GarbageCollection GarbageCollectionConfig `yaml:"garbage_collection"`
+	// KeepPreviousImages is how many images each container ran before are kept locally for rollbacks
+	KeepPreviousImages int `yaml:"keep_previous_images"`
+	// ImageGC removes images superseded by updates
+	ImageGC ImageGCConfig `yaml:"image_gc"`
+	// PullLimits protects registries from being hammered when many containers are reconciled
+	PullLimits PullLimitsConfig `yaml:"pull_limits"`
+	// AdoptExisting takes ownership of unlabeled containers that match their config instead of failing
+	AdoptExisting bool                `yaml:"adopt_existing"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	// InboundWebhooks are the sources, such as a registry or GitOps tool, that may trigger a
+	// reconcile on /webhooks/<source>
+	InboundWebhooks map[string]InboundWebhookConfig `yaml:"inbound_webhooks"`
+	// CosignPath is the cosign binary used to verify signatures, cosign in PATH by default
+	CosignPath string `yaml:"cosign_path"`
+	// VulnerabilityScan scans new images and refuses updates with severe vulnerabilities
+	VulnerabilityScan VulnerabilityScanConfig `yaml:"vulnerability_scan"`
+	// SBOM records a software bill of materials of every deployed image
+	SBOM SBOMConfig `yaml:"sbom"`
+	// UpdateSchedule is a cron expression of the minutes update checks may run in, e.g. "* 3-4 * * sun"
+	UpdateSchedule string `yaml:"update_schedule"`
+	// StatsInterval is how often container stats are gathered for /metrics, 15s by default
+	StatsInterval time.Duration `yaml:"stats_interval"`
+	// OneShotStats reads stats without waiting for a second CPU sample, CPU usage is computed
+	// against the previous collection instead
+	OneShotStats bool `yaml:"oneshot_stats"`
+	// DiskUsageInterval is how often docker system df data is gathered for /metrics, 5m by default
+	DiskUsageInterval time.Duration `yaml:"disk_usage_interval"`
+	// AggregateNetworkMetrics keeps exporting the network totals over all interfaces
+	// (docker_network_rx_bytes_total/docker_network_tx_bytes_total) next to the per-interface metrics, true by default
+	AggregateNetworkMetrics *bool `yaml:"aggregate_network_metrics"`
+	// LegacyGaugeMetrics keeps exporting cumulative network and block IO stats under their
+	// former gauge names, e.g. docker_network_rx_bytes next to docker_network_rx_bytes_total
+	LegacyGaugeMetrics bool `yaml:"legacy_gauge_metrics"`
+	// PerCPUMetrics exports the CPU usage of every core, a series per core and container
+	PerCPUMetrics bool `yaml:"per_cpu_metrics"`
+	// DisabledMetrics are metric families not exported: cpu, memory, network, blkio, pids,
+	// state, image, disk_usage or daemon
+	DisabledMetrics []string `yaml:"disabled_metrics"`
+	// Metrics sets the namespace and constant labels of all metrics
+	Metrics MetricsConfig `yaml:"metrics"`
+	// MaintenanceWindows are cron expressions of the minutes recreations, updates and removals
+	// may run in, outside them they are deferred. Without windows they may run any time.
+	MaintenanceWindows []string `yaml:"maintenance_windows"`
+}
+
+// SBOMConfig configures generating SBOMs of deployed images
+type SBOMConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Generator is syft (default) or trivy
+	Generator string `yaml:"generator"`
+	// Path is the generator binary, the generator's name in PATH by default
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// VulnerabilityScanConfig configures the scanner that gates image updates
+type VulnerabilityScanConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Scanner is trivy (default) or grype
+	Scanner string `yaml:"scanner"`
+	// Path is the scanner binary, the scanner's name in PATH by default
+	Path string `yaml:"path"`
+	// Severity is the lowest severity that blocks an update: low, medium, high (default) or critical
+	Severity string        `yaml:"severity"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// ContainerScanConfig overrides the vulnerability scan for a single container
+type ContainerScanConfig struct {
+	// Skip rolls out updates without scanning them
+	Skip     bool   `yaml:"skip"`
+	Severity string `yaml:"severity"`
+}
+
+// ImageGCConfig controls removing superseded and dangling images
+type ImageGCConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	PruneDangling bool          `yaml:"prune_dangling"`
+	PruneInterval time.Duration `yaml:"prune_interval"`
+}
+
+// PullLimitsConfig caps concurrent pulls and rate limits registry requests, 0 means unlimited
+type PullLimitsConfig struct {
+	MaxConcurrent     int     `yaml:"max_concurrent"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// GarbageCollectionConfig controls removing unused manager-created networks and volumes
+type GarbageCollectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is how long a network or volume must stay unused before it is removed
+	Retention time.Duration `yaml:"retention"`
+}
+
+// AutoHealConfig controls restarting managed containers that exit unexpectedly
+type AutoHealConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// ResetAfter resets the backoff once a container stayed up this long
+	ResetAfter time.Duration   `yaml:"reset_after"`
+	Unhealthy  UnhealthyConfig `yaml:"unhealthy"`
+}
+
+// UnhealthyConfig controls healing containers whose healthcheck reports unhealthy
+type UnhealthyConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Action is restart (default) or recreate
+	Action string `yaml:"action"`
+}
+
+const (
+	UnhealthyActionRestart  = "restart"
+	UnhealthyActionRecreate = "recreate"
+)
+
+const (
+	// ConcurrentReconcileReject fails the new request (default)
+	ConcurrentReconcileReject = "reject"
+	// ConcurrentReconcileQueue waits for the running reconcile to finish
+	ConcurrentReconcileQueue = "queue"
+)
+
+type RetryConfig struct {
+	Attempts       int           `yaml:"attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
 }
 
 type ContainerConfig struct {
@@ -17,8 +346,118 @@ type ContainerConfig struct {
 	PortBindings []PortBinding `yaml:"port_bindings"`
 	Env          []string      `yaml:"env"`
 	Cmd          []string      `yaml:"cmd"`
+	Frozen       bool          `yaml:"frozen"`
+	// Strategy is recreate (default), blue-green or rename
+	Strategy string       `yaml:"strategy"`
+	Canary   CanaryConfig `yaml:"canary"`
+	// Group puts the container in a rolling update group
+	Group string `yaml:"group"`
+	// WaitHealthy blocks the reconcile until a new container reports healthy
+	WaitHealthy   bool          `yaml:"wait_healthy"`
+	HealthTimeout time.Duration `yaml:"health_timeout"`
+	Hooks         HooksConfig   `yaml:"hooks"`
+	// Replicas runs N containers named <name>-1..<name>-N, host ports may be templated
+	Replicas  int             `yaml:"replicas"`
+	Resources ResourcesConfig `yaml:"resources"`
+	// DriftStrategy is recreate (default) or in-place, which applies resource-only drift without a recreate
+	DriftStrategy string `yaml:"drift_strategy"`
+	// Networks are created by the manager if missing, the first one is the container's network mode
+	Networks []string       `yaml:"networks"`
+	Volumes  []VolumeConfig `yaml:"volumes"`
+	// PinDigest creates the container from the digest the image tag resolves to
+	PinDigest bool `yaml:"pin_digest"`
+	// UpdatePolicy is patch, minor or major to move to newer version tags, or pinned to skip update checks
+	UpdatePolicy string `yaml:"update_policy"`
+	// TagFilter is a regex, the container tracks the newest tag matching it
+	TagFilter string `yaml:"tag_filter"`
+	// PullPolicy is if-not-present (default), always or never
+	PullPolicy string `yaml:"pull_policy"`
+	// Verify requires the signatures of new images to verify before they are rolled out
+	Verify VerifyConfig `yaml:"verify"`
+	// VulnerabilityScan overrides the vulnerability scan of new images
+	VulnerabilityScan ContainerScanConfig `yaml:"vulnerability_scan"`
+	// UpdateSchedule overrides the update schedule of the app config
+	UpdateSchedule string `yaml:"update_schedule"`
+	// UpdateCheck overrides update_check of the app config, e.g. to exclude stateful services
+	UpdateCheck *bool `yaml:"update_check"`
+	// UpdateCheckInterval overrides update_check_interval of the app config
+	UpdateCheckInterval time.Duration `yaml:"update_check_interval"`
+	// UpdateMode overrides update_mode of the app config
+	UpdateMode string `yaml:"update_mode"`
+	// Platform overrides platform of the app config
+	Platform string `yaml:"platform"`
+}
+
+const (
+	// UpdateModeAuto applies image updates
+	UpdateModeAuto = "auto"
+	// UpdateModeNotify reports image updates through /status, metrics and notifications without applying them
+	UpdateModeNotify = "notify"
+)
+
+const (
+	// UpdatePolicyPatch moves to newer patch versions of the configured major.minor
+	UpdatePolicyPatch = "patch"
+	// UpdatePolicyMinor moves to newer minor and patch versions of the configured major
+	UpdatePolicyMinor = "minor"
+	// UpdatePolicyMajor moves to any newer version
+	UpdatePolicyMajor = "major"
+	// UpdatePolicyPinned never updates the image
+	UpdatePolicyPinned = "pinned"
+)
+
+const (
+	// PullPolicyAlways pulls the image before every create and on update checks
+	PullPolicyAlways = "always"
+	// PullPolicyIfNotPresent pulls missing images before a create and on update checks
+	PullPolicyIfNotPresent = "if-not-present"
+	// PullPolicyNever never pulls, update checks compare against the local image of the tag
+	PullPolicyNever = "never"
+)
+
+// VolumeConfig mounts a named volume, or a host path when Source is absolute
+type VolumeConfig struct {
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only"`
 }
 
+type ResourcesConfig struct {
+	// Memory limit such as 512m or 1g
+	Memory    string  `yaml:"memory"`
+	CPUs      float64 `yaml:"cpus"`
+	CPUShares int64   `yaml:"cpu_shares"`
+	PidsLimit int64   `yaml:"pids_limit"`
+}
+
+const (
+	// DriftStrategyRecreate recreates the container on any drift
+	DriftStrategyRecreate = "recreate"
+	// DriftStrategyInPlace uses ContainerUpdate when only resource limits drifted
+	DriftStrategyInPlace = "in-place"
+)
+
+type HooksConfig struct {
+	PreStop   []string      `yaml:"pre_stop"`
+	PostStart []string      `yaml:"post_start"`
+	PreUpdate []string      `yaml:"pre_update"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+type CanaryConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Soak    time.Duration `yaml:"soak"`
+}
+
+const (
+	// StrategyRecreate deletes the old container before creating the new one
+	StrategyRecreate = "recreate"
+	// StrategyBlueGreen verifies the new container before removing the old one
+	StrategyBlueGreen = "blue-green"
+	// StrategyRename keeps the old container under a temporary name until the new one is verified
+	StrategyRename = "rename"
+)
+
 type PortBinding struct {
 	Port     string `yaml:"port"`
 	Protocol string `yaml:"protocol"`
@@ -1,51 +1,359 @@
 package config
 
 import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	docker "github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	"github.com/huxcrux/docker-manager/pkg/scan"
+	"github.com/huxcrux/docker-manager/pkg/schedule"
+	"github.com/huxcrux/docker-manager/pkg/verify"
 )
 
-var containers []docker.ContainerConfig
-
+// ConfigToDockerConfig expands the config into one docker.ContainerConfig per container
+// instance. Entries with more than one replica produce containers named <name>-1..<name>-N.
 func ConfigToDockerConfig(config Config) ([]docker.ContainerConfig, error) {
-	for container := range config.Containers {
+	var containers []docker.ContainerConfig
 
-		// generate portset
-		portSet := make(nat.PortSet)
-
-		for _, portBinding := range config.Containers[container].PortBindings {
-			port, err := nat.NewPort(portBinding.Protocol, portBinding.Port)
+	for container := range config.Containers {
+		replicas := config.Containers[container].Replicas
+		if replicas <= 1 {
+			localContainer, err := toDockerConfig(config, config.Containers[container], 0)
 			if err != nil {
 				return nil, err
 			}
-			portSet[port] = struct{}{}
+			containers = append(containers, localContainer)
+			continue
 		}
 
-		// generate portmap
-		portMap := make(nat.PortMap)
-		for _, portBinding := range config.Containers[container].PortBindings {
-			port, err := nat.NewPort(portBinding.Protocol, portBinding.Port)
+		for replica := 1; replica <= replicas; replica++ {
+			localContainer, err := toDockerConfig(config, config.Containers[container], replica)
 			if err != nil {
 				return nil, err
 			}
-			portMap[port] = []nat.PortBinding{
-				{
-					HostIP:   portBinding.HostIP,
-					HostPort: portBinding.HostPort,
-				},
+			containers = append(containers, localContainer)
+		}
+	}
+
+	return containers, nil
+}
+
+// ReplicaName returns the container name of a replica, replica 0 means the entry is not replicated
+func ReplicaName(name string, replica int) string {
+	if replica == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, replica)
+}
+
+// replicaTemplateData is available in templated config values such as host ports
+type replicaTemplateData struct {
+	Name  string
+	Index int
+}
+
+var templateFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}
+
+// renderReplica renders a templated value like "{{ add 8080 .Index }}" for a replica
+func renderReplica(value string, data replicaTemplateData) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	tmpl, err := template.New("value").Funcs(templateFuncs).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// toDockerConfig converts a single container instance of a config entry
+func toDockerConfig(config Config, container ContainerConfig, replica int) (docker.ContainerConfig, error) {
+	name := ReplicaName(container.Name, replica)
+	data := replicaTemplateData{Name: name, Index: max(replica, 1)}
+
+	// generate portset
+	portSet := make(nat.PortSet)
+
+	for _, portBinding := range container.PortBindings {
+		port, err := nat.NewPort(portBinding.Protocol, portBinding.Port)
+		if err != nil {
+			return docker.ContainerConfig{}, err
+		}
+		portSet[port] = struct{}{}
+	}
+
+	// generate portmap
+	portMap := make(nat.PortMap)
+	for _, portBinding := range container.PortBindings {
+		port, err := nat.NewPort(portBinding.Protocol, portBinding.Port)
+		if err != nil {
+			return docker.ContainerConfig{}, err
+		}
+		hostPort, err := renderReplica(portBinding.HostPort, data)
+		if err != nil {
+			return docker.ContainerConfig{}, fmt.Errorf("error rendering host_port of %s: %v", name, err)
+		}
+		portMap[port] = []nat.PortBinding{
+			{
+				HostIP:   portBinding.HostIP,
+				HostPort: hostPort,
+			},
+		}
+	}
+
+	var memory int64
+	if container.Resources.Memory != "" {
+		var err error
+		memory, err = units.RAMInBytes(container.Resources.Memory)
+		if err != nil {
+			return docker.ContainerConfig{}, fmt.Errorf("invalid memory limit for %s: %v", name, err)
+		}
+	}
+
+	var mounts []mount.Mount
+	for _, volume := range container.Volumes {
+		source, err := renderReplica(volume.Source, data)
+		if err != nil {
+			return docker.ContainerConfig{}, fmt.Errorf("error rendering volume source of %s: %v", name, err)
+		}
+		mountType := mount.TypeVolume
+		if filepath.IsAbs(source) {
+			mountType = mount.TypeBind
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mountType,
+			Source:   source,
+			Target:   volume.Target,
+			ReadOnly: volume.ReadOnly,
+		})
+	}
+
+	var tagFilter *regexp.Regexp
+	if container.TagFilter != "" {
+		var err error
+		tagFilter, err = regexp.Compile(container.TagFilter)
+		if err != nil {
+			return docker.ContainerConfig{}, fmt.Errorf("invalid tag_filter for %s: %v", name, err)
+		}
+	}
+
+	var updateSchedule *schedule.Schedule
+	if expr := cmp.Or(container.UpdateSchedule, config.AppConfig.UpdateSchedule); expr != "" {
+		var err error
+		updateSchedule, err = schedule.Parse(expr)
+		if err != nil {
+			return docker.ContainerConfig{}, fmt.Errorf("invalid update_schedule for %s: %v", name, err)
+		}
+	}
+
+	updateCheck := config.AppConfig.UpdateCheck
+	if container.UpdateCheck != nil {
+		updateCheck = *container.UpdateCheck
+	}
+
+	severity := container.VulnerabilityScan.Severity
+	if severity == "" {
+		severity = config.AppConfig.VulnerabilityScan.Severity
+	}
+	if severity != "" && !scan.ValidSeverity(severity) {
+		return docker.ContainerConfig{}, fmt.Errorf("invalid vulnerability scan severity for %s: %s", name, severity)
+	}
+
+	return docker.ContainerConfig{
+		Entry:        container.Name,
+		Replica:      replica,
+		Image:        container.Image,
+		Name:         name,
+		ExposedPorts: portSet,
+		PortBindings: portMap,
+		Env:          container.Env,
+		Cmd:          container.Cmd,
+		Frozen:       container.Frozen,
+		Strategy:     container.Strategy,
+		Canary: docker.CanaryConfig{
+			Enabled: container.Canary.Enabled,
+			Soak:    container.Canary.Soak,
+		},
+		WaitHealthy:    container.WaitHealthy,
+		HealthTimeout:  container.HealthTimeout,
+		Group:          container.Group,
+		MaxUnavailable: config.Groups[container.Group].MaxUnavailable,
+		Hooks: docker.Hooks{
+			PreStop:   container.Hooks.PreStop,
+			PostStart: container.Hooks.PostStart,
+			PreUpdate: container.Hooks.PreUpdate,
+			Timeout:   container.Hooks.Timeout,
+		},
+		Resources: docker.Resources{
+			Memory:    memory,
+			NanoCPUs:  int64(container.Resources.CPUs * 1e9),
+			CPUShares: container.Resources.CPUShares,
+			PidsLimit: container.Resources.PidsLimit,
+		},
+		DriftStrategy:       container.DriftStrategy,
+		Networks:            container.Networks,
+		Mounts:              mounts,
+		PinDigest:           container.PinDigest,
+		UpdatePolicy:        container.UpdatePolicy,
+		TagFilter:           tagFilter,
+		PullPolicy:          container.PullPolicy,
+		Verify:              verifyPolicy(config, container),
+		SkipScan:            container.VulnerabilityScan.Skip,
+		ScanSeverity:        severity,
+		UpdateSchedule:      updateSchedule,
+		UpdateCheck:         updateCheck,
+		UpdateCheckInterval: cmp.Or(container.UpdateCheckInterval, config.AppConfig.UpdateCheckInterval),
+		UpdateMode:          cmp.Or(container.UpdateMode, config.AppConfig.UpdateMode),
+		Platform:            cmp.Or(container.Platform, config.AppConfig.Platform),
+	}, nil
+}
+
+// verifyPolicy returns the signature policy of a container, falling back to the one of its registry
+func verifyPolicy(config Config, container ContainerConfig) verify.Policy {
+	policy := container.Verify
+	if policy == (VerifyConfig{}) {
+		host := registry.HostOf(container.Image)
+		for name, registryConfig := range config.Registries {
+			if registry.NormalizeHost(name) == host {
+				policy = registryConfig.Verify
+				break
 			}
 		}
+	}
+	return verify.Policy{
+		Key:      policy.Key,
+		Identity: policy.Identity,
+		Issuer:   policy.Issuer,
+	}
+}
 
-		localContainer := docker.ContainerConfig{
-			Image:        config.Containers[container].Image,
-			Name:         config.Containers[container].Name,
-			ExposedPorts: portSet,
-			PortBindings: portMap,
-			Env:          config.Containers[container].Env,
-			Cmd:          config.Containers[container].Cmd,
+// RetryPolicy builds the Docker retry policy from config, falling back to defaults for unset values
+func RetryPolicy(config Config) docker.RetryPolicy {
+	policy := docker.DefaultRetryPolicy
+
+	if config.AppConfig.Retry.Attempts > 0 {
+		policy.Attempts = config.AppConfig.Retry.Attempts
+	}
+	if config.AppConfig.Retry.InitialBackoff > 0 {
+		policy.InitialBackoff = config.AppConfig.Retry.InitialBackoff
+	}
+	if config.AppConfig.Retry.MaxBackoff > 0 {
+		policy.MaxBackoff = config.AppConfig.Retry.MaxBackoff
+	}
+
+	return policy
+}
+
+// RegistryHosts builds the registry configuration from config, reading referenced files
+func RegistryHosts(config Config) (map[string]registry.Host, error) {
+	hosts := make(map[string]registry.Host, len(config.Registries))
+	for host, registryConfig := range config.Registries {
+		password, err := secret(registryConfig.Password, registryConfig.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading password of registry %s: %v", host, err)
+		}
+		token, err := secret(registryConfig.Token, registryConfig.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading token of registry %s: %v", host, err)
+		}
+		hosts[host] = registry.Host{
+			Credentials: registry.Credentials{
+				Username: registryConfig.Username,
+				Password: password,
+				Token:    token,
+			},
+			Mirror:   registryConfig.Mirror,
+			Insecure: registryConfig.Insecure,
 		}
-		containers = append(containers, localContainer)
 	}
+	return hosts, nil
+}
 
-	return containers, nil
+// APITokens returns the configured admin and read-only API tokens, including those in the
+// token files
+func APITokens(api APIConfig) ([]string, []string, error) {
+	admin, err := tokens(api.Tokens, api.TokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	readOnly, err := tokens(api.ReadOnlyTokens, api.ReadOnlyTokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admin, readOnly, nil
+}
+
+// tokens returns the given tokens and those in file, one per line
+func tokens(configured []string, file string) ([]string, error) {
+	var tokens []string
+	for _, token := range configured {
+		if token == "" {
+			return nil, fmt.Errorf("API tokens must not be empty")
+		}
+		tokens = append(tokens, token)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading API token file: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if token := strings.TrimSpace(line); token != "" && !strings.HasPrefix(token, "#") {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// WebhookSecret returns the secret of an inbound webhook source, reading the secret file if set
+func WebhookSecret(webhook InboundWebhookConfig) (string, error) {
+	secret, err := secret(webhook.Secret, webhook.SecretFile)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", fmt.Errorf("no secret configured")
+	}
+	return secret, nil
+}
+
+// BasicAuthCredentials returns the configured credentials, reading the password file if set
+func BasicAuthCredentials(auth BasicAuthConfig) (string, string, error) {
+	password, err := secret(auth.Password, auth.PasswordFile)
+	if err != nil {
+		return "", "", err
+	}
+	if auth.Username != "" && password == "" {
+		return "", "", fmt.Errorf("basic auth user %s has no password", auth.Username)
+	}
+	return auth.Username, password, nil
+}
+
+// secret returns value, or the trimmed content of file if it is set
+func secret(value string, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
 }
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestConfigToDockerConfigReplicas(t *testing.T) {
+	cfg := Config{
+		Containers: []ContainerConfig{
+			{
+				Name:     "worker",
+				Image:    "nginx:latest",
+				Replicas: 3,
+				PortBindings: []PortBinding{
+					{Port: "80", Protocol: "tcp", HostPort: "{{ add 8100 .Index }}"},
+				},
+			},
+			{
+				Name:  "single",
+				Image: "nginx:latest",
+			},
+		},
+	}
+
+	containers, err := ConfigToDockerConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to convert config: %v", err)
+	}
+	if len(containers) != 4 {
+		t.Fatalf("Expected 4 containers, got %d", len(containers))
+	}
+
+	expected := []struct {
+		name     string
+		hostPort string
+	}{
+		{"worker-1", "8101"},
+		{"worker-2", "8102"},
+		{"worker-3", "8103"},
+	}
+	for i, want := range expected {
+		if containers[i].Name != want.name {
+			t.Errorf("Expected container %d to be named %s, got %s", i, want.name, containers[i].Name)
+		}
+		if containers[i].Entry != "worker" {
+			t.Errorf("Expected container %s to belong to entry worker, got %s", containers[i].Name, containers[i].Entry)
+		}
+		hostPort := containers[i].PortBindings[nat.Port("80/tcp")][0].HostPort
+		if hostPort != want.hostPort {
+			t.Errorf("Expected host port %s for %s, got %s", want.hostPort, want.name, hostPort)
+		}
+	}
+
+	if containers[3].Name != "single" || containers[3].Replica != 0 {
+		t.Errorf("Expected unreplicated container to keep its name, got %s (replica %d)", containers[3].Name, containers[3].Replica)
+	}
+}
+
+func TestVerifyPolicyFallsBackToRegistry(t *testing.T) {
+	config := Config{
+		Containers: []ContainerConfig{
+			{Name: "app", Image: "ghcr.io/me/app:1.0"},
+			{Name: "own", Image: "ghcr.io/me/own:1.0", Verify: VerifyConfig{Key: "/own.pub"}},
+			{Name: "hub", Image: "nginx:latest"},
+		},
+		Registries: map[string]RegistryConfig{
+			"ghcr.io": {Verify: VerifyConfig{Key: "/ghcr.pub"}},
+		},
+	}
+
+	containers, err := ConfigToDockerConfig(config)
+	if err != nil {
+		t.Fatalf("Error converting config: %v", err)
+	}
+
+	expected := []string{"/ghcr.pub", "/own.pub", ""}
+	for i, want := range expected {
+		if containers[i].Verify.Key != want {
+			t.Errorf("Expected key %q for %s, got %q", want, containers[i].Name, containers[i].Verify.Key)
+		}
+	}
+}
+
+func TestUpdateCheckOverride(t *testing.T) {
+	disabled := false
+	config := Config{
+		AppConfig: AppConfig{UpdateCheck: true},
+		Containers: []ContainerConfig{
+			{Name: "web", Image: "nginx:latest"},
+			{Name: "db", Image: "postgres:16", UpdateCheck: &disabled},
+		},
+	}
+
+	containers, err := ConfigToDockerConfig(config)
+	if err != nil {
+		t.Fatalf("Error converting config: %v", err)
+	}
+	if !containers[0].UpdateCheck {
+		t.Errorf("Expected web to inherit update_check")
+	}
+	if containers[1].UpdateCheck {
+		t.Errorf("Expected db to override update_check")
+	}
+}
@@ -1,27 +1,68 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Read config from file
-func Read() (*Config, error) {
+// DefaultStateDir is used when app_config.state_dir is not set
+const DefaultStateDir = "data"
 
-	ConfigFile := "config.yaml"
+// File is the config file Read and Write use
+var File = "config.yaml"
 
-	// read ConfigFile from disk
-	config, err := os.ReadFile(ConfigFile)
+// Read config from file
+func Read() (*Config, error) {
+	// read File from disk
+	config, err := os.ReadFile(File)
 	if err != nil {
 		return nil, err
 	}
+	return Parse(config)
+}
 
+// Parse parses a YAML (or JSON) config and fills in defaults
+func Parse(data []byte) (*Config, error) {
 	// Marshal config into Config struct
 	var cfg Config
-	err = yaml.Unmarshal(config, &cfg)
+	err := yaml.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
 	}
+
+	if cfg.AppConfig.StateDir == "" {
+		cfg.AppConfig.StateDir = DefaultStateDir
+	}
+
 	return &cfg, nil
 }
+
+// Write replaces the config file with data, through a temporary file so a crash never leaves
+// a partially written config behind
+func Write(data []byte) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(File); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(File), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("error creating config file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing config file: %v", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing config file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing config file: %v", err)
+	}
+	return os.Rename(tmp.Name(), File)
+}
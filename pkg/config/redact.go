@@ -0,0 +1,209 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Redacted replaces secret values in configs served by the API
+const Redacted = "<redacted>"
+
+// Redact returns a copy of config with registry passwords and tokens, API tokens, the metrics
+// basic auth password, OTLP headers, notification webhook URLs, inbound webhook secrets and
+// container env values replaced by Redacted
+func Redact(config Config) Config {
+	if config.Containers != nil {
+		containers := make([]ContainerConfig, len(config.Containers))
+		for i, container := range config.Containers {
+			container.Env = redactEnv(container.Env)
+			containers[i] = container
+		}
+		config.Containers = containers
+	}
+	if config.Registries != nil {
+		registries := make(map[string]RegistryConfig, len(config.Registries))
+		for host, registryConfig := range config.Registries {
+			registryConfig.Password = redact(registryConfig.Password)
+			registryConfig.Token = redact(registryConfig.Token)
+			registries[host] = registryConfig
+		}
+		config.Registries = registries
+	}
+
+	app := &config.AppConfig
+	app.API.Tokens = redactAll(app.API.Tokens)
+	app.API.ReadOnlyTokens = redactAll(app.API.ReadOnlyTokens)
+	app.Metrics.BasicAuth.Password = redact(app.Metrics.BasicAuth.Password)
+	if headers := app.Metrics.OTLP.Headers; headers != nil {
+		app.Metrics.OTLP.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			app.Metrics.OTLP.Headers[name] = redact(value)
+		}
+	}
+	app.Notifications.Webhooks = redactAll(app.Notifications.Webhooks)
+	if app.InboundWebhooks != nil {
+		webhooks := make(map[string]InboundWebhookConfig, len(app.InboundWebhooks))
+		for source, webhook := range app.InboundWebhooks {
+			webhook.Secret = redact(webhook.Secret)
+			webhooks[source] = webhook
+		}
+		app.InboundWebhooks = webhooks
+	}
+	return config
+}
+
+// Unredact replaces the Redacted values in config with those of current, so a config read from
+// the API can be submitted again. It fails when a redacted value has no current counterpart.
+func Unredact(config *Config, current Config) error {
+	if config.Containers != nil {
+		currentEnv := make(map[string][]string, len(current.Containers))
+		for _, container := range current.Containers {
+			currentEnv[container.Name] = container.Env
+		}
+		containers := make([]ContainerConfig, len(config.Containers))
+		for i, container := range config.Containers {
+			var err error
+			if container.Env, err = unredactEnv(container.Env, currentEnv[container.Name]); err != nil {
+				return fmt.Errorf("env of container %s: %v", container.Name, err)
+			}
+			containers[i] = container
+		}
+		config.Containers = containers
+	}
+	if config.Registries != nil {
+		registries := maps.Clone(config.Registries)
+		for host, registryConfig := range registries {
+			var err error
+			if registryConfig.Password, err = unredact(registryConfig.Password, current.Registries[host].Password); err != nil {
+				return fmt.Errorf("password of registry %s: %v", host, err)
+			}
+			if registryConfig.Token, err = unredact(registryConfig.Token, current.Registries[host].Token); err != nil {
+				return fmt.Errorf("token of registry %s: %v", host, err)
+			}
+			registries[host] = registryConfig
+		}
+		config.Registries = registries
+	}
+
+	app, currentApp := &config.AppConfig, current.AppConfig
+	var err error
+	if app.API.Tokens, err = unredactAll(app.API.Tokens, currentApp.API.Tokens); err != nil {
+		return fmt.Errorf("API tokens: %v", err)
+	}
+	if app.API.ReadOnlyTokens, err = unredactAll(app.API.ReadOnlyTokens, currentApp.API.ReadOnlyTokens); err != nil {
+		return fmt.Errorf("read-only API tokens: %v", err)
+	}
+	if app.Metrics.BasicAuth.Password, err = unredact(app.Metrics.BasicAuth.Password, currentApp.Metrics.BasicAuth.Password); err != nil {
+		return fmt.Errorf("metrics basic auth password: %v", err)
+	}
+	if headers := app.Metrics.OTLP.Headers; headers != nil {
+		app.Metrics.OTLP.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			if app.Metrics.OTLP.Headers[name], err = unredact(value, currentApp.Metrics.OTLP.Headers[name]); err != nil {
+				return fmt.Errorf("OTLP header %s: %v", name, err)
+			}
+		}
+	}
+	if app.Notifications.Webhooks, err = unredactAll(app.Notifications.Webhooks, currentApp.Notifications.Webhooks); err != nil {
+		return fmt.Errorf("notification webhooks: %v", err)
+	}
+	if app.InboundWebhooks != nil {
+		webhooks := maps.Clone(app.InboundWebhooks)
+		for source, webhook := range webhooks {
+			if webhook.Secret, err = unredact(webhook.Secret, currentApp.InboundWebhooks[source].Secret); err != nil {
+				return fmt.Errorf("secret of inbound webhook %s: %v", source, err)
+			}
+			webhooks[source] = webhook
+		}
+		app.InboundWebhooks = webhooks
+	}
+	return nil
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func redactAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = redact(value)
+	}
+	return redacted
+}
+
+// redactEnv replaces the values of KEY=value environment variables, keeping the keys
+func redactEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	redacted := make([]string, len(env))
+	for i, variable := range env {
+		if key, value, ok := strings.Cut(variable, "="); ok {
+			variable = key + "=" + redact(value)
+		}
+		redacted[i] = variable
+	}
+	return redacted
+}
+
+// unredactEnv replaces redacted environment variable values by the current value of the same key
+func unredactEnv(env []string, current []string) ([]string, error) {
+	if env == nil {
+		return nil, nil
+	}
+	currentValues := make(map[string]string, len(current))
+	for _, variable := range current {
+		if key, value, ok := strings.Cut(variable, "="); ok {
+			currentValues[key] = value
+		}
+	}
+	unredacted := make([]string, len(env))
+	for i, variable := range env {
+		if key, value, ok := strings.Cut(variable, "="); ok {
+			value, err := unredact(value, currentValues[key])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			variable = key + "=" + value
+		}
+		unredacted[i] = variable
+	}
+	return unredacted, nil
+}
+
+func unredact(value string, current string) (string, error) {
+	if value != Redacted {
+		return value, nil
+	}
+	if current == "" {
+		return "", fmt.Errorf("%s has no current value", Redacted)
+	}
+	return current, nil
+}
+
+// unredactAll replaces redacted values by the current value at the same position
+func unredactAll(values []string, current []string) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	unredacted := make([]string, len(values))
+	for i, value := range values {
+		var currentValue string
+		if i < len(current) {
+			currentValue = current[i]
+		}
+		var err error
+		if unredacted[i], err = unredact(value, currentValue); err != nil {
+			return nil, err
+		}
+	}
+	return unredacted, nil
+}
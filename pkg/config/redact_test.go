@@ -0,0 +1,38 @@
+package config
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	current := Config{
+		Registries: map[string]RegistryConfig{"ghcr.io": {Username: "bot", Password: "hunter2"}},
+		Containers: []ContainerConfig{{Name: "db", Env: []string{"POSTGRES_PASSWORD=hunter2", "EMPTY="}}},
+	}
+	current.AppConfig.API.Tokens = []string{"admin-token"}
+	current.AppConfig.Notifications.Webhooks = []string{"https://hooks.example.com/secret"}
+
+	redacted := Redact(current)
+	if redacted.Registries["ghcr.io"].Password != Redacted || redacted.Registries["ghcr.io"].Username != "bot" {
+		t.Errorf("Unexpected redacted registry %+v", redacted.Registries["ghcr.io"])
+	}
+	if redacted.AppConfig.API.Tokens[0] != Redacted || redacted.AppConfig.Notifications.Webhooks[0] != Redacted {
+		t.Errorf("Expected tokens and webhooks to be redacted, got %+v", redacted.AppConfig)
+	}
+	if env := redacted.Containers[0].Env; env[0] != "POSTGRES_PASSWORD="+Redacted || env[1] != "EMPTY=" {
+		t.Errorf("Expected env values to be redacted, got %v", env)
+	}
+	if current.Registries["ghcr.io"].Password != "hunter2" || current.AppConfig.API.Tokens[0] != "admin-token" || current.Containers[0].Env[0] != "POSTGRES_PASSWORD=hunter2" {
+		t.Errorf("Redact modified the original config")
+	}
+
+	if err := Unredact(&redacted, current); err != nil {
+		t.Fatalf("Error unredacting config: %v", err)
+	}
+	if redacted.Registries["ghcr.io"].Password != "hunter2" || redacted.AppConfig.API.Tokens[0] != "admin-token" || redacted.Containers[0].Env[0] != "POSTGRES_PASSWORD=hunter2" {
+		t.Errorf("Expected the current secrets back, got %+v", redacted)
+	}
+
+	redacted.AppConfig.API.ReadOnlyTokens = []string{Redacted}
+	if err := Unredact(&redacted, current); err == nil {
+		t.Errorf("Expected an error for a redacted token without a current value")
+	}
+}
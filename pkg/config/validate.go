@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/scan"
+	"github.com/huxcrux/docker-manager/pkg/schedule"
+	"gopkg.in/yaml.v3"
+)
+
+// Check parses data like Parse, but also rejects unknown keys and values of the wrong type, and
+// validates the result. Every problem found is returned, joined into one error, schema problems
+// with their line.
+func Check(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var errs []error
+	var cfg Config
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		// the rest of the document is still decoded past type errors, but not past syntax errors
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+
+	var joined interface{ Unwrap() []error }
+	if err := Validate(cfg); errors.As(err, &joined) {
+		errs = append(errs, joined.Unwrap()...)
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks config for problems that would fail a reload or a reconcile, reading
+// referenced secret files. Every problem found is returned, joined into one error.
+func Validate(config Config) error {
+	var errs []error
+
+	names := make(map[string]bool, len(config.Containers))
+	for _, container := range config.Containers {
+		switch {
+		case container.Name == "":
+			errs = append(errs, fmt.Errorf("container with image %q has no name", container.Image))
+		case names[container.Name]:
+			errs = append(errs, fmt.Errorf("container %s is configured more than once", container.Name))
+		}
+		names[container.Name] = true
+		if container.Image == "" {
+			errs = append(errs, fmt.Errorf("container %s has no image", container.Name))
+		}
+
+		// replicas only differ in templated values, checking the first one is enough
+		replica := 0
+		if container.Replicas > 1 {
+			replica = 1
+		}
+		if _, err := toDockerConfig(config, container, replica); err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, err := range []error{
+			checkEnum("strategy", container.Strategy, StrategyRecreate, StrategyBlueGreen, StrategyRename),
+			checkEnum("update_mode", container.UpdateMode, UpdateModeAuto, UpdateModeNotify),
+			checkEnum("pull_policy", container.PullPolicy, PullPolicyIfNotPresent, PullPolicyAlways, PullPolicyNever),
+			checkEnum("update_policy", container.UpdatePolicy, UpdatePolicyPatch, UpdatePolicyMinor, UpdatePolicyMajor, UpdatePolicyPinned),
+			checkEnum("drift_strategy", container.DriftStrategy, DriftStrategyRecreate, DriftStrategyInPlace),
+			checkVerify(container.Verify),
+		} {
+			if err != nil {
+				errs = append(errs, fmt.Errorf("container %s: %v", container.Name, err))
+			}
+		}
+	}
+	for host, registry := range config.Registries {
+		if err := checkVerify(registry.Verify); err != nil {
+			errs = append(errs, fmt.Errorf("registry %s: %v", host, err))
+		}
+	}
+
+	for _, expr := range config.AppConfig.MaintenanceWindows {
+		if _, err := schedule.Parse(expr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid maintenance window: %v", err))
+		}
+	}
+	app := config.AppConfig
+	for _, err := range []error{
+		checkEnum("concurrent_reconcile", app.ConcurrentReconcile, ConcurrentReconcileReject, ConcurrentReconcileQueue),
+		checkEnum("update_mode", app.UpdateMode, UpdateModeAuto, UpdateModeNotify),
+		checkEnum("auto_heal.unhealthy.action", app.AutoHeal.Unhealthy.Action, UnhealthyActionRestart, UnhealthyActionRecreate),
+		checkEnum("vulnerability_scan.scanner", app.VulnerabilityScan.Scanner, scan.Trivy, scan.Grype),
+		checkEnum("sbom.generator", app.SBOM.Generator, scan.Syft, scan.Trivy),
+	} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := RegistryHosts(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := APITokens(config.AppConfig.API); err != nil {
+		errs = append(errs, err)
+	}
+	for source, webhook := range config.AppConfig.InboundWebhooks {
+		if _, err := WebhookSecret(webhook); err != nil {
+			errs = append(errs, fmt.Errorf("invalid inbound webhook %s: %v", source, err))
+		}
+	}
+	if _, _, err := BasicAuthCredentials(config.AppConfig.Metrics.BasicAuth); err != nil {
+		errs = append(errs, fmt.Errorf("invalid metrics basic auth: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// checkEnum fails if value is set to something other than one of allowed
+func checkEnum(field string, value string, allowed ...string) error {
+	if value == "" || slices.Contains(allowed, value) {
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q, expected %s or %s", field, value, strings.Join(allowed[:len(allowed)-1], ", "), allowed[len(allowed)-1])
+}
+
+// checkVerify fails for keyless verification missing its certificate identity or OIDC issuer
+func checkVerify(verify VerifyConfig) error {
+	if verify.Key == "" && (verify.Identity == "") != (verify.Issuer == "") {
+		return fmt.Errorf("verify needs both identity and issuer for keyless verification")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	data := []byte(`app_config:
+  reconcile_intervall: 10
+containers:
+  - name: web
+    image: nginx:latest
+    replicas: many
+  - name: db
+`)
+
+	err := Check(data)
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 3 {
+		t.Fatalf("Expected 3 problems, got %v", err)
+	}
+
+	if err := Check([]byte("containers: [")); err == nil {
+		t.Errorf("Expected a syntax error")
+	}
+	if err := Check([]byte("containers:\n  - name: web\n    image: nginx:latest\n")); err != nil {
+		t.Errorf("Expected a valid config, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{
+		Containers: []ContainerConfig{
+			{Name: "web", Image: "nginx:latest"},
+			{Name: "web", Image: "nginx:latest"},
+			{Name: "db", Resources: ResourcesConfig{Memory: "lots"}},
+		},
+	}
+	cfg.AppConfig.ConcurrentReconcile = "sometimes"
+
+	err := Validate(cfg)
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 4 {
+		t.Fatalf("Expected 4 problems, got %v", err)
+	}
+
+	if err := Validate(Config{Containers: []ContainerConfig{{Name: "web", Image: "nginx:latest"}}}); err != nil {
+		t.Errorf("Expected a valid config, got %v", err)
+	}
+}
+
+func TestValidateEnums(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		apply func(*Config)
+		valid bool
+	}{
+		{"defaults", func(*Config) {}, true},
+		{"known values", func(c *Config) {
+			c.Containers[0].Strategy = StrategyBlueGreen
+			c.Containers[0].UpdatePolicy = UpdatePolicyPinned
+			c.AppConfig.AutoHeal.Unhealthy.Action = UnhealthyActionRecreate
+		}, true},
+		{"strategy", func(c *Config) { c.Containers[0].Strategy = "blue-gren" }, false},
+		{"container update_mode", func(c *Config) { c.Containers[0].UpdateMode = "notfy" }, false},
+		{"pull_policy", func(c *Config) { c.Containers[0].PullPolicy = "ifnotpresent" }, false},
+		{"update_policy", func(c *Config) { c.Containers[0].UpdatePolicy = "pined" }, false},
+		{"drift_strategy", func(c *Config) { c.Containers[0].DriftStrategy = "inplace" }, false},
+		{"update_mode", func(c *Config) { c.AppConfig.UpdateMode = "manual" }, false},
+		{"unhealthy action", func(c *Config) { c.AppConfig.AutoHeal.Unhealthy.Action = "kill" }, false},
+		{"scanner", func(c *Config) { c.AppConfig.VulnerabilityScan.Scanner = "clair" }, false},
+		{"sbom generator", func(c *Config) { c.AppConfig.SBOM.Generator = "grype" }, false},
+		{"verify key", func(c *Config) { c.Containers[0].Verify = VerifyConfig{Key: "cosign.pub"} }, true},
+		{"verify keyless", func(c *Config) {
+			c.Containers[0].Verify = VerifyConfig{Identity: "ci@example.com", Issuer: "https://token.actions.githubusercontent.com"}
+		}, true},
+		{"verify identity without issuer", func(c *Config) { c.Containers[0].Verify = VerifyConfig{Identity: "ci@example.com"} }, false},
+		{"registry verify issuer without identity", func(c *Config) {
+			c.Registries = map[string]RegistryConfig{"ghcr.io": {Verify: VerifyConfig{Issuer: "https://accounts.google.com"}}}
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Containers: []ContainerConfig{{Name: "web", Image: "nginx:latest"}}}
+			tc.apply(&cfg)
+			if err := Validate(cfg); (err == nil) != tc.valid {
+				t.Errorf("Expected valid %v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -2,32 +2,192 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	registrytypes "github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/go-connections/nat"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	"github.com/huxcrux/docker-manager/pkg/schedule"
+	"github.com/huxcrux/docker-manager/pkg/verify"
+	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// LabelManaged marks containers created by docker-manager
+	LabelManaged = "docker-manager.managed"
+	// LabelEntry records the config entry a container was created from
+	LabelEntry = "docker-manager.entry"
+	// LabelReplica records the replica index of a container within its entry
+	LabelReplica = "docker-manager.replica"
+	// LabelConfigHash records the hash of the config a container was created from
+	LabelConfigHash = "docker-manager.config-hash"
+	// LabelImage records the configured image of a container created by digest
+	LabelImage = "docker-manager.image"
+	// LabelDigest records the digest a container was created from
+	LabelDigest = "docker-manager.digest"
+)
+
+// Hash returns a digest of the settings a container is created with, used to detect config changes
+func (c ContainerConfig) Hash() string {
+	data, _ := json.Marshal(struct {
+		Image        string
+		ExposedPorts nat.PortSet
+		PortBindings nat.PortMap
+		Env          []string
+		Cmd          []string
+		Resources    Resources
+		Networks     []string
+		Mounts       []mount.Mount
+	}{c.Image, c.ExposedPorts, c.PortBindings, c.Env, c.Cmd, c.Resources, c.Networks, c.Mounts})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsManaged reports whether a container carries docker-manager's ownership label
+func IsManaged(labels map[string]string) bool {
+	return labels[LabelManaged] == "true"
+}
+
 type ContainerConfig struct {
+	// Entry is the name of the config entry the container was created from
+	Entry string
+	// Replica is the 1-based index of the container within its entry, 0 if not replicated
+	Replica      int
 	Image        string
 	Name         string
 	ExposedPorts nat.PortSet
 	PortBindings nat.PortMap
 	Env          []string
 	Cmd          []string
+	// Frozen containers are skipped by update checks and drift recreation
+	Frozen bool
+	// Strategy decides how the container is replaced when it is recreated
+	Strategy string
+	// WaitHealthy makes reconciles wait for new containers to become healthy
+	WaitHealthy bool
+	// HealthTimeout bounds how long a new container may take to become healthy
+	HealthTimeout time.Duration
+	// Canary rolls image updates out to one container first
+	Canary CanaryConfig
+	// Group is the rolling update group the container belongs to
+	Group string
+	// MaxUnavailable is how many containers of the group may be updated at the same time
+	MaxUnavailable int
+	// Hooks are commands run inside the container around lifecycle events
+	Hooks Hooks
+	// Resources are the resource limits of the container
+	Resources Resources
+	// DriftStrategy decides whether resource-only drift is applied in place
+	DriftStrategy string
+	// Networks the container is attached to, the first one is its network mode
+	Networks []string
+	// Mounts are the volumes and bind mounts of the container
+	Mounts []mount.Mount
+	// PinDigest creates the container from the digest Image resolves to instead of the tag
+	PinDigest bool
+	// Digest is the resolved digest reference used to create a pinned container
+	Digest string
+	// Pinned is set for containers pinned to Digest through the API, regardless of PinDigest
+	Pinned bool
+	// Platform is the platform (such as linux/arm64) images are pulled and run for, the host's if empty
+	Platform string
+	// UpdatePolicy decides which newer tags the container may move to
+	UpdatePolicy string
+	// PullPolicy decides when the image is pulled
+	PullPolicy string
+	// TagFilter makes the container track the newest tag matching it
+	TagFilter *regexp.Regexp
+	// BaseImage is the configured image of a container moving between tags, Image is the tag it runs
+	BaseImage string
+	// Verify is the signature policy new images must satisfy before they are rolled out
+	Verify verify.Policy
+	// SkipScan rolls out new images without a vulnerability scan
+	SkipScan bool
+	// ScanSeverity is the lowest vulnerability severity that blocks an update
+	ScanSeverity string
+	// UpdateCheck enables image update checks
+	UpdateCheck bool
+	// UpdateCheckInterval is the minimum time between update checks, 0 checks on every reconcile
+	UpdateCheckInterval time.Duration
+	// UpdateMode is auto to apply updates or notify to only report them
+	UpdateMode string
+	// UpdateSchedule limits update checks to the minutes it matches, nil means any time
+	UpdateSchedule *schedule.Schedule
+}
+
+// Resources holds container resource limits, zero values mean unlimited
+type Resources struct {
+	// Memory limit in bytes
+	Memory int64
+	// NanoCPUs is the CPU quota in units of 1e-9 CPUs
+	NanoCPUs  int64
+	CPUShares int64
+	PidsLimit int64
+}
+
+// hostResources converts Resources to the Docker API type used when creating containers
+func (r Resources) hostResources() container.Resources {
+	resources := container.Resources{
+		Memory:    r.Memory,
+		NanoCPUs:  r.NanoCPUs,
+		CPUShares: r.CPUShares,
+	}
+	if r.PidsLimit > 0 {
+		pidsLimit := r.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	return resources
+}
+
+// Hooks holds lifecycle commands executed inside a container via the exec API
+type Hooks struct {
+	// PreStop runs in the old container before it is stopped for a recreate
+	PreStop []string
+	// PostStart runs in a new container once it has been started
+	PostStart []string
+	// PreUpdate runs in the old container before it is replaced by a newer image
+	PreUpdate []string
+	// Timeout bounds each hook command
+	Timeout time.Duration
+}
+
+// CanaryConfig controls canary updates of the containers created from one config entry
+type CanaryConfig struct {
+	Enabled bool
+	// Soak is how long the canary must stay healthy before the rest are updated
+	Soak time.Duration
 }
 
 // deleteContainers deletes multiple Docker containers by their IDs
 func DeleteContainer(cli *client.Client, containerId string) error {
 	ctx := context.Background()
 
-	if err := cli.ContainerStop(ctx, containerId, container.StopOptions{}); err != nil {
+	err := withRetry(ctx, "stop container "+containerId, func() error {
+		return cli.ContainerStop(ctx, containerId, container.StopOptions{})
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := cli.ContainerRemove(ctx, containerId, container.RemoveOptions{}); err != nil {
+	err = withRetry(ctx, "remove container "+containerId, func() error {
+		return cli.ContainerRemove(ctx, containerId, container.RemoveOptions{})
+	})
+	if err != nil {
 		return err
 	}
 
@@ -58,14 +218,43 @@ func CreateContainer(cli *client.Client, config ContainerConfig) (err error, cre
 		}
 	}
 
-	_, err = cli.ContainerCreate(ctx, &container.Config{
-		Image:        config.Image,
-		ExposedPorts: config.ExposedPorts,
-		Env:          config.Env,
-		Cmd:          config.Cmd,
-	}, &container.HostConfig{
+	hostConfig := &container.HostConfig{
 		PortBindings: config.PortBindings,
-	}, nil, nil, config.Name)
+		Resources:    config.Resources.hostResources(),
+		Mounts:       config.Mounts,
+	}
+	var networkingConfig *network.NetworkingConfig
+	if len(config.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(config.Networks[0])
+		networkingConfig = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+		for _, name := range config.Networks {
+			networkingConfig.EndpointsConfig[name] = &network.EndpointSettings{}
+		}
+	}
+
+	image := config.Image
+	labels := map[string]string{
+		LabelManaged:    "true",
+		LabelEntry:      config.Entry,
+		LabelReplica:    strconv.Itoa(config.Replica),
+		LabelConfigHash: config.Hash(),
+	}
+	if config.Digest != "" {
+		image = config.Digest
+		labels[LabelImage] = config.Image
+		labels[LabelDigest] = config.Digest
+	}
+
+	err = withRetry(ctx, "create container "+config.Name, func() error {
+		_, err := cli.ContainerCreate(ctx, &container.Config{
+			Image:        image,
+			ExposedPorts: config.ExposedPorts,
+			Env:          config.Env,
+			Cmd:          config.Cmd,
+			Labels:       labels,
+		}, hostConfig, networkingConfig, ociPlatform(config.Platform), config.Name)
+		return err
+	})
 	if err != nil {
 		return err, false
 	}
@@ -75,8 +264,66 @@ func CreateContainer(cli *client.Client, config ContainerConfig) (err error, cre
 
 func EnsureRunningContainers(cli *client.Client, containerID string) error {
 	ctx := context.Background()
-	err := cli.ContainerStart(ctx, containerID, container.StartOptions{})
-	return err
+	return withRetry(ctx, "start container "+containerID, func() error {
+		return cli.ContainerStart(ctx, containerID, container.StartOptions{})
+	})
+}
+
+// PullImage pulls ref and waits for the pull to complete, retrying transient failures
+func PullImage(cli *client.Client, ref string) error {
+	return PullPlatformImage(cli, ref, "")
+}
+
+// PullPlatformImage pulls ref for platform (such as linux/arm64), the daemon's platform if empty
+func PullPlatformImage(cli *client.Client, ref string, platform string) error {
+	// Pull through the mirror of the registry if there is one and tag the result as ref
+	if mirrored, ok := registry.MirrorRef(ref); ok {
+		err := pull(cli, mirrored, platform)
+		if err == nil {
+			err = cli.ImageTag(context.Background(), mirrored, ref)
+		}
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Pulling %s from mirror failed, pulling from the registry: %v", ref, err)
+	}
+	return pull(cli, ref, platform)
+}
+
+// pull pulls an image with the credentials configured for its registry, within the pull
+// concurrency cap and the registry rate limit
+func pull(cli *client.Client, ref string, platform string) error {
+	ctx := context.Background()
+
+	options := image.PullOptions{Platform: platform}
+	if creds, ok := registry.CredentialsFor(ref); ok {
+		auth, err := registrytypes.EncodeAuthConfig(registrytypes.AuthConfig{
+			Username:      creds.Username,
+			Password:      creds.Password,
+			RegistryToken: creds.Token,
+		})
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = auth
+	}
+
+	release := acquirePull()
+	defer release()
+
+	return withRetry(ctx, "pull image "+ref, func() error {
+		if err := registry.Wait(ctx); err != nil {
+			return err
+		}
+		reader, err := cli.ImagePull(ctx, ref, options)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		// Consume the stream to complete the pull, surfacing errors reported in it
+		return jsonmessage.DisplayJSONMessagesStream(reader, io.Discard, 0, false, nil)
+	})
 }
 
 func GetContainerIDByName(cli *client.Client, containerName string) (string, error) {
@@ -103,3 +350,67 @@ func ListAllContariners(cli *client.Client) ([]types.Container, error) {
 	}
 	return containers, nil
 }
+
+// UpdateResources applies new resource limits to a running container without recreating it
+func UpdateResources(cli *client.Client, containerID string, resources Resources) error {
+	ctx := context.Background()
+
+	update := resources.hostResources()
+	if resources.Memory > 0 {
+		// Match the swap limit Docker sets when creating a container with a memory limit
+		update.MemorySwap = 2 * resources.Memory
+	}
+	if resources.PidsLimit == 0 {
+		// 0 leaves the limit unchanged, -1 removes it
+		unlimited := int64(-1)
+		update.PidsLimit = &unlimited
+	}
+
+	return withRetry(ctx, "update container "+containerID, func() error {
+		_, err := cli.ContainerUpdate(ctx, containerID, container.UpdateConfig{Resources: update})
+		return err
+	})
+}
+
+// RestartContainer restarts a container in place
+func RestartContainer(cli *client.Client, containerID string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "restart container "+containerID, func() error {
+		return cli.ContainerRestart(ctx, containerID, container.StopOptions{})
+	})
+}
+
+// RemoveImage removes an image that no container uses
+func RemoveImage(cli *client.Client, imageID string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "remove image "+imageID, func() error {
+		_, err := cli.ImageRemove(ctx, imageID, image.RemoveOptions{PruneChildren: true})
+		return err
+	})
+}
+
+// IsNotFound reports whether err means the object doesn't exist
+func IsNotFound(err error) bool {
+	return errdefs.IsNotFound(err)
+}
+
+// IsInvalidParameter reports whether err means the daemon rejected a parameter of the request
+func IsInvalidParameter(err error) bool {
+	return errdefs.IsInvalidParameter(err)
+}
+
+// StopContainer stops a running container without removing it
+func StopContainer(cli *client.Client, containerID string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "stop container "+containerID, func() error {
+		return cli.ContainerStop(ctx, containerID, container.StopOptions{})
+	})
+}
+
+// RenameContainer gives an existing container a new name
+func RenameContainer(cli *client.Client, containerID string, name string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "rename container "+containerID, func() error {
+		return cli.ContainerRename(ctx, containerID, name)
+	})
+}
@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+)
+
+// ResolveDigest returns the digest reference (repository@sha256:...) the tag ref currently
+// points to locally, pulling the image for platform first if it is not present
+func ResolveDigest(cli *client.Client, ref string, platform string) (string, error) {
+	_, _, err := cli.ImageInspectWithRaw(context.Background(), ref)
+	if errdefs.IsNotFound(err) {
+		err = PullPlatformImage(cli, ref, platform)
+	}
+	if err != nil {
+		return "", err
+	}
+	return ImageDigest(cli, ref, ref)
+}
+
+// ImageDigest returns the digest reference of the local image imageID in the repository of ref
+func ImageDigest(cli *client.Client, imageID string, ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %s: %v", ref, err)
+	}
+
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), imageID)
+	if err != nil {
+		return "", err
+	}
+
+	// Images pulled through a mirror carry the digest in the mirror's repository
+	names := []string{named.Name()}
+	if mirrored, ok := registry.MirrorRef(ref); ok {
+		if mirroredNamed, err := reference.ParseNormalizedNamed(mirrored); err == nil {
+			names = append(names, mirroredNamed.Name())
+		}
+	}
+
+	for _, repoDigest := range inspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(names, digested.Name()) {
+			return repoDigest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no digest in repository %s, it was probably built locally", imageID, reference.FamiliarName(named))
+}
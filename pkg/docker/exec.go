@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// DefaultExecTimeout is used for Exec when no timeout is given
+const DefaultExecTimeout = 30 * time.Second
+
+// ExecResult holds the outcome of a command executed inside a container
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// Exec runs cmd inside a running container and waits for it to finish
+func Exec(cli *client.Client, containerID string, cmd []string, timeout time.Duration) (ExecResult, error) {
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	exec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer attach.Close()
+
+	// Read the output until the command exits or the timeout hits
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			return ExecResult{}, err
+		}
+	case <-ctx.Done():
+		return ExecResult{}, fmt.Errorf("command %v timed out after %s", cmd, timeout)
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{
+		ExitCode: inspect.ExitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}, nil
+}
+
+// ExecAttach starts cmd inside a running container attached to its stdin, stdout and stderr
+// and returns the exec ID and the connection to it. Without a TTY the output is multiplexed,
+// to be split with stdcopy.
+func ExecAttach(ctx context.Context, cli *client.Client, containerID string, cmd []string, tty bool) (string, types.HijackedResponse, error) {
+	exec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", types.HijackedResponse{}, err
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{Tty: tty})
+	if err != nil {
+		return "", types.HijackedResponse{}, err
+	}
+	return exec.ID, attach, nil
+}
+
+// ExecExitCode returns the exit code of a finished exec
+func ExecExitCode(ctx context.Context, cli *client.Client, execID string) (int, error) {
+	inspect, err := cli.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
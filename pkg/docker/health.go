@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// ErrHealthTimeout is returned when a container does not become healthy in time
+var ErrHealthTimeout = errors.New("timed out waiting for container to become healthy")
+
+// DefaultHealthTimeout is how long WaitForHealthy waits when no timeout is given
+const DefaultHealthTimeout = 60 * time.Second
+
+// healthPollInterval is how often the container state is inspected while waiting
+var healthPollInterval = time.Second
+
+// WaitForHealthy blocks until the container reports healthy. Containers without a
+// healthcheck are considered healthy once they have been running for a poll interval.
+func WaitForHealthy(cli *client.Client, containerID string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	runningPolls := 0
+	for {
+		inspect, err := cli.ContainerInspect(ctx, containerID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: container %s not healthy within %s", ErrHealthTimeout, containerID, timeout)
+			}
+			return err
+		}
+
+		state := inspect.State
+		switch {
+		case state == nil:
+		case state.Status == "exited" || state.Status == "dead":
+			return fmt.Errorf("container %s %s with exit code %d", containerID, state.Status, state.ExitCode)
+		case state.Health != nil:
+			if state.Health.Status == types.Healthy {
+				return nil
+			}
+			if state.Health.Status == types.Unhealthy {
+				return fmt.Errorf("container %s is unhealthy", containerID)
+			}
+		case state.Running:
+			runningPolls++
+			if runningPolls > 1 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: container %s not healthy within %s", ErrHealthTimeout, containerID, timeout)
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
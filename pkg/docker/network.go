@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// managedFilter selects networks and volumes created by docker-manager
+func managedFilter() filters.Args {
+	return filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))
+}
+
+// EnsureNetwork creates a bridge network labelled as managed unless a network with the
+// name already exists. Existing networks are used as is and never garbage collected.
+func EnsureNetwork(cli *client.Client, name string) (bool, error) {
+	ctx := context.Background()
+
+	_, err := cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err == nil {
+		return false, nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return false, err
+	}
+
+	err = withRetry(ctx, "create network "+name, func() error {
+		_, err := cli.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver: "bridge",
+			Labels: map[string]string{LabelManaged: "true"},
+		})
+		return err
+	})
+	return err == nil, err
+}
+
+// ListManagedNetworks returns the names of networks created by docker-manager
+func ListManagedNetworks(cli *client.Client) ([]string, error) {
+	networks, err := cli.NetworkList(context.Background(), network.ListOptions{Filters: managedFilter()})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(networks))
+	for _, network := range networks {
+		names = append(names, network.Name)
+	}
+	return names, nil
+}
+
+// RemoveNetwork removes a network, it fails while containers are attached to it
+func RemoveNetwork(cli *client.Client, name string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "remove network "+name, func() error {
+		return cli.NetworkRemove(ctx, name)
+	})
+}
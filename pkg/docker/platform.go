@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// architectures maps the kernel architecture names the daemon reports to image architectures
+var architectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// HostPlatform returns the platform of the Docker host, such as linux/arm64, which is the
+// platform the daemon pulls unless told otherwise
+func HostPlatform(cli *client.Client) (string, error) {
+	info, err := cli.Info(context.Background())
+	if err != nil {
+		return "", err
+	}
+	arch := info.Architecture
+	if mapped, ok := architectures[arch]; ok {
+		arch = mapped
+	}
+	return info.OSType + "/" + arch, nil
+}
+
+// ociPlatform converts a platform such as linux/arm/v7, nil for the daemon's default
+func ociPlatform(platform string) *ocispec.Platform {
+	if platform == "" {
+		return nil
+	}
+	goos, arch, variant := registry.ParsePlatform(platform)
+	return &ocispec.Platform{OS: goos, Architecture: arch, Variant: variant}
+}
@@ -0,0 +1,36 @@
+package docker
+
+import "sync"
+
+var (
+	// pullSlots holds a token per running pull, nil means pulls are not limited
+	pullSlots   chan struct{}
+	pullSlotsMu sync.Mutex
+)
+
+// SetPullConcurrency caps the number of image pulls running at the same time, 0 removes the cap.
+// Pulls already running finish in the slots they were started in.
+func SetPullConcurrency(n int) {
+	pullSlotsMu.Lock()
+	defer pullSlotsMu.Unlock()
+	if n <= 0 {
+		pullSlots = nil
+		return
+	}
+	if pullSlots == nil || cap(pullSlots) != n {
+		pullSlots = make(chan struct{}, n)
+	}
+}
+
+// acquirePull waits for a free pull slot and returns the function releasing it
+func acquirePull() func() {
+	pullSlotsMu.Lock()
+	slots := pullSlots
+	pullSlotsMu.Unlock()
+
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
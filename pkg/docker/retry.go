@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how Docker operations are retried on transient errors
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first one
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+var (
+	retryPolicy   = DefaultRetryPolicy
+	retryPolicyMu sync.RWMutex
+)
+
+// SetRetryPolicy replaces the policy used for all retried Docker operations
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicyMu.Lock()
+	retryPolicy = policy
+	retryPolicyMu.Unlock()
+}
+
+func currentRetryPolicy() RetryPolicy {
+	retryPolicyMu.RLock()
+	defer retryPolicyMu.RUnlock()
+	return retryPolicy
+}
+
+// backoff returns the delay before the given retry (1 is the first retry)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+		if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// IsRetryable reports whether err looks like a transient daemon or registry failure
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Errors caused by the request itself will not go away by retrying
+	if errdefs.IsNotFound(err) || errdefs.IsInvalidParameter(err) || errdefs.IsConflict(err) ||
+		errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) || errdefs.IsNotImplemented(err) ||
+		errdefs.IsCancelled(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errdefs.IsUnavailable(err) || errdefs.IsSystem(err) || errdefs.IsDeadline(err) ||
+		client.IsErrConnectionFailed(err) {
+		return true
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Registry errors reported in the pull stream are plain strings
+	var jsonErr *jsonmessage.JSONError
+	if errors.As(err, &jsonErr) {
+		return transientMessage.MatchString(jsonErr.Message)
+	}
+
+	return false
+}
+
+// transientMessage matches registry errors worth retrying. Status codes only count as such,
+// e.g. "status: 503" or "502 Bad Gateway", not as part of a tag or digest.
+var transientMessage = regexp.MustCompile(`(?i)\b(toomanyrequests|timeout|timed out|connection reset|connection refused|unexpected eof)\b|` +
+	`\b(status|code)\W{0,2}50[0234]\b|\b50[0234] (internal server error|bad gateway|service unavailable|gateway timeout)\b`)
+
+// withRetry runs fn until it succeeds, fails with a non-retryable error or the attempts are exhausted
+func withRetry(ctx context.Context, operation string, fn func() error) error {
+	policy := currentRetryPolicy()
+	attempts := policy.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn()
+		if err == nil || !IsRetryable(err) || attempt == attempts {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		log.Warnf("%s failed (attempt %d/%d), retrying in %s: %v", operation, attempt, attempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
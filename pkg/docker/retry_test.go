@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !IsRetryable(errdefs.Unavailable(errors.New("daemon busy"))) {
+		t.Errorf("Expected unavailable error to be retryable")
+	}
+	if IsRetryable(errdefs.NotFound(errors.New("no such image"))) {
+		t.Errorf("Expected not found error to not be retryable")
+	}
+	if IsRetryable(errors.New("some other error")) {
+		t.Errorf("Expected unknown error to not be retryable")
+	}
+}
+
+func TestIsRetryablePullMessage(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"received unexpected HTTP status: 503 Service Unavailable":       true,
+		"unknown: 502 Bad Gateway":                                       true,
+		"toomanyrequests: You have reached your pull rate limit":         true,
+		"net/http: TLS handshake timeout":                                true,
+		"manifest for app:v1.500 not found":                              false,
+		"manifest unknown: app@sha256:5003a1c0500b not found":            false,
+		"pull access denied for app-timeouts, repository does not exist": false,
+	} {
+		if got := IsRetryable(&jsonmessage.JSONError{Message: msg}); got != want {
+			t.Errorf("IsRetryable(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	SetRetryPolicy(RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1})
+	defer SetRetryPolicy(DefaultRetryPolicy)
+
+	calls := 0
+	err := withRetry(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return errdefs.Unavailable(errors.New("try again"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
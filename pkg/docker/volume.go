@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// EnsureVolume creates a named volume labelled as managed by the config entry unless a
+// volume with the name already exists. Existing volumes are used as is and never garbage collected.
+func EnsureVolume(cli *client.Client, name string, entry string) (bool, error) {
+	ctx := context.Background()
+
+	_, err := cli.VolumeInspect(ctx, name)
+	if err == nil {
+		return false, nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return false, err
+	}
+
+	err = withRetry(ctx, "create volume "+name, func() error {
+		_, err := cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name: name,
+			Labels: map[string]string{
+				LabelManaged: "true",
+				LabelEntry:   entry,
+			},
+		})
+		return err
+	})
+	return err == nil, err
+}
+
+// ListManagedVolumes returns the names of volumes created by docker-manager
+func ListManagedVolumes(cli *client.Client) ([]string, error) {
+	volumes, err := cli.VolumeList(context.Background(), volume.ListOptions{Filters: managedFilter()})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(volumes.Volumes))
+	for _, volume := range volumes.Volumes {
+		names = append(names, volume.Name)
+	}
+	return names, nil
+}
+
+// RemoveVolume removes a volume and its data, it fails while a container uses it
+func RemoveVolume(cli *client.Client, name string) error {
+	ctx := context.Background()
+	return withRetry(ctx, "remove volume "+name, func() error {
+		return cli.VolumeRemove(ctx, name, false)
+	})
+}
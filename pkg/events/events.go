@@ -0,0 +1,77 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of events published by the manager, changes to containers use the audit action
+// (create, recreate, remove, ...) as type
+const (
+	TypeReconcileStarted  = "reconcile_started"
+	TypeReconcileFinished = "reconcile_finished"
+	TypeUpdateAvailable   = "update_available"
+	TypeError             = "error"
+)
+
+// Event is a single thing the manager did or found
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Container string    `json:"container,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// bufferSize is how many events a subscriber may fall behind before events are dropped for it
+const bufferSize = 64
+
+// Bus fans published events out to subscribers. A nil Bus drops every event.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates a Bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every event published from now on and a function
+// to unsubscribe, which closes the channel. Subscribers that don't keep up miss events
+// rather than blocking the manager.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, bufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends event to every subscriber, setting its time if it has none
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
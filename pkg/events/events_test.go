@@ -0,0 +1,31 @@
+package events
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe()
+
+	bus.Publish(Event{Type: TypeReconcileStarted})
+	event := <-events
+	if event.Type != TypeReconcileStarted || event.Time.IsZero() {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// A subscriber that falls behind misses events instead of blocking Publish
+	for i := 0; i < bufferSize+10; i++ {
+		bus.Publish(Event{Type: TypeError})
+	}
+	if len(events) != bufferSize {
+		t.Errorf("Expected %d buffered events, got %d", bufferSize, len(events))
+	}
+
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(Event{Type: TypeReconcileFinished})
+	for range events {
+	}
+
+	var none *Bus
+	none.Publish(Event{Type: TypeError})
+}
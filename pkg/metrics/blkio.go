@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deviceReadBytesDesc = prometheus.NewDesc("docker_block_io_device_read_bytes_total",
+		"Block IO read bytes of Docker containers per device",
+		[]string{"container_id", "container_name", "device"}, nil)
+	deviceWriteBytesDesc = prometheus.NewDesc("docker_block_io_device_write_bytes_total",
+		"Block IO write bytes of Docker containers per device",
+		[]string{"container_id", "container_name", "device"}, nil)
+	deviceReadOpsDesc = prometheus.NewDesc("docker_block_io_device_read_ops_total",
+		"Block IO read operations of Docker containers per device",
+		[]string{"container_id", "container_name", "device"}, nil)
+	deviceWriteOpsDesc = prometheus.NewDesc("docker_block_io_device_write_ops_total",
+		"Block IO write operations of Docker containers per device",
+		[]string{"container_id", "container_name", "device"}, nil)
+)
+
+// deviceNames caches the names of block devices by major:minor
+var (
+	deviceNames   = make(map[string]string)
+	deviceNamesMu sync.Mutex
+)
+
+// deviceName resolves a block device number to its name through /sys/dev/block, e.g. 8:0 to
+// sda. The number itself is returned when it can't be resolved.
+func deviceName(major uint64, minor uint64) string {
+	number := fmt.Sprintf("%d:%d", major, minor)
+
+	deviceNamesMu.Lock()
+	defer deviceNamesMu.Unlock()
+	if name, ok := deviceNames[number]; ok {
+		return name
+	}
+
+	name := number
+	if target, err := os.Readlink(filepath.Join("/sys/dev/block", number)); err == nil {
+		name = filepath.Base(target)
+	}
+	deviceNames[number] = name
+	return name
+}
+
+// collectDevices sends the per-device block IO metrics of a stats sample. cgroup v1 reports
+// operations as Read and Write, cgroup v2 as read and write.
+func collectDevices(ch chan<- prometheus.Metric, stats types.StatsJSON) {
+	for _, entries := range []struct {
+		values []types.BlkioStatEntry
+		read   *prometheus.Desc
+		write  *prometheus.Desc
+	}{
+		{stats.BlkioStats.IoServiceBytesRecursive, deviceReadBytesDesc, deviceWriteBytesDesc},
+		{stats.BlkioStats.IoServicedRecursive, deviceReadOpsDesc, deviceWriteOpsDesc},
+	} {
+		for _, entry := range entries.values {
+			var desc *prometheus.Desc
+			switch strings.ToLower(entry.Op) {
+			case "read":
+				desc = entries.read
+			case "write":
+				desc = entries.write
+			default:
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(entry.Value), stats.ID, stats.Name, deviceName(entry.Major, entry.Minor))
+		}
+	}
+}
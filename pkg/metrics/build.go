@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"github.com/huxcrux/docker-manager/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterBuildInfo registers docker_manager_build_info with reg, always 1 with the build as labels
+func RegisterBuildInfo(reg prometheus.Registerer, info version.Info) error {
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "docker_manager_build_info",
+		Help: "docker-manager build, always 1",
+		ConstLabels: prometheus.Labels{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"date":       info.Date,
+			"go_version": info.GoVersion,
+		},
+	})
+	buildInfo.Set(1)
+	return Register(reg, buildInfo)
+}
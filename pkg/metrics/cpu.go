@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// perCPU exports the CPU usage of every core, off by default as it adds a series per core
+// and container
+var perCPU atomic.Bool
+
+// SetPerCPU sets whether the CPU usage of every core is exported
+func SetPerCPU(enabled bool) {
+	perCPU.Store(enabled)
+}
+
+var perCPUDesc = prometheus.NewDesc("docker_cpu_usage_per_cpu_seconds_total",
+	"CPU time consumed by Docker containers per core",
+	[]string{"container_id", "container_name", "cpu"}, nil)
+
+// collectPerCPU sends the per-core CPU usage of a stats sample if enabled. Only cgroup v1
+// hosts report it.
+func collectPerCPU(ch chan<- prometheus.Metric, stats types.StatsJSON) {
+	if !perCPU.Load() {
+		return
+	}
+	for cpu, usage := range stats.CPUStats.CPUUsage.PercpuUsage {
+		ch <- prometheus.MustNewConstMetric(perCPUDesc, prometheus.CounterValue, float64(usage)/float64(time.Second), stats.ID, stats.Name, strconv.Itoa(cpu))
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	daemonInfoDesc = prometheus.NewDesc("docker_daemon_info",
+		"Docker daemon version and setup, always 1",
+		[]string{"version", "api_version", "storage_driver", "cgroup_version", "os", "kernel_version", "architecture"}, nil)
+	daemonContainersDesc = prometheus.NewDesc("docker_daemon_containers",
+		"Number of containers on the Docker host by state",
+		[]string{"state"}, nil)
+	daemonImagesDesc = prometheus.NewDesc("docker_daemon_images",
+		"Number of images on the Docker host", nil, nil)
+)
+
+// daemonTimeout bounds the info and version calls of a scrape
+const daemonTimeout = 5 * time.Second
+
+// DaemonCollector is a prometheus.Collector exporting the Docker daemon's version and
+// container and image counts. Both come from cheap API calls, so they're read on every
+// scrape.
+type DaemonCollector struct {
+	cli *client.Client
+}
+
+// NewDaemonCollector creates a collector using the given Docker client
+func NewDaemonCollector(cli *client.Client) *DaemonCollector {
+	return &DaemonCollector{cli: cli}
+}
+
+// Describe implements prometheus.Collector
+func (c *DaemonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- daemonInfoDesc
+	ch <- daemonContainersDesc
+	ch <- daemonImagesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *DaemonCollector) Collect(ch chan<- prometheus.Metric) {
+	if !enabled(FamilyDaemon) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+	defer cancel()
+
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(daemonInfoDesc, err)
+		return
+	}
+	version, err := c.cli.ServerVersion(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(daemonInfoDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(daemonInfoDesc, prometheus.GaugeValue, 1,
+		version.Version, version.APIVersion, info.Driver, info.CgroupVersion, info.OperatingSystem, info.KernelVersion, info.Architecture)
+	ch <- prometheus.MustNewConstMetric(daemonContainersDesc, prometheus.GaugeValue, float64(info.ContainersRunning), "running")
+	ch <- prometheus.MustNewConstMetric(daemonContainersDesc, prometheus.GaugeValue, float64(info.ContainersPaused), "paused")
+	ch <- prometheus.MustNewConstMetric(daemonContainersDesc, prometheus.GaugeValue, float64(info.ContainersStopped), "stopped")
+	ch <- prometheus.MustNewConstMetric(daemonImagesDesc, prometheus.GaugeValue, float64(info.Images))
+}
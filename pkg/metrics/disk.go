@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDiskUsageInterval is how often disk usage is gathered unless configured otherwise,
+// computing it makes the daemon walk every layer and volume
+const DefaultDiskUsageInterval = 5 * time.Minute
+
+var (
+	imagesSizeDesc = prometheus.NewDesc("docker_images_size_bytes",
+		"Disk space used by all image layers", nil, nil)
+	imagesReclaimableDesc = prometheus.NewDesc("docker_images_reclaimable_bytes",
+		"Disk space of images not used by any container", nil, nil)
+	volumeSizeDesc = prometheus.NewDesc("docker_volume_size_bytes",
+		"Disk space used by a volume",
+		[]string{"volume", "driver"}, nil)
+	volumesReclaimableDesc = prometheus.NewDesc("docker_volumes_reclaimable_bytes",
+		"Disk space of volumes not used by any container", nil, nil)
+	containerSizeRwDesc = prometheus.NewDesc("docker_container_size_rw_bytes",
+		"Size of the writable layer of Docker containers",
+		[]string{"container_id", "container_name"}, nil)
+	containerSizeRootFsDesc = prometheus.NewDesc("docker_container_size_root_fs_bytes",
+		"Total size of the file system of Docker containers, including their image",
+		[]string{"container_id", "container_name"}, nil)
+	buildCacheSizeDesc = prometheus.NewDesc("docker_build_cache_size_bytes",
+		"Disk space used by the build cache", nil, nil)
+	buildCacheReclaimableDesc = prometheus.NewDesc("docker_build_cache_reclaimable_bytes",
+		"Disk space of build cache records not in use", nil, nil)
+)
+
+// DiskUsageCollector is a prometheus.Collector exporting the data of docker system df. As
+// for container stats, Run gathers it in the background and scrapes serve the latest usage.
+type DiskUsageCollector struct {
+	cli *client.Client
+
+	// usage and err are the outcome of the latest collection
+	usage *types.DiskUsage
+	err   error
+	mu    sync.RWMutex
+}
+
+// NewDiskUsageCollector creates a collector using the given Docker client, it must be
+// registered to be scraped and Run to gather disk usage
+func NewDiskUsageCollector(cli *client.Client) *DiskUsageCollector {
+	return &DiskUsageCollector{cli: cli}
+}
+
+// Run gathers disk usage every interval until ctx is cancelled. interval is called before
+// every collection so config reloads take effect, 0 means DefaultDiskUsageInterval.
+func (c *DiskUsageCollector) Run(ctx context.Context, interval func() time.Duration) {
+	for {
+		if enabled(FamilyDiskUsage) {
+			c.collect(ctx)
+		}
+
+		wait := interval()
+		if wait <= 0 {
+			wait = DefaultDiskUsageInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// collect reads the disk usage and replaces the cached one
+func (c *DiskUsageCollector) collect(ctx context.Context) {
+	usage, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		log.Warnf("Error collecting disk usage: %v", err)
+		err = fmt.Errorf("could not read disk usage: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.usage = &usage
+	}
+	c.err = err
+}
+
+// Describe implements prometheus.Collector
+func (c *DiskUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- imagesSizeDesc
+	ch <- imagesReclaimableDesc
+	ch <- volumeSizeDesc
+	ch <- volumesReclaimableDesc
+	ch <- containerSizeRwDesc
+	ch <- containerSizeRootFsDesc
+	ch <- buildCacheSizeDesc
+	ch <- buildCacheReclaimableDesc
+}
+
+// Collect implements prometheus.Collector, serving the latest disk usage. Until the first
+// collection succeeds nothing is exported.
+func (c *DiskUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	if !enabled(FamilyDiskUsage) {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.usage == nil {
+		if c.err != nil {
+			ch <- prometheus.NewInvalidMetric(imagesSizeDesc, c.err)
+		}
+		return
+	}
+	usage := c.usage
+
+	var imagesReclaimable int64
+	for _, img := range usage.Images {
+		if img.Containers == 0 {
+			imagesReclaimable += img.Size - max(img.SharedSize, 0)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(imagesSizeDesc, prometheus.GaugeValue, float64(usage.LayersSize))
+	ch <- prometheus.MustNewConstMetric(imagesReclaimableDesc, prometheus.GaugeValue, float64(imagesReclaimable))
+
+	var volumesReclaimable int64
+	for _, volume := range usage.Volumes {
+		// the size is -1 when the driver can't report it
+		if volume.UsageData == nil || volume.UsageData.Size < 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(volumeSizeDesc, prometheus.GaugeValue, float64(volume.UsageData.Size), volume.Name, volume.Driver)
+		if volume.UsageData.RefCount == 0 {
+			volumesReclaimable += volume.UsageData.Size
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(volumesReclaimableDesc, prometheus.GaugeValue, float64(volumesReclaimable))
+
+	for _, ctr := range usage.Containers {
+		name := ctr.ID
+		if len(ctr.Names) > 0 {
+			name = ctr.Names[0]
+		}
+		ch <- prometheus.MustNewConstMetric(containerSizeRwDesc, prometheus.GaugeValue, float64(ctr.SizeRw), ctr.ID, name)
+		ch <- prometheus.MustNewConstMetric(containerSizeRootFsDesc, prometheus.GaugeValue, float64(ctr.SizeRootFs), ctr.ID, name)
+	}
+
+	var buildCacheSize, buildCacheReclaimable int64
+	for _, record := range usage.BuildCache {
+		buildCacheSize += record.Size
+		if !record.InUse && !record.Shared {
+			buildCacheReclaimable += record.Size
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(buildCacheSizeDesc, prometheus.GaugeValue, float64(buildCacheSize))
+	ch <- prometheus.MustNewConstMetric(buildCacheReclaimableDesc, prometheus.GaugeValue, float64(buildCacheReclaimable))
+}
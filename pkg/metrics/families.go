@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Metric families that can be disabled
+const (
+	FamilyCPU       = "cpu"
+	FamilyMemory    = "memory"
+	FamilyNetwork   = "network"
+	FamilyBlockIO   = "blkio"
+	FamilyPids      = "pids"
+	FamilyState     = "state"
+	FamilyImage     = "image"
+	FamilyDiskUsage = "disk_usage"
+	FamilyDaemon    = "daemon"
+)
+
+var families = []string{FamilyCPU, FamilyMemory, FamilyNetwork, FamilyBlockIO, FamilyPids, FamilyState, FamilyImage, FamilyDiskUsage, FamilyDaemon}
+
+var (
+	disabledFamilies   = map[string]bool{}
+	disabledFamiliesMu sync.RWMutex
+)
+
+// SetDisabledFamilies replaces the metric families that aren't exported
+func SetDisabledFamilies(disabled []string) error {
+	set := make(map[string]bool)
+	for _, family := range disabled {
+		if !validFamily(family) {
+			return fmt.Errorf("unknown metric family %s, expected one of %v", family, families)
+		}
+		set[family] = true
+	}
+
+	disabledFamiliesMu.Lock()
+	defer disabledFamiliesMu.Unlock()
+	disabledFamilies = set
+	return nil
+}
+
+func validFamily(family string) bool {
+	for _, f := range families {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled reports whether the metrics of family are exported
+func enabled(family string) bool {
+	disabledFamiliesMu.RLock()
+	defer disabledFamiliesMu.RUnlock()
+	return !disabledFamilies[family]
+}
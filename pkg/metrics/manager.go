@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ManagerMetrics holds Prometheus metrics about docker-manager's own actions
+type ManagerMetrics struct {
+	HealthTimeouts   *prometheus.CounterVec
+	AutoHealRestarts *prometheus.CounterVec
+	// SignatureFailures counts image updates refused because their signature didn't verify
+	SignatureFailures *prometheus.CounterVec
+	// VulnerableImages counts image updates refused because of their vulnerabilities
+	VulnerableImages *prometheus.CounterVec
+	// UpdatesAvailable is 1 for containers with a newer image that isn't applied
+	UpdatesAvailable *prometheus.GaugeVec
+	// Drift is 1 per setting a container deviates from its config in, until it is fixed
+	Drift *prometheus.GaugeVec
+	// reconcile runs, by result: success, partial (some containers failed) or error
+	ReconcileDuration       prometheus.Histogram
+	ReconcileRuns           *prometheus.CounterVec
+	LastSuccessfulReconcile prometheus.Gauge
+	// containers changed by reconciles, recreations include image updates
+	ContainersCreated   prometheus.Counter
+	ContainersRecreated prometheus.Counter
+	ContainersRemoved   prometheus.Counter
+
+	// containers are the names series were recorded for, so they can be deleted once the
+	// container is gone
+	containers   map[string]bool
+	containersMu sync.Mutex
+}
+
+// NewManagerMetrics initializes the manager metrics and registers them with reg
+func NewManagerMetrics(reg prometheus.Registerer) (*ManagerMetrics, error) {
+	mm := &ManagerMetrics{
+		containers: make(map[string]bool),
+		HealthTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "docker_manager_health_timeouts_total",
+				Help: "Number of times a container did not become healthy within its timeout",
+			},
+			[]string{"container_name"},
+		),
+		AutoHealRestarts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "docker_manager_autoheal_restarts_total",
+				Help: "Number of times auto-heal restarted a container",
+			},
+			[]string{"container_name"},
+		),
+		SignatureFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "docker_manager_signature_failures_total",
+				Help: "Number of image updates refused because the image signature did not verify",
+			},
+			[]string{"container_name"},
+		),
+		UpdatesAvailable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "docker_manager_update_available",
+				Help: "Whether a newer image is available but not applied for a container, in notify update mode or until a maintenance window",
+			},
+			[]string{"container_name"},
+		),
+		VulnerableImages: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "docker_manager_vulnerable_images_total",
+				Help: "Number of image updates refused because the image has vulnerabilities above the severity threshold",
+			},
+			[]string{"container_name"},
+		),
+		Drift: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "docker_manager_container_drift",
+				Help: "Settings a container deviates from its config in while the drift isn't fixed, e.g. outside maintenance windows",
+			},
+			[]string{"container_name", "reason"},
+		),
+		ReconcileDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "docker_manager_reconcile_duration_seconds",
+				Help:    "Time taken by reconcile runs",
+				Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+			},
+		),
+		ReconcileRuns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "docker_manager_reconcile_runs_total",
+				Help: "Number of reconcile runs by result: success, partial or error",
+			},
+			[]string{"result"},
+		),
+		LastSuccessfulReconcile: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "docker_manager_last_successful_reconcile_timestamp",
+				Help: "Unix time the last reconcile without any failure finished",
+			},
+		),
+		ContainersCreated: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "docker_manager_containers_created_total",
+				Help: "Number of containers created by reconciles",
+			},
+		),
+		ContainersRecreated: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "docker_manager_containers_recreated_total",
+				Help: "Number of containers recreated by reconciles, including image updates",
+			},
+		),
+		ContainersRemoved: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "docker_manager_containers_removed_total",
+				Help: "Number of containers removed by reconciles",
+			},
+		),
+	}
+
+	if err := Register(reg, mm.HealthTimeouts, mm.AutoHealRestarts, mm.SignatureFailures, mm.VulnerableImages,
+		mm.UpdatesAvailable, mm.Drift, mm.ReconcileDuration, mm.ReconcileRuns, mm.LastSuccessfulReconcile,
+		mm.ContainersCreated, mm.ContainersRecreated, mm.ContainersRemoved); err != nil {
+		return nil, err
+	}
+
+	return mm, nil
+}
+
+// HealthTimeout records a container that did not become healthy in time
+func (mm *ManagerMetrics) HealthTimeout(containerName string) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	mm.HealthTimeouts.WithLabelValues(containerName).Inc()
+}
+
+// AutoHealRestart records a container restarted by auto-heal
+func (mm *ManagerMetrics) AutoHealRestart(containerName string) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	mm.AutoHealRestarts.WithLabelValues(containerName).Inc()
+}
+
+// SignatureFailure records an image update refused because its signature didn't verify
+func (mm *ManagerMetrics) SignatureFailure(containerName string) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	mm.SignatureFailures.WithLabelValues(containerName).Inc()
+}
+
+// VulnerableImage records an image update refused because of its vulnerabilities
+func (mm *ManagerMetrics) VulnerableImage(containerName string) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	mm.VulnerableImages.WithLabelValues(containerName).Inc()
+}
+
+// SetUpdateAvailable records whether a newer image is available for a container
+func (mm *ManagerMetrics) SetUpdateAvailable(containerName string, available bool) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	if !available {
+		mm.UpdatesAvailable.DeleteLabelValues(containerName)
+		return
+	}
+	mm.UpdatesAvailable.WithLabelValues(containerName).Set(1)
+}
+
+// ReconcileRun records a finished reconcile run and its result
+func (mm *ManagerMetrics) ReconcileRun(duration time.Duration, result string) {
+	if mm == nil {
+		return
+	}
+	mm.ReconcileDuration.Observe(duration.Seconds())
+	mm.ReconcileRuns.WithLabelValues(result).Inc()
+	if result == "success" {
+		mm.LastSuccessfulReconcile.SetToCurrentTime()
+	}
+}
+
+// ContainerCreated records a container created by a reconcile
+func (mm *ManagerMetrics) ContainerCreated() {
+	if mm == nil {
+		return
+	}
+	mm.ContainersCreated.Inc()
+}
+
+// ContainerRecreated records a container recreated by a reconcile
+func (mm *ManagerMetrics) ContainerRecreated() {
+	if mm == nil {
+		return
+	}
+	mm.ContainersRecreated.Inc()
+}
+
+// ContainerRemoved records a container removed by a reconcile
+func (mm *ManagerMetrics) ContainerRemoved() {
+	if mm == nil {
+		return
+	}
+	mm.ContainersRemoved.Inc()
+}
+
+// SetDrift replaces the settings a container is known to deviate from its config in, none
+// clears them
+func (mm *ManagerMetrics) SetDrift(containerName string, reasons []string) {
+	if mm == nil {
+		return
+	}
+	mm.track(containerName)
+	mm.Drift.DeletePartialMatch(prometheus.Labels{"container_name": containerName})
+	for _, reason := range reasons {
+		mm.Drift.WithLabelValues(containerName, reason).Set(1)
+	}
+}
+
+func (mm *ManagerMetrics) track(containerName string) {
+	mm.containersMu.Lock()
+	defer mm.containersMu.Unlock()
+	mm.containers[containerName] = true
+}
+
+// RetainContainers deletes the series of containers not in keep, so removed and renamed
+// containers don't linger on /metrics
+func (mm *ManagerMetrics) RetainContainers(keep map[string]bool) {
+	if mm == nil {
+		return
+	}
+	mm.containersMu.Lock()
+	defer mm.containersMu.Unlock()
+
+	vecs := []interface {
+		DeletePartialMatch(labels prometheus.Labels) int
+	}{mm.HealthTimeouts, mm.AutoHealRestarts, mm.SignatureFailures, mm.VulnerableImages, mm.UpdatesAvailable, mm.Drift}
+	for name := range mm.containers {
+		if keep[name] {
+			continue
+		}
+		for _, vec := range vecs {
+			vec.DeletePartialMatch(prometheus.Labels{"container_name": name})
+		}
+		delete(mm.containers, name)
+	}
+}
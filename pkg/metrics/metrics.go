@@ -1,168 +1,487 @@
 package metrics
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 )
 
-// DockerMetrics holds Prometheus metrics
-type DockerMetrics struct {
-	CPUUsageTotal      *prometheus.GaugeVec
-	MemoryUsage        *prometheus.GaugeVec
-	MemoryMaxUsage     *prometheus.GaugeVec
-	MemoryLimit        *prometheus.GaugeVec
-	MemoryCache        *prometheus.GaugeVec
-	MemoryRSS          *prometheus.GaugeVec
-	MemoryUsageOverall *prometheus.GaugeVec
-	NetworkRxBytes     *prometheus.GaugeVec
-	NetworkTxBytes     *prometheus.GaugeVec
-	BlockIoReadBytes   *prometheus.GaugeVec
-	BlockIoWriteBytes  *prometheus.GaugeVec
-}
-
-// NewDockerMetrics initializes and registers Prometheus metrics
-func NewDockerMetrics() *DockerMetrics {
-	dm := &DockerMetrics{
-		CPUUsageTotal: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_cpu_usage_total",
-				Help: "Total CPU usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryUsage: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_usage",
-				Help: "Memory usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryMaxUsage: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_max_usage",
-				Help: "Maximum memory usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryLimit: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_limit",
-				Help: "Memory limit of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryCache: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_cache",
-				Help: "Cache memory usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryRSS: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_rss",
-				Help: "RSS memory usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		MemoryUsageOverall: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_memory_usage_overall",
-				Help: "Overall memory usage of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		NetworkRxBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_network_rx_bytes",
-				Help: "Network received bytes of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		NetworkTxBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_network_tx_bytes",
-				Help: "Network transmitted bytes of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		BlockIoReadBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_block_io_read_bytes",
-				Help: "Block IO read bytes of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-		BlockIoWriteBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "docker_block_io_write_bytes",
-				Help: "Block IO write bytes of Docker containers",
-			},
-			[]string{"container_id", "container_name"},
-		),
-	}
-
-	// Register all metrics with Prometheus
-	prometheus.MustRegister(dm.CPUUsageTotal)
-	prometheus.MustRegister(dm.MemoryUsage)
-	prometheus.MustRegister(dm.MemoryMaxUsage)
-	prometheus.MustRegister(dm.MemoryLimit)
-	prometheus.MustRegister(dm.MemoryCache)
-	prometheus.MustRegister(dm.MemoryRSS)
-	prometheus.MustRegister(dm.MemoryUsageOverall)
-	prometheus.MustRegister(dm.NetworkRxBytes)
-	prometheus.MustRegister(dm.NetworkTxBytes)
-	prometheus.MustRegister(dm.BlockIoReadBytes)
-	prometheus.MustRegister(dm.BlockIoWriteBytes)
-
-	return dm
-}
-
-// UpdateMetrics updates Prometheus metrics with values from types.StatsJSON
-func (dm *DockerMetrics) UpdateMetrics(stats types.StatsJSON) {
-	containerID := stats.ID
-	containerName := stats.Name
-
-	// CPU usage calculation
-	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage - stats.PreCPUStats.CPUUsage.TotalUsage)
-	systemDelta := float64(stats.CPUStats.SystemUsage - stats.PreCPUStats.SystemUsage)
-	cpuPercent := (cpuDelta / systemDelta) * float64(len(stats.CPUStats.CPUUsage.PercpuUsage)) * 100.0
-
-	// Memory usage
-	memoryUsage := float64(stats.MemoryStats.Usage)
-	memoryMaxUsage := float64(stats.MemoryStats.MaxUsage)
-	memoryLimit := float64(stats.MemoryStats.Limit)
-	memoryCache := float64(stats.MemoryStats.Stats["cache"])
-	memoryRSS := float64(stats.MemoryStats.Stats["rss"])
-	overallMemoryUsage := memoryUsage - memoryCache
-
-	// Network I/O
-	var rxBytes, txBytes uint64
+// statMetric is a container metric computed from a stats sample
+type statMetric struct {
+	family    string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stats types.StatsJSON) float64
+	// aggregate metrics are only exported while aggregateNetwork is set
+	aggregate bool
+	// legacy metrics are the former gauges of cumulative stats, only exported while
+	// legacyGauges is set
+	legacy bool
+}
+
+// legacyGauges keeps exporting the cumulative network and block IO stats under their former
+// gauge names next to the counters, so dashboards can be migrated
+var legacyGauges atomic.Bool
+
+// oneShotStats reads stats with the one-shot API, which doesn't wait a second for a second
+// CPU sample
+var oneShotStats atomic.Bool
+
+// SetOneShotStats sets whether stats are read with the one-shot API
+func SetOneShotStats(enabled bool) {
+	oneShotStats.Store(enabled)
+}
+
+// SetLegacyGauges sets whether the former gauges of cumulative stats are exported
+func SetLegacyGauges(enabled bool) {
+	legacyGauges.Store(enabled)
+}
+
+func newStatMetric(family string, name string, help string, value func(stats types.StatsJSON) float64) statMetric {
+	return statMetric{
+		family:    family,
+		desc:      prometheus.NewDesc(name, help, []string{"container_id", "container_name"}, nil),
+		valueType: prometheus.GaugeValue,
+		value:     value,
+	}
+}
+
+func newCounterMetric(family string, name string, help string, value func(stats types.StatsJSON) float64) statMetric {
+	metric := newStatMetric(family, name, help, value)
+	metric.valueType = prometheus.CounterValue
+	return metric
+}
+
+func newAggregateMetric(metric statMetric) statMetric {
+	metric.aggregate = true
+	return metric
+}
+
+func newLegacyMetric(metric statMetric) statMetric {
+	metric.legacy = true
+	return metric
+}
+
+// statMetrics are the container metrics exported per container
+var statMetrics = []statMetric{
+	newStatMetric(FamilyCPU, "docker_cpu_usage_total", "Total CPU usage of Docker containers", cpuPercent),
+	newStatMetric(FamilyMemory, "docker_memory_usage", "Memory usage of Docker containers", func(s types.StatsJSON) float64 {
+		return float64(s.MemoryStats.Usage)
+	}),
+	newStatMetric(FamilyMemory, "docker_memory_max_usage", "Maximum memory usage of Docker containers", func(s types.StatsJSON) float64 {
+		return float64(s.MemoryStats.MaxUsage)
+	}),
+	newStatMetric(FamilyMemory, "docker_memory_limit", "Memory limit of Docker containers", func(s types.StatsJSON) float64 {
+		return float64(s.MemoryStats.Limit)
+	}),
+	newStatMetric(FamilyMemory, "docker_memory_cache", "Cache memory usage of Docker containers", func(s types.StatsJSON) float64 {
+		return memoryStat(s, "cache", "file")
+	}),
+	newStatMetric(FamilyMemory, "docker_memory_rss", "RSS memory usage of Docker containers", func(s types.StatsJSON) float64 {
+		return memoryStat(s, "rss", "anon")
+	}),
+	newStatMetric(FamilyMemory, "docker_memory_usage_overall", "Overall memory usage of Docker containers", func(s types.StatsJSON) float64 {
+		return float64(s.MemoryStats.Usage) - memoryStat(s, "cache", "inactive_file")
+	}),
+	newAggregateMetric(newCounterMetric(FamilyNetwork, "docker_network_rx_bytes_total", "Network received bytes of Docker containers", networkRx)),
+	newAggregateMetric(newCounterMetric(FamilyNetwork, "docker_network_tx_bytes_total", "Network transmitted bytes of Docker containers", networkTx)),
+	newCounterMetric(FamilyBlockIO, "docker_block_io_read_bytes_total", "Block IO read bytes of Docker containers", func(s types.StatsJSON) float64 {
+		return blockIO(s, "read")
+	}),
+	newCounterMetric(FamilyBlockIO, "docker_block_io_write_bytes_total", "Block IO write bytes of Docker containers", func(s types.StatsJSON) float64 {
+		return blockIO(s, "write")
+	}),
+	newLegacyMetric(newAggregateMetric(newStatMetric(FamilyNetwork, "docker_network_rx_bytes", "Deprecated: use docker_network_rx_bytes_total", networkRx))),
+	newLegacyMetric(newAggregateMetric(newStatMetric(FamilyNetwork, "docker_network_tx_bytes", "Deprecated: use docker_network_tx_bytes_total", networkTx))),
+	newLegacyMetric(newStatMetric(FamilyBlockIO, "docker_block_io_read_bytes", "Deprecated: use docker_block_io_read_bytes_total", func(s types.StatsJSON) float64 {
+		return blockIO(s, "read")
+	})),
+	newLegacyMetric(newStatMetric(FamilyBlockIO, "docker_block_io_write_bytes", "Deprecated: use docker_block_io_write_bytes_total", func(s types.StatsJSON) float64 {
+		return blockIO(s, "write")
+	})),
+	newStatMetric(FamilyPids, "docker_container_pids", "Number of processes and threads in Docker containers", func(s types.StatsJSON) float64 {
+		return float64(s.PidsStats.Current)
+	}),
+	newStatMetric(FamilyPids, "docker_container_pids_limit", "Maximum number of processes and threads of Docker containers, 0 when unlimited", func(s types.StatsJSON) float64 {
+		return float64(s.PidsStats.Limit)
+	}),
+	newCounterMetric(FamilyCPU, "docker_cpu_periods_total", "CPU enforcement periods elapsed for Docker containers with a CPU limit", func(s types.StatsJSON) float64 {
+		return float64(s.CPUStats.ThrottlingData.Periods)
+	}),
+	newCounterMetric(FamilyCPU, "docker_cpu_throttled_periods_total", "CPU enforcement periods in which Docker containers were throttled", func(s types.StatsJSON) float64 {
+		return float64(s.CPUStats.ThrottlingData.ThrottledPeriods)
+	}),
+	newCounterMetric(FamilyCPU, "docker_cpu_throttled_seconds_total", "Time Docker containers were throttled for", func(s types.StatsJSON) float64 {
+		return float64(s.CPUStats.ThrottlingData.ThrottledTime) / float64(time.Second)
+	}),
+}
+
+// cpuPercent computes the CPU usage between the stats sample and the one before it
+func cpuPercent(stats types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta < 0 || systemDelta <= 0 {
+		return 0
+	}
+	// PercpuUsage is only reported on cgroup v1
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return (cpuDelta / systemDelta) * cpus * 100.0
+}
+
+// memoryStat reads a memory stat by its cgroup v1 or v2 key, depending on the host. cgroup
+// v2 has no cache and rss, the closest are file and anon, and usage without cache becomes
+// usage without inactive_file.
+func memoryStat(stats types.StatsJSON, v1 string, v2 string) float64 {
+	if _, cgroupV1 := stats.MemoryStats.Stats["cache"]; cgroupV1 {
+		return float64(stats.MemoryStats.Stats[v1])
+	}
+	return float64(stats.MemoryStats.Stats[v2])
+}
+
+// networkRx sums the bytes received on all interfaces
+func networkRx(stats types.StatsJSON) float64 {
+	var rxBytes uint64
 	for _, v := range stats.Networks {
 		rxBytes += v.RxBytes
+	}
+	return float64(rxBytes)
+}
+
+// networkTx sums the bytes transmitted on all interfaces
+func networkTx(stats types.StatsJSON) float64 {
+	var txBytes uint64
+	for _, v := range stats.Networks {
 		txBytes += v.TxBytes
 	}
+	return float64(txBytes)
+}
 
-	// Block I/O
-	var blkRead, blkWrite uint64
+// blockIO sums the block IO bytes of operation op, cgroup v1 reports Read and Write
+func blockIO(stats types.StatsJSON, op string) float64 {
+	var total uint64
 	for _, bio := range stats.BlkioStats.IoServiceBytesRecursive {
-		if bio.Op == "read" {
-			blkRead += bio.Value
-		} else if bio.Op == "write" {
-			blkWrite += bio.Value
-		}
-	}
-
-	// Set Prometheus metrics
-	dm.CPUUsageTotal.WithLabelValues(containerID, containerName).Set(cpuPercent)
-	dm.MemoryUsage.WithLabelValues(containerID, containerName).Set(memoryUsage)
-	dm.MemoryMaxUsage.WithLabelValues(containerID, containerName).Set(memoryMaxUsage)
-	dm.MemoryLimit.WithLabelValues(containerID, containerName).Set(memoryLimit)
-	dm.MemoryCache.WithLabelValues(containerID, containerName).Set(memoryCache)
-	dm.MemoryRSS.WithLabelValues(containerID, containerName).Set(memoryRSS)
-	dm.MemoryUsageOverall.WithLabelValues(containerID, containerName).Set(overallMemoryUsage)
-	dm.NetworkRxBytes.WithLabelValues(containerID, containerName).Set(float64(rxBytes))
-	dm.NetworkTxBytes.WithLabelValues(containerID, containerName).Set(float64(txBytes))
-	dm.BlockIoReadBytes.WithLabelValues(containerID, containerName).Set(float64(blkRead))
-	dm.BlockIoWriteBytes.WithLabelValues(containerID, containerName).Set(float64(blkWrite))
+		if strings.EqualFold(bio.Op, op) {
+			total += bio.Value
+		}
+	}
+	return float64(total)
+}
+
+// DefaultStatsInterval is how often container stats are gathered unless configured otherwise
+const DefaultStatsInterval = 15 * time.Second
+
+// DockerCollector is a prometheus.Collector exporting the stats of all containers. Stats are
+// gathered in the background by Run and scrapes serve the latest samples, so a scrape doesn't
+// wait for one Docker API round-trip per container.
+type DockerCollector struct {
+	cli *client.Client
+
+	// scrapeErrors counts the containers whose stats couldn't be read, errored are the
+	// containers it has series for
+	scrapeErrors *prometheus.CounterVec
+	errored      map[string]bool
+	erroredMu    sync.Mutex
+	// self-metrics of the collection
+	duration   prometheus.Histogram
+	containers prometheus.Gauge
+	apiErrors  *prometheus.CounterVec
+
+	// samples, states and err are the outcome of the latest collection
+	samples []types.StatsJSON
+	states  []types.ContainerJSON
+	err     error
+	// imageCreated caches when images were built by image ID, images never change
+	imageCreated map[string]time.Time
+	mu           sync.RWMutex
+}
+
+// NewDockerCollector creates a collector using the given Docker client, it must be
+// registered to be scraped and Run to gather stats
+func NewDockerCollector(cli *client.Client) *DockerCollector {
+	return &DockerCollector{
+		cli:          cli,
+		imageCreated: make(map[string]time.Time),
+		errored:      make(map[string]bool),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_manager_scrape_errors_total",
+			Help: "Number of times the stats of a container couldn't be read",
+		}, []string{"container"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "docker_manager_stats_collection_duration_seconds",
+			Help:    "Time taken to collect the stats of all containers",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}),
+		containers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "docker_manager_stats_containers_scraped",
+			Help: "Number of containers whose stats were read in the latest collection",
+		}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_manager_docker_api_errors_total",
+			Help: "Number of failed Docker API calls made to collect stats, by operation",
+		}, []string{"operation"}),
+	}
+}
+
+// Run gathers container stats every interval until ctx is cancelled. interval is called
+// before every collection so config reloads take effect, 0 means DefaultStatsInterval.
+func (c *DockerCollector) Run(ctx context.Context, interval func() time.Duration) {
+	for {
+		c.collect(ctx)
+
+		wait := interval()
+		if wait <= 0 {
+			wait = DefaultStatsInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// collect fetches the stats and state of all containers concurrently and replaces the cached
+// samples. A container whose stats or state couldn't be read is left out and counted in
+// scrapeErrors.
+func (c *DockerCollector) collect(ctx context.Context) {
+	start := time.Now()
+	var (
+		samples []types.StatsJSON
+		states  []types.ContainerJSON
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		c.apiErrors.WithLabelValues("list").Inc()
+		err = fmt.Errorf("could not list containers: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, ctr := range containers {
+		name := ctr.ID
+		if len(ctr.Names) > 0 {
+			name = strings.TrimPrefix(ctr.Names[0], "/")
+		}
+		names[name] = true
+
+		wg.Add(1)
+		go func(containerID string, name string) {
+			defer wg.Done()
+			stats, err := c.containerStats(ctx, containerID)
+			if err != nil {
+				log.Warnf("Error collecting stats of container %s: %v", name, err)
+				c.scrapeError(name)
+				return
+			}
+			var info types.ContainerJSON
+			if enabled(FamilyState) || enabled(FamilyImage) {
+				info, err = c.cli.ContainerInspect(ctx, containerID)
+				if err != nil {
+					log.Warnf("Error inspecting container %s: %v", name, err)
+					c.apiErrors.WithLabelValues("inspect").Inc()
+					c.scrapeError(name)
+					return
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			samples = append(samples, stats)
+			states = append(states, info)
+		}(ctr.ID, name)
+	}
+	wg.Wait()
+	if err == nil {
+		c.forgetErrors(names)
+	}
+	imageCreated := make(map[string]time.Time)
+	if enabled(FamilyImage) {
+		imageCreated = c.imagesCreated(ctx, states)
+	}
+	c.duration.Observe(time.Since(start).Seconds())
+	c.containers.Set(float64(len(samples)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples, c.states, c.err, c.imageCreated = samples, states, err, imageCreated
+}
+
+// Describe implements prometheus.Collector
+func (c *DockerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range statMetrics {
+		ch <- metric.desc
+	}
+	for _, metric := range interfaceMetrics {
+		ch <- metric.desc
+	}
+	ch <- deviceReadBytesDesc
+	ch <- deviceWriteBytesDesc
+	ch <- deviceReadOpsDesc
+	ch <- deviceWriteOpsDesc
+	ch <- perCPUDesc
+	ch <- containerStateDesc
+	ch <- containerHealthDesc
+	ch <- containerRestartsDesc
+	ch <- containerStartTimeDesc
+	ch <- containerUptimeDesc
+	ch <- containerExitCodeDesc
+	ch <- containerOOMKilledDesc
+	ch <- containerImageCreatedDesc
+	c.scrapeErrors.Describe(ch)
+	c.duration.Describe(ch)
+	c.containers.Describe(ch)
+	c.apiErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector, serving the latest samples. Only failing to list
+// the containers fails the scrape.
+func (c *DockerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.scrapeErrors.Collect(ch)
+	c.duration.Collect(ch)
+	c.containers.Collect(ch)
+	c.apiErrors.Collect(ch)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.err != nil {
+		ch <- prometheus.NewInvalidMetric(statMetrics[0].desc, c.err)
+	}
+	aggregate, legacy := aggregateNetwork.Load(), legacyGauges.Load()
+	for _, stats := range c.samples {
+		for _, metric := range statMetrics {
+			if metric.aggregate && !aggregate || metric.legacy && !legacy || !enabled(metric.family) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stats), stats.ID, stats.Name)
+		}
+		if enabled(FamilyNetwork) {
+			collectInterfaces(ch, stats)
+		}
+		if enabled(FamilyBlockIO) {
+			collectDevices(ch, stats)
+		}
+		if enabled(FamilyCPU) {
+			collectPerCPU(ch, stats)
+		}
+	}
+	for _, info := range c.states {
+		if enabled(FamilyState) {
+			collectState(ch, info)
+		}
+		if enabled(FamilyImage) && info.ContainerJSONBase != nil {
+			collectImage(ch, info, c.imageCreated[info.Image])
+		}
+	}
+}
+
+// previousCPU returns the CPU stats of the previous collection of a container, empty if
+// there is none
+func (c *DockerCollector) previousCPU(containerID string) types.CPUStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, stats := range c.samples {
+		if stats.ID == containerID {
+			return stats.CPUStats
+		}
+	}
+	return types.CPUStats{}
+}
+
+// scrapeError counts a container whose stats couldn't be read
+func (c *DockerCollector) scrapeError(name string) {
+	c.erroredMu.Lock()
+	defer c.erroredMu.Unlock()
+	c.errored[name] = true
+	c.scrapeErrors.WithLabelValues(name).Inc()
+}
+
+// forgetErrors deletes the scrape error series of containers that no longer exist
+func (c *DockerCollector) forgetErrors(names map[string]bool) {
+	c.erroredMu.Lock()
+	defer c.erroredMu.Unlock()
+	for name := range c.errored {
+		if !names[name] {
+			c.scrapeErrors.DeleteLabelValues(name)
+			delete(c.errored, name)
+		}
+	}
+}
+
+// imagesCreated returns when the images of the containers were built, inspecting only the
+// images missing from the cache. Images no longer in use are dropped from the result.
+func (c *DockerCollector) imagesCreated(ctx context.Context, states []types.ContainerJSON) map[string]time.Time {
+	c.mu.RLock()
+	cached := c.imageCreated
+	c.mu.RUnlock()
+
+	imageCreated := make(map[string]time.Time)
+	for _, info := range states {
+		if info.ContainerJSONBase == nil {
+			continue
+		}
+		if created, ok := cached[info.Image]; ok {
+			imageCreated[info.Image] = created
+			continue
+		}
+		if _, ok := imageCreated[info.Image]; ok {
+			continue
+		}
+
+		inspect, _, err := c.cli.ImageInspectWithRaw(ctx, info.Image)
+		if err != nil {
+			log.Warnf("Error inspecting image %s: %v", info.Image, err)
+			c.apiErrors.WithLabelValues("image_inspect").Inc()
+			continue
+		}
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			log.Warnf("Error reading creation time of image %s: %v", info.Image, err)
+			continue
+		}
+		imageCreated[info.Image] = created
+	}
+	return imageCreated
+}
+
+// containerStats reads a single stats sample of a container. In one-shot mode the daemon
+// returns right away without a previous CPU sample, the CPU usage is then computed against
+// the sample of the previous collection.
+func (c *DockerCollector) containerStats(ctx context.Context, containerID string) (types.StatsJSON, error) {
+	var stats types.StatsJSON
+	var resp container.StatsResponse
+	var err error
+	oneShot := oneShotStats.Load()
+	if oneShot {
+		resp, err = c.cli.ContainerStatsOneShot(ctx, containerID)
+	} else {
+		resp, err = c.cli.ContainerStats(ctx, containerID, false)
+	}
+	if err != nil {
+		c.apiErrors.WithLabelValues("stats").Inc()
+		return stats, fmt.Errorf("could not fetch stats for container %s: %v", containerID, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("could not read stats for container %s: %v", containerID, err)
+	}
+	if oneShot {
+		stats.PreCPUStats = c.previousCPU(containerID)
+	}
+	return stats, nil
 }
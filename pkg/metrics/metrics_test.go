@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestCPUPercent(t *testing.T) {
+	var stats types.StatsJSON
+	stats.PreCPUStats.CPUUsage.TotalUsage = 100
+	stats.PreCPUStats.SystemUsage = 1000
+	stats.CPUStats.CPUUsage.TotalUsage = 200
+	stats.CPUStats.SystemUsage = 2000
+	stats.CPUStats.OnlineCPUs = 4
+
+	if got := cpuPercent(stats); got != 40 {
+		t.Errorf("Expected 40%%, got %v", got)
+	}
+
+	// the system usage must advance, e.g. the first one-shot collection has no previous sample
+	stats.CPUStats.SystemUsage = 1000
+	if got := cpuPercent(stats); got != 0 {
+		t.Errorf("Expected 0%% without a system delta, got %v", got)
+	}
+}
+
+func TestMemoryStat(t *testing.T) {
+	var v1, v2 types.StatsJSON
+	v1.MemoryStats.Stats = map[string]uint64{"cache": 10, "rss": 20}
+	v2.MemoryStats.Stats = map[string]uint64{"file": 30, "anon": 40, "inactive_file": 5}
+
+	if got := memoryStat(v1, "rss", "anon"); got != 20 {
+		t.Errorf("Expected rss 20 on cgroup v1, got %v", got)
+	}
+	if got := memoryStat(v2, "rss", "anon"); got != 40 {
+		t.Errorf("Expected anon 40 on cgroup v2, got %v", got)
+	}
+	if got := memoryStat(v2, "cache", "inactive_file"); got != 5 {
+		t.Errorf("Expected inactive_file 5 on cgroup v2, got %v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aggregateNetwork keeps exporting docker_network_rx_bytes_total and
+// docker_network_tx_bytes_total, the totals over all interfaces, next to the per-interface metrics
+var aggregateNetwork atomic.Bool
+
+func init() {
+	aggregateNetwork.Store(true)
+}
+
+// SetAggregateNetwork sets whether the network totals over all interfaces are exported
+func SetAggregateNetwork(enabled bool) {
+	aggregateNetwork.Store(enabled)
+}
+
+// interfaceMetric is a network metric exported per container interface
+type interfaceMetric struct {
+	desc  *prometheus.Desc
+	value func(stats types.NetworkStats) uint64
+}
+
+func newInterfaceMetric(name string, help string, value func(stats types.NetworkStats) uint64) interfaceMetric {
+	return interfaceMetric{
+		desc:  prometheus.NewDesc(name, help, []string{"container_id", "container_name", "interface"}, nil),
+		value: value,
+	}
+}
+
+var interfaceMetrics = []interfaceMetric{
+	newInterfaceMetric("docker_network_interface_rx_bytes_total", "Bytes received on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.RxBytes }),
+	newInterfaceMetric("docker_network_interface_rx_packets_total", "Packets received on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.RxPackets }),
+	newInterfaceMetric("docker_network_interface_rx_errors_total", "Receive errors on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.RxErrors }),
+	newInterfaceMetric("docker_network_interface_rx_dropped_total", "Incoming packets dropped on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.RxDropped }),
+	newInterfaceMetric("docker_network_interface_tx_bytes_total", "Bytes transmitted on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.TxBytes }),
+	newInterfaceMetric("docker_network_interface_tx_packets_total", "Packets transmitted on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.TxPackets }),
+	newInterfaceMetric("docker_network_interface_tx_errors_total", "Transmit errors on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.TxErrors }),
+	newInterfaceMetric("docker_network_interface_tx_dropped_total", "Outgoing packets dropped on a network interface of Docker containers",
+		func(s types.NetworkStats) uint64 { return s.TxDropped }),
+}
+
+// collectInterfaces sends the per-interface network metrics of a stats sample
+func collectInterfaces(ch chan<- prometheus.Metric, stats types.StatsJSON) {
+	for iface, network := range stats.Networks {
+		for _, metric := range interfaceMetrics {
+			ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.CounterValue, float64(metric.value(network)), stats.ID, stats.Name, iface)
+		}
+	}
+}
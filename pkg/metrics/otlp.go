@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// DefaultOTLPInterval is how often metrics are exported over OTLP unless configured otherwise
+const DefaultOTLPInterval = time.Minute
+
+// StartOTLP exports all metrics of gatherer over OTLP/HTTP to endpoint, a URL such as
+// http://otel-collector:4318, every interval in the background. headers are sent with every
+// export, e.g. for authentication. The returned function exports the last interval and stops
+// the export.
+func StartOTLP(ctx context.Context, gatherer prometheus.Gatherer, endpoint string, headers map[string]string, interval time.Duration) (func(context.Context) error, error) {
+	if interval <= 0 {
+		interval = DefaultOTLPInterval
+	}
+
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint), otlpmetrichttp.WithHeaders(headers))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+	}
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithProducer(promBridge.NewMetricProducer(promBridge.WithGatherer(gatherer))),
+	)
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+)
+
+// Push defaults
+const (
+	DefaultPushJob      = "docker_manager"
+	DefaultPushInterval = time.Minute
+)
+
+// RunPush pushes all metrics of gatherer to the Pushgateway at url every interval until ctx
+// is cancelled, for managers that can't be scraped. Metrics are grouped by job and the
+// host name as instance, so managers on several hosts don't replace each other's metrics.
+func RunPush(ctx context.Context, gatherer prometheus.Gatherer, url string, job string, interval time.Duration) {
+	if job == "" {
+		job = DefaultPushJob
+	}
+	if interval <= 0 {
+		interval = DefaultPushInterval
+	}
+	pusher := push.New(url, job).Gatherer(gatherer)
+	if hostname, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", hostname)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pusher.PushContext(ctx); err != nil {
+			log.Errorf("Error pushing metrics to %s: %v", url, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
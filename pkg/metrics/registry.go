@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// NewRegistry creates the registry served on /metrics. Go runtime and process metrics are
+// only included with runtime set.
+func NewRegistry(runtime bool) (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+	if runtime {
+		if err := Register(reg, collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// Wrap returns a Registerer that prefixes all metrics registered through it with namespace
+// and adds constLabels to them, e.g. host or environment to tell apart the series of many
+// hosts. Both are optional.
+func Wrap(reg prometheus.Registerer, namespace string, constLabels map[string]string) prometheus.Registerer {
+	if len(constLabels) > 0 {
+		reg = prometheus.WrapRegistererWith(constLabels, reg)
+	}
+	if namespace != "" {
+		reg = prometheus.WrapRegistererWithPrefix(namespace+"_", reg)
+	}
+	return reg
+}
+
+// Register registers collectors with reg, failing on the first collector that can't be
+// registered, e.g. because its metrics clash with ones already registered
+func Register(reg prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return fmt.Errorf("error registering metrics: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// containerStates and healthStatuses are exported as enums, the current one is 1
+var (
+	containerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+	healthStatuses  = []string{"none", "starting", "healthy", "unhealthy"}
+)
+
+var (
+	containerStateDesc = prometheus.NewDesc("docker_container_state",
+		"State of Docker containers, 1 for the current state",
+		[]string{"container_id", "container_name", "state"}, nil)
+	containerHealthDesc = prometheus.NewDesc("docker_container_health_status",
+		"Health status of Docker containers, 1 for the current status, none without a healthcheck",
+		[]string{"container_id", "container_name", "status"}, nil)
+	containerRestartsDesc = prometheus.NewDesc("docker_container_restart_count",
+		"Number of times Docker restarted the container",
+		[]string{"container_id", "container_name"}, nil)
+	containerStartTimeDesc = prometheus.NewDesc("docker_container_start_time_seconds",
+		"Unix time the container was last started",
+		[]string{"container_id", "container_name"}, nil)
+	containerUptimeDesc = prometheus.NewDesc("docker_container_uptime_seconds",
+		"Seconds since the container was last started, 0 when it isn't running",
+		[]string{"container_id", "container_name"}, nil)
+	containerExitCodeDesc = prometheus.NewDesc("docker_container_exit_code",
+		"Exit code of the last run of the container, 0 while running",
+		[]string{"container_id", "container_name"}, nil)
+	containerOOMKilledDesc = prometheus.NewDesc("docker_container_oom_killed",
+		"1 if the last run of the container was killed for running out of memory",
+		[]string{"container_id", "container_name"}, nil)
+	containerImageCreatedDesc = prometheus.NewDesc("docker_container_image_created_timestamp",
+		"Unix time the image the container runs was built",
+		[]string{"container_id", "container_name", "image"}, nil)
+)
+
+// collectState sends the state, health and restart count metrics of an inspected container
+func collectState(ch chan<- prometheus.Metric, info types.ContainerJSON) {
+	if info.ContainerJSONBase == nil || info.State == nil {
+		return
+	}
+	// the name is kept as Docker reports it so it matches the container_name of the stats metrics
+	name := info.Name
+
+	health := "none"
+	if info.State.Health != nil {
+		health = info.State.Health.Status
+	}
+
+	enum(ch, containerStateDesc, containerStates, info.State.Status, info.ID, name)
+	enum(ch, containerHealthDesc, healthStatuses, health, info.ID, name)
+	ch <- prometheus.MustNewConstMetric(containerRestartsDesc, prometheus.GaugeValue, float64(info.RestartCount), info.ID, name)
+	ch <- prometheus.MustNewConstMetric(containerExitCodeDesc, prometheus.GaugeValue, float64(info.State.ExitCode), info.ID, name)
+	var oomKilled float64
+	if info.State.OOMKilled {
+		oomKilled = 1
+	}
+	ch <- prometheus.MustNewConstMetric(containerOOMKilledDesc, prometheus.GaugeValue, oomKilled, info.ID, name)
+
+	// StartedAt is the zero time in RFC3339 for containers that never started
+	startedAt, err := time.Parse(time.RFC3339Nano, info.State.StartedAt)
+	if err != nil || startedAt.Year() <= 1 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(containerStartTimeDesc, prometheus.GaugeValue, float64(startedAt.Unix()), info.ID, name)
+	var uptime float64
+	if info.State.Running {
+		uptime = time.Since(startedAt).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(containerUptimeDesc, prometheus.GaugeValue, uptime, info.ID, name)
+}
+
+// enum sends one sample per value, 1 for current and 0 for the others
+func enum(ch chan<- prometheus.Metric, desc *prometheus.Desc, values []string, current string, id string, name string) {
+	for _, value := range values {
+		var v float64
+		if value == current {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, id, name, value)
+	}
+}
+
+// collectImage sends when the image of an inspected container was built, if known
+func collectImage(ch chan<- prometheus.Metric, info types.ContainerJSON, imageCreated time.Time) {
+	if imageCreated.IsZero() || info.Config == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(containerImageCreatedDesc, prometheus.GaugeValue, float64(imageCreated.Unix()), info.ID, info.Name, info.Config.Image)
+}
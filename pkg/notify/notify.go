@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kind is the type of event a notification is sent for
+type Kind string
+
+const (
+	// KindSignatureFailed is sent when an image update is refused because its signature didn't verify
+	KindSignatureFailed Kind = "signature_failed"
+	// KindVulnerableImage is sent when an image update is refused because of its vulnerabilities
+	KindVulnerableImage Kind = "vulnerable_image"
+	// KindUpdateAvailable is sent when a container in notify update mode has a newer image
+	KindUpdateAvailable Kind = "update_available"
+)
+
+// Event is posted as JSON to every configured webhook
+type Event struct {
+	Time      time.Time `json:"time"`
+	Kind      Kind      `json:"kind"`
+	Container string    `json:"container,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// timeout bounds a single webhook request
+const timeout = 10 * time.Second
+
+var (
+	webhooks   []string
+	webhooksMu sync.RWMutex
+
+	httpClient = &http.Client{Timeout: timeout}
+)
+
+// Configure replaces the webhook URLs notifications are posted to
+func Configure(urls []string) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	webhooks = urls
+}
+
+// Send posts event to every configured webhook in the background. Failures are logged but
+// never returned, a notification must not fail the action it reports on.
+func Send(event Event) {
+	webhooksMu.RLock()
+	urls := webhooks
+	webhooksMu.RUnlock()
+	if len(urls) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Error encoding notification: %v", err)
+		return
+	}
+
+	for _, url := range urls {
+		go func() {
+			if err := post(url, body); err != nil {
+				log.Errorf("Error sending notification to %s: %v", url, err)
+			}
+		}()
+	}
+}
+
+func post(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
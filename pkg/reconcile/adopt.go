@@ -0,0 +1,62 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// adopted reports whether a container without the ownership label was adopted earlier
+func (r *Reconciler) adopted(container types.Container) bool {
+	if r.state == nil {
+		return false
+	}
+	record, err := r.state.Container(strings.TrimPrefix(container.Names[0], "/"))
+	if err != nil {
+		log.Errorf("Error reading state of container %s: %v", container.Names[0], err)
+		return false
+	}
+	return record != nil && record.Adopted && record.ContainerID == container.ID
+}
+
+// adopt takes ownership of an existing container that matches its config but lacks the
+// ownership label. Docker can't change labels of an existing container, so the adoption is
+// recorded in the state store and the labels are added the next time the container is recreated.
+func (r *Reconciler) adopt(ctx context.Context, existing types.Container, spec docker.ContainerConfig) error {
+	if r.state == nil {
+		return fmt.Errorf("container %s can't be adopted without a state store", spec.Name)
+	}
+
+	inspect, err := r.cli.ContainerInspect(ctx, existing.ID)
+	if err != nil {
+		return err
+	}
+	if drift := detectDrift(inspect, spec); len(drift) > 0 {
+		return fmt.Errorf("container %s exists but is not managed by docker-manager and differs from config (%s), not adopting it", spec.Name, driftFields(drift))
+	}
+
+	err = r.state.PutContainer(state.ContainerRecord{
+		Name:        spec.Name,
+		Entry:       spec.Entry,
+		ContainerID: existing.ID,
+		ConfigHash:  spec.Hash(),
+		Image:       spec.Image,
+		ImageID:     inspect.Image,
+		UpdatedAt:   time.Now(),
+		Adopted:     true,
+	})
+	r.audit(ctx, audit.Entry{Action: audit.ActionAdopt, Container: spec.Name, Reason: "matches config", NewImage: spec.Image}, err)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Adopted existing container %s", spec.Name)
+	return nil
+}
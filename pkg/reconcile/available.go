@@ -0,0 +1,85 @@
+package reconcile
+
+import (
+	"sort"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/events"
+	"github.com/huxcrux/docker-manager/pkg/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// AvailableUpdate is a newer image found but not applied, because the container is in
+// notify update mode or the update was deferred until a maintenance window
+type AvailableUpdate struct {
+	Container string `json:"container"`
+	// Image is the image reference the container would be updated to
+	Image        string `json:"image"`
+	RunningImage string `json:"running_image"`
+	LatestImage  string `json:"latest_image"`
+	// Since is when the latest image was first found
+	Since time.Time `json:"since"`
+}
+
+// markAvailable records an update that isn't applied for reason, notifying once per new image
+func (r *Reconciler) markAvailable(update pendingUpdate, reason string) {
+	r.availableMu.Lock()
+	previous, ok := r.available[update.spec.Name]
+	if ok && previous.LatestImage == update.latestImage {
+		r.availableMu.Unlock()
+		return
+	}
+	available := AvailableUpdate{
+		Container:    update.spec.Name,
+		Image:        update.spec.Image,
+		RunningImage: update.runningImage,
+		LatestImage:  update.latestImage,
+		Since:        time.Now(),
+	}
+	r.available[update.spec.Name] = available
+	r.availableMu.Unlock()
+
+	log.Infof("Container %s has an update available (%s), not applying it: %s", available.Container, available.LatestImage, reason)
+	r.metrics.SetUpdateAvailable(available.Container, true)
+	r.events.Publish(events.Event{Type: events.TypeUpdateAvailable, Container: available.Container, Message: available.LatestImage + " (" + reason + ")"})
+	notify.Send(notify.Event{
+		Kind:      notify.KindUpdateAvailable,
+		Container: available.Container,
+		Image:     available.Image,
+		Message:   "newer image " + available.LatestImage + " is available",
+	})
+}
+
+// clearAvailable forgets the available update of a container that is up to date or gone
+func (r *Reconciler) clearAvailable(name string) {
+	r.availableMu.Lock()
+	_, ok := r.available[name]
+	delete(r.available, name)
+	r.availableMu.Unlock()
+
+	if ok {
+		r.metrics.SetUpdateAvailable(name, false)
+	}
+}
+
+// pruneAvailable forgets available updates of containers that are no longer configured
+func (r *Reconciler) pruneAvailable(configured map[string]bool) {
+	for _, update := range r.Available() {
+		if !configured[update.Container] {
+			r.clearAvailable(update.Container)
+		}
+	}
+}
+
+// Available returns the updates found but not applied
+func (r *Reconciler) Available() []AvailableUpdate {
+	r.availableMu.RLock()
+	defer r.availableMu.RUnlock()
+
+	updates := make([]AvailableUpdate, 0, len(r.available))
+	for _, update := range r.available {
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Container < updates[j].Container })
+	return updates
+}
@@ -0,0 +1,224 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// Drift is a single setting where a container deviates from its config
+type Drift struct {
+	Field   string `json:"field"`
+	Desired any    `json:"desired"`
+	Actual  any    `json:"actual"`
+}
+
+// detectDrift compares a container with the config it should be running
+func detectDrift(inspect types.ContainerJSON, config docker.ContainerConfig) []Drift {
+	var drift []Drift
+
+	// Check environment variables
+	// Some env vars is set by container. We need to match the ones we care about. Unclear how we track vars that is unset over time.
+	// Skipping for now and will return to this later on.
+	//if !reflect.DeepEqual(inspect.Config.Env, config.Env) {
+	//	log.Debugf("Container %s environment does not match\n", config.Name)
+	//	needsUpdate = true
+	//}
+
+	// Check port bindings
+	if !reflect.DeepEqual(inspect.Config.ExposedPorts, config.ExposedPorts) {
+		log.Debugf("Container %s exposed ports do not match\n", config.Name)
+		drift = append(drift, Drift{Field: "exposed_ports", Desired: config.ExposedPorts, Actual: inspect.Config.ExposedPorts})
+	}
+	if !reflect.DeepEqual(inspect.HostConfig.PortBindings, config.PortBindings) {
+		log.Debugf("Container %s port bindings do not match\n", config.Name)
+		drift = append(drift, Drift{Field: "port_bindings", Desired: config.PortBindings, Actual: inspect.HostConfig.PortBindings})
+	}
+
+	// Check image, containers created by digest record the configured image in a label
+	image := inspect.Config.Image
+	if configured, ok := inspect.Config.Labels[docker.LabelImage]; ok {
+		image = configured
+	}
+	if !reflect.DeepEqual(image, config.Image) {
+		log.Debugf("Container %s image does not match\n", config.Name)
+		drift = append(drift, Drift{Field: "image", Desired: config.Image, Actual: image})
+	}
+	// Containers pinned through the API run their digest either way
+	if pinned := inspect.Config.Labels[docker.LabelDigest] != ""; pinned != config.PinDigest && !config.Pinned {
+		drift = append(drift, Drift{Field: "pin_digest", Desired: config.PinDigest, Actual: pinned})
+	}
+
+	// Check resource limits
+	resources := inspect.HostConfig.Resources
+	if resources.Memory != config.Resources.Memory {
+		drift = append(drift, Drift{Field: "memory", Desired: config.Resources.Memory, Actual: resources.Memory})
+	}
+	if resources.NanoCPUs != config.Resources.NanoCPUs {
+		drift = append(drift, Drift{Field: "cpus", Desired: float64(config.Resources.NanoCPUs) / 1e9, Actual: float64(resources.NanoCPUs) / 1e9})
+	}
+	if config.Resources.CPUShares != 0 && resources.CPUShares != config.Resources.CPUShares {
+		drift = append(drift, Drift{Field: "cpu_shares", Desired: config.Resources.CPUShares, Actual: resources.CPUShares})
+	}
+	var pidsLimit int64
+	if resources.PidsLimit != nil && *resources.PidsLimit > 0 {
+		pidsLimit = *resources.PidsLimit
+	}
+	if pidsLimit != config.Resources.PidsLimit {
+		drift = append(drift, Drift{Field: "pids_limit", Desired: config.Resources.PidsLimit, Actual: pidsLimit})
+	}
+
+	// Check command
+	if config.Cmd != nil {
+		if !reflect.DeepEqual([]string(inspect.Config.Cmd), config.Cmd) {
+			log.Debugf("Container %s command does not match\n", config.Name)
+			drift = append(drift, Drift{Field: "cmd", Desired: config.Cmd, Actual: inspect.Config.Cmd})
+		}
+	}
+
+	// Check networks, containers without configured networks stay on Docker's default
+	if len(config.Networks) > 0 {
+		var actual []string
+		if inspect.NetworkSettings != nil {
+			for name := range inspect.NetworkSettings.Networks {
+				actual = append(actual, name)
+			}
+		}
+		desired := append([]string(nil), config.Networks...)
+		sort.Strings(actual)
+		sort.Strings(desired)
+		if !reflect.DeepEqual(actual, desired) {
+			drift = append(drift, Drift{Field: "networks", Desired: desired, Actual: actual})
+		}
+	}
+
+	// Check mounts, anonymous volumes declared by the image are ignored
+	for _, desired := range config.Mounts {
+		if !hasMount(inspect.Mounts, desired) {
+			drift = append(drift, Drift{Field: "volumes", Desired: config.Mounts, Actual: inspect.Mounts})
+			break
+		}
+	}
+
+	return drift
+}
+
+// hasMount reports whether the container has the desired mount
+func hasMount(mounts []types.MountPoint, desired mount.Mount) bool {
+	for _, m := range mounts {
+		if m.Type != desired.Type || m.Destination != desired.Target || m.RW == desired.ReadOnly {
+			continue
+		}
+		if (desired.Type == mount.TypeVolume && m.Name == desired.Source) || (desired.Type == mount.TypeBind && m.Source == desired.Source) {
+			return true
+		}
+	}
+	return false
+}
+
+// driftFields lists the fields of drift as a comma separated string
+func driftFields(drift []Drift) string {
+	return strings.Join(driftReasons(drift), ", ")
+}
+
+// driftReasons lists the fields of drift
+func driftReasons(drift []Drift) []string {
+	fields := make([]string, 0, len(drift))
+	for _, d := range drift {
+		fields = append(fields, d.Field)
+	}
+	return fields
+}
+
+// updatableInPlace reports whether drift can be applied with ContainerUpdate instead of a
+// recreate: the container uses the in-place drift strategy and only resource limits differ.
+// Removing a memory limit requires a recreate since ContainerUpdate treats 0 as unchanged.
+func updatableInPlace(spec docker.ContainerConfig, drift []Drift) bool {
+	if spec.DriftStrategy != config.DriftStrategyInPlace {
+		return false
+	}
+	for _, d := range drift {
+		switch d.Field {
+		case "cpus", "cpu_shares", "pids_limit":
+		case "memory":
+			if spec.Resources.Memory == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ContainerDrift is a container whose live state differs from its config
+type ContainerDrift struct {
+	Name string `json:"name"`
+	// Drift are the differing settings. Missing and unwanted containers differ in "exists",
+	// containers not in their desired running or stopped state in "state".
+	Drift []Drift `json:"drift"`
+	// Frozen containers keep their drift until they are unfrozen
+	Frozen bool `json:"frozen,omitempty"`
+}
+
+// Drift lists the containers of cfg that differ from their config, and the managed containers
+// a reconcile would remove. Nothing is changed.
+func (r *Reconciler) Drift(ctx context.Context, cfg *config.Config) ([]ContainerDrift, error) {
+	statuses, err := r.Containers(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	drifted := containerDrift(statuses)
+	if !cfg.AppConfig.RemoveUnwantedContainers {
+		return drifted, nil
+	}
+
+	configured := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		configured[status.Name] = true
+	}
+	containers, err := docker.ListAllContariners(r.cli)
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range containers {
+		name := strings.TrimPrefix(container.Names[0], "/")
+		if configured[name] || !r.owns(container) || r.isProtected(name) {
+			continue
+		}
+		drifted = append(drifted, ContainerDrift{Name: name, Drift: []Drift{{Field: "exists", Desired: false, Actual: true}}})
+	}
+	return drifted, nil
+}
+
+// containerDrift picks the configured containers that differ from their config. Available
+// updates are not drift, the config still names the image the container runs.
+func containerDrift(statuses []ContainerStatus) []ContainerDrift {
+	drifted := []ContainerDrift{}
+	for _, status := range statuses {
+		var drift []Drift
+		if status.Actual == nil {
+			drift = append(drift, Drift{Field: "exists", Desired: true, Actual: false})
+		} else {
+			desired := "running"
+			if status.Stopped {
+				desired = "stopped"
+			}
+			if running := status.Actual.State == "running"; running == status.Stopped {
+				drift = append(drift, Drift{Field: "state", Desired: desired, Actual: status.Actual.State})
+			}
+			drift = append(drift, status.Drift...)
+		}
+		if len(drift) > 0 {
+			drifted = append(drifted, ContainerDrift{Name: status.Name, Drift: drift, Frozen: status.Frozen})
+		}
+	}
+	return drifted
+}
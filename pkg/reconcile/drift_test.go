@@ -0,0 +1,27 @@
+package reconcile
+
+import "testing"
+
+func TestContainerDrift(t *testing.T) {
+	statuses := []ContainerStatus{
+		{Name: "missing"},
+		{Name: "in-sync", Actual: &ActualState{State: "running"}, UpdateAvailable: &AvailableUpdate{}},
+		{Name: "stopped", Stopped: true, Actual: &ActualState{State: "exited"}},
+		{Name: "crashed", Actual: &ActualState{State: "exited"}},
+		{Name: "drifted", Frozen: true, Actual: &ActualState{State: "running"}, Drift: []Drift{{Field: "memory", Desired: int64(1), Actual: int64(0)}}},
+	}
+
+	drifted := containerDrift(statuses)
+	if len(drifted) != 3 {
+		t.Fatalf("Expected 3 drifted containers, got %+v", drifted)
+	}
+	if drifted[0].Name != "missing" || drifted[0].Drift[0].Field != "exists" {
+		t.Errorf("Expected missing to not exist, got %+v", drifted[0])
+	}
+	if drifted[1].Name != "crashed" || drifted[1].Drift[0].Field != "state" || drifted[1].Drift[0].Actual != "exited" {
+		t.Errorf("Expected crashed to differ in state, got %+v", drifted[1])
+	}
+	if drifted[2].Name != "drifted" || !drifted[2].Frozen || drifted[2].Drift[0].Field != "memory" {
+		t.Errorf("Expected the memory drift of drifted, got %+v", drifted[2])
+	}
+}
@@ -0,0 +1,68 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+// ErrNotRunning is returned for commands on containers that aren't running
+var ErrNotRunning = errors.New("container is not running")
+
+// ExecSession is a command started by ExecAttach
+type ExecSession struct {
+	ID   string
+	Conn types.HijackedResponse
+}
+
+// Exec runs cmd in the configured container name, waits for it to finish and records it in
+// the audit log
+func (r *Reconciler) Exec(ctx context.Context, cfg *config.Config, name string, cmd []string, timeout time.Duration) (docker.ExecResult, error) {
+	id, err := r.runningContainer(ctx, cfg, name)
+	if err != nil {
+		return docker.ExecResult{}, err
+	}
+
+	result, err := docker.Exec(r.cli, id, cmd, timeout)
+	r.audit(ctx, audit.Entry{Action: audit.ActionExec, Container: name, Reason: strings.Join(cmd, " ")}, err)
+	return result, err
+}
+
+// ExecAttach starts cmd in the configured container name attached to stdin, records it in
+// the audit log and returns the session, which the caller must close
+func (r *Reconciler) ExecAttach(ctx context.Context, cfg *config.Config, name string, cmd []string, tty bool) (*ExecSession, error) {
+	id, err := r.runningContainer(ctx, cfg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	execID, conn, err := docker.ExecAttach(ctx, r.cli, id, cmd, tty)
+	r.audit(ctx, audit.Entry{Action: audit.ActionExec, Container: name, Reason: "interactive: " + strings.Join(cmd, " ")}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecSession{ID: execID, Conn: conn}, nil
+}
+
+// ExitCode returns the exit code of a finished session
+func (r *Reconciler) ExitCode(ctx context.Context, session *ExecSession) (int, error) {
+	return docker.ExecExitCode(ctx, r.cli, session.ID)
+}
+
+// runningContainer returns the ID of the configured container name if it is running
+func (r *Reconciler) runningContainer(ctx context.Context, cfg *config.Config, name string) (string, error) {
+	inspect, err := r.configuredContainer(ctx, cfg, name)
+	if err != nil {
+		return "", err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return "", ErrNotRunning
+	}
+	return inspect.ID, nil
+}
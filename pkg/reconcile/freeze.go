@@ -0,0 +1,114 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const frozenFile = "frozen.json"
+
+// Freeze marks a container as frozen so reconciles leave it as-is until Unfreeze is called.
+// The frozen set is persisted in the state directory, a failed save leaves it unchanged.
+func (r *Reconciler) Freeze(name string) error {
+	r.frozenMu.Lock()
+	defer r.frozenMu.Unlock()
+
+	if r.frozen[name] {
+		return r.saveFrozen()
+	}
+	r.frozen[name] = true
+	if err := r.saveFrozen(); err != nil {
+		delete(r.frozen, name)
+		return err
+	}
+	return nil
+}
+
+// Unfreeze clears a freeze set through Freeze. Containers frozen in config stay frozen.
+func (r *Reconciler) Unfreeze(name string) error {
+	r.frozenMu.Lock()
+	defer r.frozenMu.Unlock()
+
+	if !r.frozen[name] {
+		return r.saveFrozen()
+	}
+	delete(r.frozen, name)
+	if err := r.saveFrozen(); err != nil {
+		r.frozen[name] = true
+		return err
+	}
+	return nil
+}
+
+// Frozen returns the names of containers frozen through Freeze
+func (r *Reconciler) Frozen() []string {
+	r.frozenMu.RLock()
+	defer r.frozenMu.RUnlock()
+
+	names := make([]string, 0, len(r.frozen))
+	for name := range r.frozen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Reconciler) isFrozen(name string) bool {
+	r.frozenMu.RLock()
+	defer r.frozenMu.RUnlock()
+	return r.frozen[name]
+}
+
+// saveFrozen writes the frozen set to disk, the caller must hold frozenMu
+func (r *Reconciler) saveFrozen() error {
+	return r.saveNames(frozenFile, r.frozen)
+}
+
+// loadFrozen restores the persisted frozen set
+func (r *Reconciler) loadFrozen() (err error) {
+	r.frozen, err = r.loadNames(frozenFile)
+	return err
+}
+
+// saveNames writes a set of container names to file in the state directory
+func (r *Reconciler) saveNames(file string, set map[string]bool) error {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.stateDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.stateDir, file), data, 0o644)
+}
+
+// loadNames reads a set of container names written by saveNames, a missing file is empty
+func (r *Reconciler) loadNames(file string) (map[string]bool, error) {
+	set := make(map[string]bool)
+
+	data, err := os.ReadFile(filepath.Join(r.stateDir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		set[name] = true
+	}
+	return set, nil
+}
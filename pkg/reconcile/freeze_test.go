@@ -0,0 +1,26 @@
+package reconcile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreezeKeepsSetOnSaveError(t *testing.T) {
+	// a file where the state directory should be fails every save
+	stateDir := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(stateDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Reconciler{stateDir: stateDir, frozen: map[string]bool{"db": true}}
+
+	if err := r.Freeze("web"); err == nil {
+		t.Fatalf("Expected saving the frozen set to fail")
+	}
+	if err := r.Unfreeze("db"); err == nil {
+		t.Fatalf("Expected saving the frozen set to fail")
+	}
+	if r.isFrozen("web") || !r.isFrozen("db") {
+		t.Errorf("Expected the frozen set to be unchanged, got %v", r.Frozen())
+	}
+}
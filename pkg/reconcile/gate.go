@@ -0,0 +1,133 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/notify"
+	"github.com/huxcrux/docker-manager/pkg/scan"
+	"github.com/huxcrux/docker-manager/pkg/verify"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultScanSeverity is the lowest vulnerability severity that blocks an update
+	DefaultScanSeverity = "high"
+	// DefaultScanTimeout bounds a single vulnerability scan
+	DefaultScanTimeout = 5 * time.Minute
+)
+
+// gateImage refuses the image an update would roll out if its signature doesn't verify or it
+// has vulnerabilities above the configured severity. The digest reference is checked, so
+// the result covers exactly the image that is deployed.
+func (r *Reconciler) gateImage(ctx context.Context, spec docker.ContainerConfig, imageID string) error {
+	verifies := spec.Verify.Enabled()
+	scans := r.appConfig.VulnerabilityScan.Enabled && !spec.SkipScan
+	if !verifies && !scans {
+		return nil
+	}
+
+	// Locally built images have no digest, they can be scanned by tag but not verified
+	ref := spec.Digest
+	if ref == "" {
+		var err error
+		ref, err = docker.ImageDigest(r.cli, imageID, spec.Image)
+		if err != nil && verifies {
+			return err
+		}
+		if err != nil {
+			ref = spec.Image
+		}
+	}
+
+	if verifies {
+		if err := r.verifySignature(ctx, spec, ref, imageID); err != nil {
+			return err
+		}
+	}
+	if scans {
+		if err := r.scanImage(ctx, spec, ref, imageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySignature verifies the cosign signature of ref. Images that were refused are
+// remembered, so they are not verified and alerted on again every reconcile.
+func (r *Reconciler) verifySignature(ctx context.Context, spec docker.ContainerConfig, ref string, imageID string) error {
+	key := strings.Join([]string{imageID, spec.Verify.Key, spec.Verify.Identity, spec.Verify.Issuer}, " ")
+	if err, ok := r.unverified[key]; ok {
+		return err
+	}
+
+	progress(ctx, Event{Step: "verifying", Container: spec.Name, Message: "signature of " + ref})
+	err := verify.Verify(ctx, ref, spec.Verify)
+	if err != nil {
+		r.unverified[key] = err
+		log.Errorf("Refusing to update container %s: %v", spec.Name, err)
+		r.metrics.SignatureFailure(spec.Name)
+		notify.Send(notify.Event{
+			Kind:      notify.KindSignatureFailed,
+			Container: spec.Name,
+			Image:     ref,
+			Message:   err.Error(),
+		})
+		return err
+	}
+
+	log.Infof("Signature of %s verified for container %s", ref, spec.Name)
+	return nil
+}
+
+// scanImage scans ref for vulnerabilities. Images that were refused are remembered, so they
+// are not scanned again every reconcile. A scan that fails to run refuses the update as well.
+func (r *Reconciler) scanImage(ctx context.Context, spec docker.ContainerConfig, ref string, imageID string) error {
+	severity := spec.ScanSeverity
+	if severity == "" {
+		severity = DefaultScanSeverity
+	}
+	key := imageID + " " + strings.ToLower(severity)
+	if err, ok := r.vulnerable[key]; ok {
+		return err
+	}
+
+	timeout := r.appConfig.VulnerabilityScan.Timeout
+	if timeout <= 0 {
+		timeout = DefaultScanTimeout
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	progress(ctx, Event{Step: "scanning", Container: spec.Name, Message: "image " + ref})
+	vulnerabilities, err := scan.Scan(scanCtx, r.appConfig.VulnerabilityScan.Scanner, r.appConfig.VulnerabilityScan.Path, ref)
+	if err != nil {
+		return err
+	}
+
+	found := scan.AtLeast(vulnerabilities, severity)
+	if len(found) == 0 {
+		log.Infof("Image %s of container %s has no vulnerabilities of severity %s or higher", ref, spec.Name, severity)
+		return nil
+	}
+
+	var ids []string
+	for _, v := range found[:min(len(found), 5)] {
+		ids = append(ids, v.ID)
+	}
+	err = fmt.Errorf("image %s has %d vulnerabilities of severity %s or higher (%s)", ref, len(found), severity, strings.Join(ids, ", "))
+	r.vulnerable[key] = err
+
+	log.Errorf("Refusing to update container %s: %v", spec.Name, err)
+	r.metrics.VulnerableImage(spec.Name)
+	notify.Send(notify.Event{
+		Kind:      notify.KindVulnerableImage,
+		Container: spec.Name,
+		Image:     ref,
+		Message:   err.Error(),
+	})
+	return err
+}
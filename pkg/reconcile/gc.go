@@ -0,0 +1,165 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultGCRetention is how long unused networks and volumes are kept when no retention is configured
+const DefaultGCRetention = 24 * time.Hour
+
+// ensureNetworksAndVolumes creates the networks and named volumes a container needs
+func (r *Reconciler) ensureNetworksAndVolumes(ctx context.Context, spec docker.ContainerConfig) error {
+	for _, name := range spec.Networks {
+		created, err := docker.EnsureNetwork(r.cli, name)
+		if created || err != nil {
+			r.audit(ctx, audit.Entry{Action: audit.ActionCreateNetwork, Container: spec.Name, Resource: name}, err)
+		}
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Infof("Network %s created", name)
+		}
+	}
+
+	for _, m := range spec.Mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+		created, err := docker.EnsureVolume(r.cli, m.Source, spec.Entry)
+		if created || err != nil {
+			r.audit(ctx, audit.Entry{Action: audit.ActionCreateVolume, Container: spec.Name, Resource: m.Source}, err)
+		}
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Infof("Volume %s created", m.Source)
+		}
+	}
+
+	return nil
+}
+
+// collectGarbage removes networks and volumes created by the manager once no configured or
+// existing container has used them for the retention period. When they are first seen unused
+// the time is recorded in the state store, so the grace period survives restarts.
+func (r *Reconciler) collectGarbage(ctx context.Context, configs []docker.ContainerConfig) {
+	if r.state == nil {
+		log.Warn("Garbage collection requires a state store, skipping")
+		return
+	}
+
+	retention := r.appConfig.GarbageCollection.Retention
+	if retention <= 0 {
+		retention = DefaultGCRetention
+	}
+
+	// Everything referenced by config or attached to any container is in use
+	used := make(map[string]bool)
+	for _, spec := range configs {
+		for _, name := range spec.Networks {
+			used["network:"+name] = true
+		}
+		for _, m := range spec.Mounts {
+			if m.Type == mount.TypeVolume {
+				used["volume:"+m.Source] = true
+			}
+		}
+	}
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		log.Errorf("Error listing containers for garbage collection: %v", err)
+		return
+	}
+	for _, c := range containers {
+		if c.NetworkSettings != nil {
+			for name := range c.NetworkSettings.Networks {
+				used["network:"+name] = true
+			}
+		}
+		for _, m := range c.Mounts {
+			if m.Type == mount.TypeVolume {
+				used["volume:"+m.Name] = true
+			}
+		}
+	}
+
+	networks, err := docker.ListManagedNetworks(r.cli)
+	if err != nil {
+		log.Errorf("Error listing networks: %v", err)
+		return
+	}
+	volumes, err := docker.ListManagedVolumes(r.cli)
+	if err != nil {
+		log.Errorf("Error listing volumes: %v", err)
+		return
+	}
+
+	unused, err := r.state.Unused()
+	if err != nil {
+		log.Errorf("Error reading unused resources: %v", err)
+		return
+	}
+
+	existing := make(map[string]bool)
+	now := time.Now()
+	collect := func(kind, name string, remove func() error, action string) {
+		key := kind + ":" + name
+		existing[key] = true
+		if used[key] {
+			if _, ok := unused[key]; ok {
+				r.clearUnused(key)
+			}
+			return
+		}
+
+		since, ok := unused[key]
+		if !ok {
+			log.Infof("Unused %s %s will be removed after %v", kind, name, retention)
+			if err := r.state.MarkUnused(key, now); err != nil {
+				log.Errorf("Error recording unused %s %s: %v", kind, name, err)
+			}
+			return
+		}
+		if now.Sub(since) < retention {
+			return
+		}
+
+		err := remove()
+		r.audit(ctx, audit.Entry{Action: action, Resource: name, Reason: "unused since " + since.Format(time.RFC3339)}, err)
+		if err != nil {
+			log.Errorf("Error removing unused %s %s: %v", kind, name, err)
+			return
+		}
+		log.Infof("Removed unused %s %s", kind, name)
+		r.clearUnused(key)
+	}
+
+	for _, name := range networks {
+		collect("network", name, func() error { return docker.RemoveNetwork(r.cli, name) }, audit.ActionRemoveNetwork)
+	}
+	for _, name := range volumes {
+		collect("volume", name, func() error { return docker.RemoveVolume(r.cli, name) }, audit.ActionRemoveVolume)
+	}
+
+	// Forget resources that were removed outside the manager
+	for key := range unused {
+		if !existing[key] {
+			r.clearUnused(key)
+		}
+	}
+}
+
+func (r *Reconciler) clearUnused(key string) {
+	if err := r.state.ClearUnused(key); err != nil {
+		log.Errorf("Error clearing unused state of %s: %v", key, err)
+	}
+}
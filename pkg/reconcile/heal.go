@@ -0,0 +1,328 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHealInitialBackoff = 5 * time.Second
+	defaultHealMaxBackoff     = 5 * time.Minute
+	defaultHealResetAfter     = 10 * time.Minute
+	eventsReconnectDelay      = 5 * time.Second
+
+	defaultUnhealthyGracePeriod = time.Minute
+)
+
+// healer tracks restart backoff and unhealthy grace periods per container
+type healer struct {
+	mu         sync.Mutex
+	containers map[string]*healState
+	// unhealthy cancels the pending action of containers within their grace period
+	unhealthy map[string]context.CancelFunc
+}
+
+type healState struct {
+	failures    int
+	lastRestart time.Time
+	pending     bool
+}
+
+// AutoHeal watches Docker events and restarts managed containers that exit unexpectedly,
+// independent of the reconcile loop. It blocks until ctx is cancelled. currentConfig must
+// return the config in use so reloads are picked up.
+func (r *Reconciler) AutoHeal(ctx context.Context, currentConfig func() *config.Config) {
+	for {
+		r.watchEvents(ctx, currentConfig)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsReconnectDelay):
+		}
+	}
+}
+
+// watchEvents handles container events until the event stream fails
+func (r *Reconciler) watchEvents(ctx context.Context, currentConfig func() *config.Config) {
+	messages, errs := r.cli.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+			filters.Arg("label", docker.LabelManaged+"=true"),
+		),
+	})
+
+	for {
+		select {
+		case msg := <-messages:
+			r.handleEvent(ctx, msg, currentConfig())
+		case err := <-errs:
+			if ctx.Err() == nil {
+				log.Warnf("Docker event stream failed, reconnecting: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (r *Reconciler) handleEvent(ctx context.Context, msg events.Message, cfg *config.Config) {
+	name := msg.Actor.Attributes["name"]
+
+	switch msg.Action {
+	case events.ActionDie:
+		if !cfg.AppConfig.AutoHeal.Enabled || !r.healable(name, cfg) {
+			return
+		}
+		// Containers stopped by a running reconcile are expected to die
+		if r.reconciling() {
+			return
+		}
+		r.scheduleRestart(ctx, name, fmt.Sprintf("exited with code %s", msg.Actor.Attributes["exitCode"]), cfg.AppConfig.AutoHeal)
+	case events.ActionHealthStatusUnhealthy:
+		if !cfg.AppConfig.AutoHeal.Unhealthy.Enabled || !r.healable(name, cfg) {
+			return
+		}
+		r.watchUnhealthy(ctx, name, cfg)
+	case events.ActionHealthStatusHealthy:
+		r.heal.mu.Lock()
+		if cancel, ok := r.heal.unhealthy[name]; ok {
+			log.Infof("Container %s is healthy again", name)
+			cancel()
+			delete(r.heal.unhealthy, name)
+		}
+		r.heal.mu.Unlock()
+	}
+}
+
+// healable reports whether a container is configured and may be touched outside a reconcile
+func (r *Reconciler) healable(name string, cfg *config.Config) bool {
+	if r.Paused() || r.isFrozen(name) || r.isStopped(name) || matchProtected(compileProtected(cfg.AppConfig.ProtectedContainers), name) {
+		return false
+	}
+
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return false
+	}
+	for _, container := range containers {
+		if container.Name == name {
+			return !container.Frozen
+		}
+	}
+	return false
+}
+
+// reconciling reports whether a reconcile currently holds the run lock
+func (r *Reconciler) reconciling() bool {
+	return len(r.lock) > 0
+}
+
+// scheduleRestart restarts a container after its backoff delay. The delay doubles with
+// every restart and resets once the container stayed up for ResetAfter.
+func (r *Reconciler) scheduleRestart(ctx context.Context, name string, reason string, settings config.AutoHealConfig) {
+	initial := settings.InitialBackoff
+	if initial <= 0 {
+		initial = defaultHealInitialBackoff
+	}
+	maxBackoff := settings.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultHealMaxBackoff
+	}
+	resetAfter := settings.ResetAfter
+	if resetAfter <= 0 {
+		resetAfter = defaultHealResetAfter
+	}
+
+	r.heal.mu.Lock()
+	st, ok := r.heal.containers[name]
+	if !ok {
+		st = &healState{}
+		r.heal.containers[name] = st
+	}
+	if st.pending {
+		r.heal.mu.Unlock()
+		return
+	}
+	if time.Since(st.lastRestart) > resetAfter {
+		st.failures = 0
+	}
+	delay := initial
+	for i := 0; i < st.failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	st.failures++
+	st.pending = true
+	r.heal.mu.Unlock()
+
+	log.Infof("Container %s %s, restarting in %s", name, reason, delay)
+
+	go func() {
+		defer func() {
+			r.heal.mu.Lock()
+			st.pending = false
+			st.lastRestart = time.Now()
+			r.heal.mu.Unlock()
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		// A reconcile or operator may have dealt with the container in the meantime
+		if r.reconciling() || r.Paused() {
+			return
+		}
+		ctid, err := docker.GetContainerIDByName(r.cli, name)
+		if err != nil {
+			return
+		}
+		inspect, err := r.cli.ContainerInspect(ctx, ctid)
+		if err != nil || (inspect.State != nil && inspect.State.Running) {
+			return
+		}
+
+		err = docker.EnsureRunningContainers(r.cli, ctid)
+		r.audit(ctx, audit.Entry{Action: audit.ActionStart, Container: name, Reason: "auto-heal: " + reason, NewImage: inspect.Config.Image}, err)
+		if err != nil {
+			log.Errorf("Error restarting container %s: %v", name, err)
+			return
+		}
+		r.metrics.AutoHealRestart(name)
+		log.Infof("Container %s restarted by auto-heal", name)
+	}()
+}
+
+// watchUnhealthy restarts or recreates a container that is still unhealthy after the grace period
+func (r *Reconciler) watchUnhealthy(ctx context.Context, name string, cfg *config.Config) {
+	settings := cfg.AppConfig.AutoHeal.Unhealthy
+	grace := settings.GracePeriod
+	if grace <= 0 {
+		grace = defaultUnhealthyGracePeriod
+	}
+
+	r.heal.mu.Lock()
+	if _, ok := r.heal.unhealthy[name]; ok {
+		r.heal.mu.Unlock()
+		return
+	}
+	graceCtx, cancel := context.WithCancel(ctx)
+	r.heal.unhealthy[name] = cancel
+	r.heal.mu.Unlock()
+
+	log.Warnf("Container %s is unhealthy, taking action in %s unless it recovers", name, grace)
+
+	go func() {
+		defer func() {
+			r.heal.mu.Lock()
+			delete(r.heal.unhealthy, name)
+			r.heal.mu.Unlock()
+			cancel()
+		}()
+
+		select {
+		case <-graceCtx.Done():
+			return
+		case <-time.After(grace):
+		}
+
+		ctid, err := docker.GetContainerIDByName(r.cli, name)
+		if err != nil {
+			return
+		}
+		inspect, err := r.cli.ContainerInspect(ctx, ctid)
+		if err != nil || inspect.State == nil || inspect.State.Health == nil || inspect.State.Health.Status != "unhealthy" {
+			return
+		}
+
+		// Don't race a running reconcile
+		if err := r.acquire(ctx, false); err != nil {
+			return
+		}
+		defer r.release()
+
+		reason := fmt.Sprintf("auto-heal: unhealthy for more than %s", grace)
+		action := unhealthyAction(settings.Action, r.inMaintenanceWindow())
+		if action != settings.Action {
+			log.Infof("Container %s is outside the maintenance windows, restarting instead of recreating it", name)
+			reason += ", outside the maintenance windows"
+		}
+		if action == config.UnhealthyActionRecreate {
+			err = r.recreateUnhealthy(ctx, name, ctid, cfg)
+			r.audit(ctx, audit.Entry{Action: audit.ActionRecreate, Container: name, Reason: reason, OldImage: inspect.Config.Image, NewImage: inspect.Config.Image}, err)
+		} else {
+			err = docker.RestartContainer(r.cli, ctid)
+			r.audit(ctx, audit.Entry{Action: audit.ActionRestart, Container: name, Reason: reason, NewImage: inspect.Config.Image}, err)
+		}
+		if err != nil {
+			log.Errorf("Error healing unhealthy container %s: %v", name, err)
+			return
+		}
+		r.metrics.AutoHealRestart(name)
+		log.Infof("Unhealthy container %s healed", name)
+	}()
+}
+
+// unhealthyAction is the action taken on an unhealthy container. Recreating it is disruptive,
+// outside the maintenance windows it is restarted instead.
+func unhealthyAction(action string, inWindow bool) string {
+	if action == config.UnhealthyActionRecreate && !inWindow {
+		return config.UnhealthyActionRestart
+	}
+	return action
+}
+
+// recreateUnhealthy replaces an unhealthy container with a fresh one built from its config,
+// resolving its image like a reconcile does
+func (r *Reconciler) recreateUnhealthy(ctx context.Context, name string, containerID string, cfg *config.Config) error {
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return err
+	}
+	pins, err := r.Pinned()
+	if err != nil {
+		return fmt.Errorf("error reading pins: %v", err)
+	}
+	for _, spec := range containers {
+		if spec.Name != name {
+			continue
+		}
+		// Healing must not move a pin_digest container to another digest either
+		digests := make(map[string]string)
+		if spec.PinDigest {
+			inspect, err := r.cli.ContainerInspect(ctx, containerID)
+			if err != nil {
+				return err
+			}
+			// containers created by digest record the image they were created from
+			if digest := inspect.Config.Labels[docker.LabelDigest]; digest != "" {
+				digests[inspect.Config.Labels[docker.LabelImage]] = digest
+			}
+		}
+		if err := r.resolveSpec(ctx, &spec, pins, digests); err != nil {
+			return err
+		}
+		if err := r.recreate(ctx, containerID, spec); err != nil {
+			return err
+		}
+		ctid, err := docker.GetContainerIDByName(r.cli, name)
+		if err != nil {
+			return err
+		}
+		return docker.EnsureRunningContainers(r.cli, ctid)
+	}
+	return fmt.Errorf("container %s is not configured", name)
+}
@@ -0,0 +1,31 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+func TestResolveSpecKeepsPinnedDigest(t *testing.T) {
+	r := &Reconciler{}
+
+	// an unhealthy container pinned through the API is recreated on its pin, not its tag
+	spec := docker.ContainerConfig{Name: "web", Image: "nginx:latest"}
+	pins := map[string]string{"web": "nginx@sha256:a"}
+	if err := r.resolveSpec(context.Background(), &spec, pins, map[string]string{}); err != nil {
+		t.Fatalf("Error resolving spec: %v", err)
+	}
+	if !spec.Pinned || spec.Digest != "nginx@sha256:a" {
+		t.Errorf("Expected web to keep its pinned digest, got %+v", spec)
+	}
+
+	// pin_digest containers keep the digest they were created from
+	spec = docker.ContainerConfig{Name: "api", Image: "api:1", PinDigest: true}
+	if err := r.resolveSpec(context.Background(), &spec, pins, map[string]string{"api:1": "api@sha256:b"}); err != nil {
+		t.Fatalf("Error resolving spec: %v", err)
+	}
+	if spec.Pinned || spec.Digest != "api@sha256:b" {
+		t.Errorf("Expected api to keep its digest, got %+v", spec)
+	}
+}
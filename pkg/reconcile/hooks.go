@@ -0,0 +1,29 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// runHook executes a lifecycle hook inside a container. A hook that can't be executed or
+// exits with a non-zero code is returned as an error.
+func (r *Reconciler) runHook(spec docker.ContainerConfig, hook string, cmd []string, containerID string) error {
+	if len(cmd) == 0 {
+		return nil
+	}
+
+	log.Infof("Running %s hook for container %s: %s", hook, spec.Name, strings.Join(cmd, " "))
+	result, err := docker.Exec(r.cli, containerID, cmd, spec.Hooks.Timeout)
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v", hook, err)
+	}
+
+	log.Debugf("%s hook for container %s output: %s%s", hook, spec.Name, result.Stdout, result.Stderr)
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s hook exited with code %d: %s", hook, result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultKeepPreviousImages is how many previous images per container are kept for rollbacks
+	DefaultKeepPreviousImages = 2
+	// DefaultPruneInterval is how often dangling images are pruned
+	DefaultPruneInterval = 24 * time.Hour
+)
+
+// collectImages removes images superseded by the updates of this run, keeping the retained
+// previous images of every container for rollbacks, and prunes dangling images once per
+// prune interval. Images used by any container are never removed.
+func (r *Reconciler) collectImages(ctx context.Context, report *Report) {
+	if r.state == nil {
+		log.Warn("Image garbage collection requires a state store, skipping")
+		return
+	}
+
+	gc := r.appConfig.ImageGC
+	keep := r.keepPrevious()
+
+	records, err := r.state.Containers()
+	if err != nil {
+		log.Errorf("Error reading container state: %v", err)
+		return
+	}
+	protected, err := r.imagesInUse(ctx)
+	if err != nil {
+		log.Errorf("Error listing containers for image garbage collection: %v", err)
+		return
+	}
+	for _, record := range records {
+		protected[record.ImageID] = true
+		for _, id := range keptImages(record, keep) {
+			protected[id] = true
+		}
+	}
+
+	// Images that fell out of the retention of containers updated in this run
+	var superseded []string
+	updated := make(map[string]bool)
+	for _, result := range report.Results {
+		if result.Action == ActionUpdated {
+			updated[result.Container] = true
+		}
+	}
+	for _, record := range records {
+		if updated[record.Name] && len(record.PreviousImages) > keep {
+			superseded = append(superseded, record.PreviousImages[keep:]...)
+		}
+	}
+	r.removeImages(ctx, superseded, protected, "superseded")
+
+	interval := gc.PruneInterval
+	if interval <= 0 {
+		interval = DefaultPruneInterval
+	}
+	if gc.PruneDangling && time.Since(r.lastPrune) >= interval {
+		r.lastPrune = time.Now()
+		dangling, err := r.cli.ImageList(ctx, image.ListOptions{Filters: filters.NewArgs(filters.Arg("dangling", "true"))})
+		if err != nil {
+			log.Errorf("Error listing dangling images: %v", err)
+			return
+		}
+		ids := make([]string, 0, len(dangling))
+		for _, img := range dangling {
+			ids = append(ids, img.ID)
+		}
+		r.removeImages(ctx, ids, protected, "dangling")
+	}
+}
+
+// keepPrevious returns how many previous images per container are kept, at most as many
+// as the state store tracks
+func (r *Reconciler) keepPrevious() int {
+	keep := r.appConfig.KeepPreviousImages
+	if keep <= 0 {
+		keep = DefaultKeepPreviousImages
+	}
+	return min(keep, state.MaxPreviousImages)
+}
+
+// keptImages returns the previous images of a container within the retention
+func keptImages(record state.ContainerRecord, keep int) []string {
+	return record.PreviousImages[:min(keep, len(record.PreviousImages))]
+}
+
+// restorePreviousImages pulls retained previous images that were removed locally, for
+// example by docker image prune, by their recorded digest so rollbacks always find them
+func (r *Reconciler) restorePreviousImages(ctx context.Context) {
+	records, err := r.state.Containers()
+	if err != nil {
+		log.Errorf("Error reading container state: %v", err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	for _, record := range records {
+		for _, id := range keptImages(record, r.keepPrevious()) {
+			digest := record.PreviousDigests[id]
+			if digest == "" || checked[id] {
+				continue
+			}
+			checked[id] = true
+
+			_, _, err := r.cli.ImageInspectWithRaw(ctx, id)
+			if !docker.IsNotFound(err) {
+				continue
+			}
+
+			log.Infof("Previous image %s of container %s is missing, pulling %s", id, record.Name, digest)
+			err = docker.PullImage(r.cli, digest)
+			r.audit(ctx, audit.Entry{Action: audit.ActionPull, Container: record.Name, Reason: "restore previous image", NewImage: digest}, err)
+			if err != nil {
+				log.Errorf("Error restoring previous image %s of container %s: %v", digest, record.Name, err)
+			}
+		}
+	}
+}
+
+// imagesInUse returns the IDs of images used by any container, managed or not
+func (r *Reconciler) imagesInUse(ctx context.Context) (map[string]bool, error) {
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, c := range containers {
+		inUse[c.ImageID] = true
+	}
+	return inUse, nil
+}
+
+// removeImages removes the given images except protected ones
+func (r *Reconciler) removeImages(ctx context.Context, ids []string, protected map[string]bool, reason string) {
+	removed := make(map[string]bool)
+	for _, id := range ids {
+		if protected[id] || removed[id] {
+			continue
+		}
+		removed[id] = true
+
+		err := docker.RemoveImage(r.cli, id)
+		if docker.IsNotFound(err) {
+			continue
+		}
+		r.audit(ctx, audit.Entry{Action: audit.ActionRemoveImage, Resource: id, Reason: reason}, err)
+		if err != nil {
+			log.Warnf("Error removing %s image %s: %v", reason, id, err)
+			continue
+		}
+		log.Infof("Removed %s image %s", reason, id)
+	}
+}
@@ -0,0 +1,126 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+const stoppedFile = "stopped.json"
+
+// Start starts the configured container name and lets reconciles and auto heal keep it
+// running again after a Stop
+func (r *Reconciler) Start(ctx context.Context, cfg *config.Config, name string) error {
+	return r.lifecycle(ctx, cfg, name, audit.ActionStart, func(id string) error {
+		if err := r.setStopped(name, false); err != nil {
+			return fmt.Errorf("error saving stopped containers: %v", err)
+		}
+		return docker.EnsureRunningContainers(r.cli, id)
+	})
+}
+
+// Stop stops the configured container name. Reconciles and auto heal don't start it again
+// until Start or Restart is called, the stopped set is persisted in the state directory. A
+// failed stop leaves the stopped set as it was.
+func (r *Reconciler) Stop(ctx context.Context, cfg *config.Config, name string) error {
+	return r.lifecycle(ctx, cfg, name, audit.ActionStop, func(id string) error {
+		wasStopped := r.isStopped(name)
+		if err := r.setStopped(name, true); err != nil {
+			return fmt.Errorf("error saving stopped containers: %v", err)
+		}
+		if err := docker.StopContainer(r.cli, id); err != nil {
+			if !wasStopped {
+				if err := r.setStopped(name, false); err != nil {
+					log.Errorf("Error saving stopped containers: %v", err)
+				}
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// Restart restarts the configured container name, starting it if it was stopped
+func (r *Reconciler) Restart(ctx context.Context, cfg *config.Config, name string) error {
+	return r.lifecycle(ctx, cfg, name, audit.ActionRestart, func(id string) error {
+		if err := r.setStopped(name, false); err != nil {
+			return fmt.Errorf("error saving stopped containers: %v", err)
+		}
+		return docker.RestartContainer(r.cli, id)
+	})
+}
+
+// lifecycle runs action on a configured container while holding the run lock, so it doesn't
+// race a reconcile, and records it in the audit log
+func (r *Reconciler) lifecycle(ctx context.Context, cfg *config.Config, name string, action string, run func(id string) error) error {
+	if err := r.acquire(ctx, cfg.AppConfig.ConcurrentReconcile == config.ConcurrentReconcileQueue); err != nil {
+		return err
+	}
+	defer r.release()
+
+	inspect, err := r.configuredContainer(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+
+	err = run(inspect.ID)
+	r.audit(ctx, audit.Entry{Action: action, Container: name, Reason: "api", OldImage: inspect.Config.Image}, err)
+	if err == nil {
+		log.Infof("Container %s: %s", name, action)
+	}
+	return err
+}
+
+// Stopped returns the names of containers stopped through Stop
+func (r *Reconciler) Stopped() []string {
+	r.stoppedMu.RLock()
+	defer r.stoppedMu.RUnlock()
+
+	names := make([]string, 0, len(r.stopped))
+	for name := range r.stopped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Reconciler) isStopped(name string) bool {
+	r.stoppedMu.RLock()
+	defer r.stoppedMu.RUnlock()
+	return r.stopped[name]
+}
+
+func (r *Reconciler) setStopped(name string, stopped bool) error {
+	r.stoppedMu.Lock()
+	defer r.stoppedMu.Unlock()
+
+	if r.stopped[name] == stopped {
+		return nil
+	}
+	setName(r.stopped, name, stopped)
+	if err := r.saveNames(stoppedFile, r.stopped); err != nil {
+		setName(r.stopped, name, !stopped)
+		return err
+	}
+	return nil
+}
+
+// setName adds name to or removes it from names
+func setName(names map[string]bool, name string, set bool) {
+	if set {
+		names[name] = true
+	} else {
+		delete(names, name)
+	}
+}
+
+// loadStopped restores the persisted stopped set
+func (r *Reconciler) loadStopped() (err error) {
+	r.stopped, err = r.loadNames(stoppedFile)
+	return err
+}
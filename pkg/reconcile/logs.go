@@ -0,0 +1,41 @@
+package reconcile
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/huxcrux/docker-manager/pkg/config"
+)
+
+// LogOptions selects the logs of a container
+type LogOptions struct {
+	// Tail is the number of lines from the end to return, or all
+	Tail string
+	// Since is an RFC3339 timestamp or a duration such as 10m
+	Since      string
+	Follow     bool
+	Timestamps bool
+}
+
+// Logs returns the stdout and stderr logs of the configured container name. Unless the
+// container runs with a TTY both are multiplexed on the stream, to be split with stdcopy.
+func (r *Reconciler) Logs(ctx context.Context, cfg *config.Config, name string, opts LogOptions) (io.ReadCloser, bool, error) {
+	inspect, err := r.configuredContainer(ctx, cfg, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	logs, err := r.cli.ContainerLogs(ctx, inspect.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Follow:     opts.Follow,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return logs, inspect.Config != nil && inspect.Config.Tty, nil
+}
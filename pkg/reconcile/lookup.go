@@ -0,0 +1,39 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+// ErrNoContainer is returned for actions on configured containers that don't exist on the host
+var ErrNoContainer = errors.New("container does not exist")
+
+// configuredContainer inspects the container name, which must be a container of cfg (a
+// replica name for entries with replicas), so the API can't reach unmanaged containers
+func (r *Reconciler) configuredContainer(ctx context.Context, cfg *config.Config, name string) (types.ContainerJSON, error) {
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return types.ContainerJSON{}, fmt.Errorf("error converting config to Docker config: %v", err)
+	}
+	configured := false
+	for _, container := range containers {
+		if container.Name == name {
+			configured = true
+			break
+		}
+	}
+	if !configured {
+		return types.ContainerJSON{}, ErrNotConfigured
+	}
+
+	inspect, err := r.cli.ContainerInspect(ctx, name)
+	if docker.IsNotFound(err) {
+		return types.ContainerJSON{}, ErrNoContainer
+	}
+	return inspect, err
+}
@@ -0,0 +1,94 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/schedule"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeferredAction is a disruptive action postponed until the next maintenance window
+type DeferredAction struct {
+	Container string `json:"container"`
+	// Action is recreate, update or remove
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// Since is when the action was first deferred
+	Since time.Time `json:"since"`
+}
+
+// compileWindows parses the maintenance window cron expressions
+func compileWindows(exprs []string) ([]*schedule.Schedule, error) {
+	var windows []*schedule.Schedule
+	for _, expr := range exprs {
+		window, err := schedule.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window: %v", err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// inMaintenanceWindow reports whether disruptive actions may run now. Without maintenance
+// windows they always may.
+func (r *Reconciler) inMaintenanceWindow() bool {
+	return inWindow(r.windows, time.Now())
+}
+
+// inWindow reports whether t falls in any of windows, or there are no windows
+func inWindow(windows []*schedule.Schedule, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if window.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferAction queues a disruptive action of the running reconcile until the next maintenance
+// window. Actions deferred by the previous reconcile keep the time they were first deferred.
+func (r *Reconciler) deferAction(container string, action string, reason string) {
+	log.Infof("Container %s needs to %s (%s), deferring until the next maintenance window", container, action, reason)
+
+	deferred := DeferredAction{Container: container, Action: action, Reason: reason, Since: time.Now()}
+	r.deferredMu.RLock()
+	if previous, ok := r.deferred[container]; ok && previous.Action == action {
+		deferred.Since = previous.Since
+	}
+	r.deferredMu.RUnlock()
+	r.nextDeferred[container] = deferred
+}
+
+// deferredUpdate returns the update of container deferred by the last reconcile
+func (r *Reconciler) deferredUpdate(container string) (DeferredAction, bool) {
+	r.deferredMu.RLock()
+	defer r.deferredMu.RUnlock()
+	deferred, ok := r.deferred[container]
+	return deferred, ok && deferred.Action == "update"
+}
+
+// publishDeferred replaces the deferred actions with the ones of the reconcile that just ran
+func (r *Reconciler) publishDeferred() {
+	r.deferredMu.Lock()
+	defer r.deferredMu.Unlock()
+	r.deferred = r.nextDeferred
+}
+
+// Deferred returns the disruptive actions the last reconcile deferred until a maintenance window
+func (r *Reconciler) Deferred() []DeferredAction {
+	r.deferredMu.RLock()
+	defer r.deferredMu.RUnlock()
+
+	actions := make([]DeferredAction, 0, len(r.deferred))
+	for _, action := range r.deferred {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Container < actions[j].Container })
+	return actions
+}
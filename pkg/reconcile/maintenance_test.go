@@ -0,0 +1,83 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+)
+
+func TestInWindow(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		windows []string
+		at      string
+		want    bool
+	}{
+		{"no windows", nil, "2024-06-05 12:00", true},
+		{"inside", []string{"* 3-4 * * *"}, "2024-06-05 03:30", true},
+		{"outside", []string{"* 3-4 * * *"}, "2024-06-05 05:00", false},
+		{"last minute", []string{"* 3-4 * * *"}, "2024-06-05 04:59", true},
+		{"any window", []string{"* 3 * * *", "* 14 * * *"}, "2024-06-05 14:10", true},
+		{"wrapping midnight, before", []string{"* 22-23,0-1 * * *"}, "2024-06-05 23:45", true},
+		{"wrapping midnight, after", []string{"* 22-23,0-1 * * *"}, "2024-06-06 00:15", true},
+		{"wrapping midnight, outside", []string{"* 22-23,0-1 * * *"}, "2024-06-06 02:00", false},
+		// Saturday night into Sunday morning, 2024-06-08 is a Saturday
+		{"wrapping into the next day", []string{"* 23 * * sat", "* 0-1 * * sun"}, "2024-06-09 00:30", true},
+		{"wrapping into the next day, outside", []string{"* 23 * * sat", "* 0-1 * * sun"}, "2024-06-10 00:30", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			windows, err := compileWindows(tc.windows)
+			if err != nil {
+				t.Fatalf("Error compiling windows: %v", err)
+			}
+			at, err := time.Parse("2006-01-02 15:04", tc.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inWindow(windows, at); got != tc.want {
+				t.Errorf("Expected %v at %s, got %v", tc.want, tc.at, got)
+			}
+		})
+	}
+}
+
+func TestUnhealthyAction(t *testing.T) {
+	for _, tc := range []struct {
+		action   string
+		inWindow bool
+		want     string
+	}{
+		{config.UnhealthyActionRecreate, true, config.UnhealthyActionRecreate},
+		{config.UnhealthyActionRecreate, false, config.UnhealthyActionRestart},
+		{config.UnhealthyActionRestart, false, config.UnhealthyActionRestart},
+		{"", false, ""},
+	} {
+		if got := unhealthyAction(tc.action, tc.inWindow); got != tc.want {
+			t.Errorf("Expected %q for %q (in window %v), got %q", tc.want, tc.action, tc.inWindow, got)
+		}
+	}
+}
+
+func TestDeferActionKeepsSince(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	r := &Reconciler{
+		deferred:     map[string]DeferredAction{"web": {Container: "web", Action: "update", Since: since}, "db": {Container: "db", Action: "update", Since: since}},
+		nextDeferred: make(map[string]DeferredAction),
+	}
+
+	r.deferAction("web", "update", "newer image")
+	r.deferAction("db", "recreate", "drift: memory")
+	r.publishDeferred()
+
+	deferred := r.Deferred()
+	if len(deferred) != 2 || deferred[0].Container != "db" || deferred[1].Container != "web" {
+		t.Fatalf("Unexpected deferred actions %+v", deferred)
+	}
+	if !deferred[1].Since.Equal(since) {
+		t.Errorf("Expected the update of web to keep its first deferral, got %s", deferred[1].Since)
+	}
+	if deferred[0].Since.Equal(since) {
+		t.Errorf("Expected the recreate of db to be deferred anew")
+	}
+}
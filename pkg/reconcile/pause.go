@@ -0,0 +1,53 @@
+package reconcile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrPaused is returned when a reconcile is requested while reconciliation is paused
+var ErrPaused = errors.New("reconciliation is paused")
+
+const pauseFile = "paused"
+
+// Paused reports whether reconciliation is currently paused
+func (r *Reconciler) Paused() bool {
+	return r.paused.Load()
+}
+
+// Pause stops reconciles from running until Resume is called. The flag is persisted
+// in the state directory so a restarted manager stays paused.
+func (r *Reconciler) Pause() error {
+	if err := os.MkdirAll(r.stateDir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(r.stateDir, pauseFile), nil, 0o644); err != nil {
+		return err
+	}
+	r.paused.Store(true)
+	return nil
+}
+
+// Resume allows reconciles to run again
+func (r *Reconciler) Resume() error {
+	err := os.Remove(filepath.Join(r.stateDir, pauseFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r.paused.Store(false)
+	return nil
+}
+
+// loadPaused restores the persisted pause flag
+func (r *Reconciler) loadPaused() error {
+	_, err := os.Stat(filepath.Join(r.stateDir, pauseFile))
+	if err == nil {
+		r.paused.Store(true)
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
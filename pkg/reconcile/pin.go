@@ -0,0 +1,77 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pin holds a configured container on the digest it currently runs until Unpin is called,
+// regardless of where its tag moves. Pinned containers are not checked for updates and
+// recreations use the digest. The pin is persisted in the state store. It returns the digest.
+func (r *Reconciler) Pin(ctx context.Context, cfg *config.Config, name string) (string, error) {
+	if r.state == nil {
+		return "", fmt.Errorf("pins require a state store")
+	}
+
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return "", fmt.Errorf("error converting config to Docker config: %v", err)
+	}
+	var spec *docker.ContainerConfig
+	for i := range containers {
+		if containers[i].Name == name {
+			spec = &containers[i]
+		}
+	}
+	if spec == nil {
+		return "", ErrNotConfigured
+	}
+
+	ctid, err := docker.GetContainerIDByName(r.cli, name)
+	if err != nil {
+		return "", err
+	}
+	inspect, err := r.cli.ContainerInspect(ctx, ctid)
+	if err != nil {
+		return "", err
+	}
+
+	// Containers created from a digest keep it, others are pinned to the digest of their image
+	digest := inspect.Config.Labels[docker.LabelDigest]
+	if digest == "" {
+		digest, err = docker.ImageDigest(r.cli, inspect.Image, spec.Image)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := r.state.Pin(name, digest); err != nil {
+		return "", err
+	}
+	log.Infof("Container %s pinned to %s", name, digest)
+	return digest, nil
+}
+
+// Unpin removes a pin set through Pin, the container tracks its configured image again
+func (r *Reconciler) Unpin(name string) error {
+	if r.state == nil {
+		return fmt.Errorf("pins require a state store")
+	}
+	if err := r.state.Unpin(name); err != nil {
+		return err
+	}
+	log.Infof("Container %s unpinned", name)
+	return nil
+}
+
+// Pinned returns the digests containers are pinned to through Pin, keyed by container name
+func (r *Reconciler) Pinned() (map[string]string, error) {
+	if r.state == nil {
+		return nil, nil
+	}
+	return r.state.Pins()
+}
@@ -0,0 +1,87 @@
+package reconcile
+
+import (
+	"context"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+// Change is what a reconcile would do to a container
+type Change string
+
+const (
+	ChangeCreate   Change = "create"
+	ChangeRecreate Change = "recreate"
+	// ChangeReconfigure applies resource limits in place, without recreating the container
+	ChangeReconfigure Change = "reconfigure"
+	ChangeStart       Change = "start"
+	ChangeRemove      Change = "remove"
+	// ChangeFrozen is drift of a frozen container, which is kept until it is unfrozen
+	ChangeFrozen Change = "frozen"
+)
+
+// PlannedChange is a change the next reconcile would make to a container
+type PlannedChange struct {
+	Container string  `json:"container"`
+	Change    Change  `json:"change"`
+	Drift     []Drift `json:"drift"`
+}
+
+// Plan lists the changes a reconcile of cfg would make, in config order followed by the
+// removals. Nothing is changed. Recreates outside a maintenance window are deferred by the
+// reconcile, available updates are left to the update checks.
+func (r *Reconciler) Plan(ctx context.Context, cfg *config.Config) ([]PlannedChange, error) {
+	drifted, err := r.Drift(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	specs := make(map[string]docker.ContainerConfig, len(containers))
+	for _, container := range containers {
+		specs[container.Name] = container
+	}
+	return planChanges(drifted, specs), nil
+}
+
+// planChanges turns drift into the changes a reconcile would make. Containers running although
+// stopped through the API are left alone, the reconcile only starts containers.
+func planChanges(drifted []ContainerDrift, specs map[string]docker.ContainerConfig) []PlannedChange {
+	changes := []PlannedChange{}
+	for _, container := range drifted {
+		var change Change
+		var settings []Drift
+		for _, d := range container.Drift {
+			switch d.Field {
+			case "exists":
+				if d.Desired == true {
+					change = ChangeCreate
+				} else {
+					change = ChangeRemove
+				}
+			case "state":
+				if d.Desired == "running" && change == "" {
+					change = ChangeStart
+				}
+			default:
+				settings = append(settings, d)
+			}
+		}
+		switch {
+		case change == ChangeCreate || change == ChangeRemove:
+		case container.Frozen && len(settings) > 0:
+			change = ChangeFrozen
+		case len(settings) > 0 && updatableInPlace(specs[container.Name], settings):
+			change = ChangeReconfigure
+		case len(settings) > 0:
+			change = ChangeRecreate
+		}
+		if change != "" {
+			changes = append(changes, PlannedChange{Container: container.Name, Change: change, Drift: container.Drift})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,42 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+func TestPlanChanges(t *testing.T) {
+	memory := Drift{Field: "memory", Desired: int64(2), Actual: int64(1)}
+	drifted := []ContainerDrift{
+		{Name: "missing", Drift: []Drift{{Field: "exists", Desired: true, Actual: false}}},
+		{Name: "crashed", Drift: []Drift{{Field: "state", Desired: "running", Actual: "exited"}}},
+		{Name: "started", Drift: []Drift{{Field: "state", Desired: "stopped", Actual: "running"}}},
+		{Name: "image", Drift: []Drift{{Field: "state", Desired: "running", Actual: "exited"}, {Field: "image", Desired: "b", Actual: "a"}}},
+		{Name: "resized", Drift: []Drift{memory}},
+		{Name: "frozen", Frozen: true, Drift: []Drift{memory}},
+		{Name: "unwanted", Drift: []Drift{{Field: "exists", Desired: false, Actual: true}}},
+	}
+	specs := map[string]docker.ContainerConfig{
+		"resized": {DriftStrategy: config.DriftStrategyInPlace, Resources: docker.Resources{Memory: 2}},
+	}
+
+	changes := planChanges(drifted, specs)
+	expected := map[string]Change{
+		"missing":  ChangeCreate,
+		"crashed":  ChangeStart,
+		"image":    ChangeRecreate,
+		"resized":  ChangeReconfigure,
+		"frozen":   ChangeFrozen,
+		"unwanted": ChangeRemove,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), changes)
+	}
+	for _, change := range changes {
+		if change.Change != expected[change.Container] {
+			t.Errorf("Expected %s for %s, got %s", expected[change.Container], change.Container, change.Change)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+// tracksTags reports whether a container moves between tags instead of following its configured tag
+func tracksTags(spec docker.ContainerConfig) bool {
+	switch spec.UpdatePolicy {
+	case config.UpdatePolicyPatch, config.UpdatePolicyMinor, config.UpdatePolicyMajor:
+		return true
+	}
+	return spec.TagFilter != nil
+}
+
+// splitTag splits an image reference into repository and tag, the tag is empty if there is none
+func splitTag(ref string) (string, string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || i < strings.LastIndex(ref, "/") || strings.Contains(ref, "@") {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// allowedTag reports whether tag may replace the configured tag base: it must match the tag
+// filter and, with an update policy, be a compatible version no lower than base and within
+// the allowed range
+func allowedTag(spec docker.ContainerConfig, base string, tag string) bool {
+	if spec.TagFilter != nil && !spec.TagFilter.MatchString(tag) {
+		return false
+	}
+	if spec.UpdatePolicy == "" {
+		return spec.TagFilter != nil
+	}
+
+	baseVersion, ok := registry.ParseVersion(base)
+	if !ok {
+		return false
+	}
+	version, ok := registry.ParseVersion(tag)
+	if !ok || !baseVersion.Compatible(version) || version.Compare(baseVersion) < 0 {
+		return false
+	}
+
+	switch spec.UpdatePolicy {
+	case config.UpdatePolicyPatch:
+		return version.SharesPrefix(baseVersion, 2)
+	case config.UpdatePolicyMinor:
+		return version.SharesPrefix(baseVersion, 1)
+	case config.UpdatePolicyMajor:
+		return true
+	}
+	return false
+}
+
+// newerTag reports whether tag sorts after current, as versions when both are compatible
+// versions and lexically otherwise
+func newerTag(tag string, current string) bool {
+	version, ok := registry.ParseVersion(tag)
+	currentVersion, currentOk := registry.ParseVersion(current)
+	if ok && currentOk && version.Compatible(currentVersion) {
+		return version.Compare(currentVersion) > 0
+	}
+	return tag > current
+}
+
+// trackedImage returns the image a tag tracking container should run: the tag it already runs
+// if that is still allowed by its policy, otherwise the configured image
+func (r *Reconciler) trackedImage(ctx context.Context, spec docker.ContainerConfig) string {
+	ctid, err := docker.GetContainerIDByName(r.cli, spec.Name)
+	if err != nil {
+		return spec.Image
+	}
+	inspect, err := r.cli.ContainerInspect(ctx, ctid)
+	if err != nil {
+		return spec.Image
+	}
+
+	running := inspect.Config.Image
+	if configured, ok := inspect.Config.Labels[docker.LabelImage]; ok {
+		running = configured
+	}
+
+	repo, base := splitTag(spec.Image)
+	runningRepo, tag := splitTag(running)
+	if runningRepo != repo || !allowedTag(spec, base, tag) {
+		return spec.Image
+	}
+	return running
+}
+
+// newestImage returns the image with the highest tag the registry offers within the tag
+// filter and update policy of spec, or the current image if there is none
+func (r *Reconciler) newestImage(ctx context.Context, spec docker.ContainerConfig, configured string) string {
+	if r.registry == nil {
+		return spec.Image
+	}
+
+	tags, err := r.registry.Tags(ctx, spec.Image)
+	if err != nil {
+		log.Errorf("Error listing tags of %s: %v", spec.Image, err)
+		return spec.Image
+	}
+
+	repo, current := splitTag(spec.Image)
+	_, base := splitTag(configured)
+	newest := ""
+	if allowedTag(spec, base, current) {
+		newest = current
+	}
+	for _, tag := range tags {
+		if allowedTag(spec, base, tag) && (newest == "" || newerTag(tag, newest)) {
+			newest = tag
+		}
+	}
+	if newest == "" {
+		return spec.Image
+	}
+	return repo + ":" + newest
+}
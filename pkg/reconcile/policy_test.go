@@ -0,0 +1,59 @@
+package reconcile
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+func TestAllowedTag(t *testing.T) {
+	tests := []struct {
+		policy string
+		tag    string
+		want   bool
+	}{
+		{config.UpdatePolicyPatch, "1.27.4", true},
+		{config.UpdatePolicyPatch, "1.28.0", false},
+		{config.UpdatePolicyMinor, "1.28.0", true},
+		{config.UpdatePolicyMinor, "2.0.0", false},
+		{config.UpdatePolicyMajor, "2.0.0", true},
+		{config.UpdatePolicyMajor, "1.27.2", false},
+		{config.UpdatePolicyMajor, "2.0.0-alpine", false},
+		{config.UpdatePolicyMajor, "latest", false},
+		{config.UpdatePolicyPinned, "1.27.4", false},
+	}
+
+	for _, test := range tests {
+		spec := docker.ContainerConfig{UpdatePolicy: test.policy}
+		if got := allowedTag(spec, "1.27.3", test.tag); got != test.want {
+			t.Errorf("allowedTag(%s, 1.27.3, %s) = %v, want %v", test.policy, test.tag, got, test.want)
+		}
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	spec := docker.ContainerConfig{TagFilter: regexp.MustCompile(`^1\.27\..*-alpine$`)}
+	tags := []string{"1.27.2-alpine", "1.27.10-alpine", "1.27.11", "1.28.0-alpine"}
+
+	newest := ""
+	for _, tag := range tags {
+		if allowedTag(spec, "", tag) && (newest == "" || newerTag(tag, newest)) {
+			newest = tag
+		}
+	}
+	if newest != "1.27.10-alpine" {
+		t.Errorf("Expected newest tag 1.27.10-alpine, got %s", newest)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	repo, tag := splitTag("registry.local:5000/team/app:1.2")
+	if repo != "registry.local:5000/team/app" || tag != "1.2" {
+		t.Errorf("Unexpected split %s %s", repo, tag)
+	}
+	if repo, tag := splitTag("registry.local:5000/team/app"); repo != "registry.local:5000/team/app" || tag != "" {
+		t.Errorf("Unexpected split %s %s", repo, tag)
+	}
+}
@@ -0,0 +1,95 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrefetchResult is the outcome of prefetching one image
+type PrefetchResult struct {
+	Image string `json:"image"`
+	// Containers are the containers using the image
+	Containers []string `json:"containers"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Prefetch pulls the images of the configured containers, or only of the named ones, so
+// recreations and updates in a maintenance window don't wait for pulls. Containers tracking
+// tags prefetch the newest tag their policy allows, containers pinned through the API their
+// digest. Containers with the never pull policy are skipped. Pulls run concurrently within
+// the pull limits.
+func (r *Reconciler) Prefetch(ctx context.Context, cfg *config.Config, names []string) ([]PrefetchResult, error) {
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error converting config to Docker config: %v", err)
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(containers, func(c docker.ContainerConfig) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("%w: %s", ErrNotConfigured, name)
+		}
+	}
+	pins, err := r.Pinned()
+	if err != nil {
+		return nil, fmt.Errorf("error reading pins: %v", err)
+	}
+
+	// Collect each image once per platform
+	type target struct{ image, platform string }
+	users := make(map[target][]string)
+	for _, container := range containers {
+		if (len(names) > 0 && !slices.Contains(names, container.Name)) || !pulls(container) {
+			continue
+		}
+
+		image := container.Image
+		switch digest, pinned := pins[container.Name]; {
+		case pinned:
+			image = digest
+		case tracksTags(container):
+			container.BaseImage = container.Image
+			container.Image = r.trackedImage(ctx, container)
+			image = r.newestImage(ctx, container, container.BaseImage)
+		}
+		t := target{image: image, platform: container.Platform}
+		users[t] = append(users[t], container.Name)
+	}
+
+	var (
+		results   []PrefetchResult
+		resultsMu sync.Mutex
+		wg        sync.WaitGroup
+	)
+	for t, containers := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			log.Infof("Prefetching image %s", t.image)
+			err := docker.PullPlatformImage(r.cli, t.image, t.platform)
+			for _, name := range containers {
+				r.audit(ctx, audit.Entry{Action: audit.ActionPull, Container: name, Reason: "prefetch", NewImage: t.image}, err)
+			}
+
+			result := PrefetchResult{Image: t.image, Containers: containers}
+			if err != nil {
+				log.Errorf("Error prefetching image %s: %v", t.image, err)
+				result.Error = err.Error()
+			}
+			resultsMu.Lock()
+			results = append(results, result)
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Image < results[j].Image })
+	return results, nil
+}
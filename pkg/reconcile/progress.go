@@ -0,0 +1,63 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+)
+
+// Event is a progress step of a running reconcile
+type Event struct {
+	// Step is "ensure" or "pulling" before work on a container starts, or the audit
+	// action (create, recreate, remove, ...) once a change was made
+	Step      string `json:"step"`
+	Container string `json:"container,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ProgressFunc receives progress events, it is called from the reconciling goroutine
+type ProgressFunc func(Event)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports the progress of reconciles run with it to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progress reports an event to the ProgressFunc of ctx, if any
+func progress(ctx context.Context, event Event) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(event)
+	}
+}
+
+// auditProgress reports a recorded audit entry as a progress event
+func auditProgress(ctx context.Context, entry audit.Entry) {
+	progress(ctx, Event{
+		Step:      entry.Action,
+		Container: entry.Container,
+		Message:   auditMessage(entry),
+		Error:     entry.Error,
+	})
+}
+
+// auditMessage describes what an audit entry changed, such as "from nginx:1.26 image nginx:1.27 (newer image)"
+func auditMessage(entry audit.Entry) string {
+	var message []string
+	if entry.Resource != "" {
+		message = append(message, entry.Resource)
+	}
+	if entry.OldImage != "" {
+		message = append(message, "from "+entry.OldImage)
+	}
+	if entry.NewImage != "" {
+		message = append(message, "image "+entry.NewImage)
+	}
+	if entry.Reason != "" {
+		message = append(message, "("+entry.Reason+")")
+	}
+	return strings.Join(message, " ")
+}
@@ -0,0 +1,36 @@
+package reconcile
+
+import (
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// compileProtected turns the protected_containers entries into anchored regexes. Entries
+// that are not valid regexes only match by exact name.
+func compileProtected(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			log.Warnf("Protected container pattern %q is not a valid regex, matching it by name only: %v", pattern, err)
+			re = regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// isProtected reports whether a container may never be removed or recreated
+func (r *Reconciler) isProtected(name string) bool {
+	return matchProtected(r.protected, name)
+}
+
+func matchProtected(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package reconcile
+
+import (
+	"context"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+// pulls reports whether images of the container may be pulled at all
+func pulls(spec docker.ContainerConfig) bool {
+	return spec.PullPolicy != config.PullPolicyNever
+}
+
+// pullForCreate pulls the image of a container that is about to be created according to its
+// pull policy: always pulls, if-not-present (default) pulls only missing images and never
+// leaves it to the create to fail if the image is missing
+func (r *Reconciler) pullForCreate(ctx context.Context, spec docker.ContainerConfig) error {
+	switch spec.PullPolicy {
+	case config.PullPolicyNever:
+		return nil
+	case config.PullPolicyAlways:
+	default:
+		ref := spec.Image
+		if spec.Digest != "" {
+			ref = spec.Digest
+		}
+		_, _, err := r.cli.ImageInspectWithRaw(ctx, ref)
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+
+	progress(ctx, Event{Step: "pulling", Container: spec.Name, Message: "image " + spec.Image})
+	err := docker.PullPlatformImage(r.cli, spec.Image, spec.Platform)
+	r.audit(ctx, audit.Entry{Action: audit.ActionPull, Container: spec.Name, Reason: "create", NewImage: spec.Image}, err)
+	return err
+}
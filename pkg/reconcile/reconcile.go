@@ -0,0 +1,686 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/events"
+	"github.com/huxcrux/docker-manager/pkg/metrics"
+	"github.com/huxcrux/docker-manager/pkg/registry"
+	"github.com/huxcrux/docker-manager/pkg/schedule"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrReconcileInProgress is returned when a reconcile is requested while another one is running
+var ErrReconcileInProgress = errors.New("reconcile already in progress")
+
+// Reconciler drives the containers on a Docker host towards the desired config
+type Reconciler struct {
+	cli      *client.Client
+	stateDir string
+	metrics  *metrics.ManagerMetrics
+	state    *state.Store
+	auditLog *audit.Log
+	registry *registry.Client
+	events   *events.Bus
+
+	// lock holds a token while a reconcile is running
+	lock   chan struct{}
+	paused atomic.Bool
+
+	// frozen holds containers frozen through the API
+	frozen   map[string]bool
+	frozenMu sync.RWMutex
+
+	// stopped holds containers stopped through the API, which are not started again
+	stopped   map[string]bool
+	stoppedMu sync.RWMutex
+
+	// blockedImages holds, per config entry, an image that failed a canary or was rolled
+	// back from, persisted in the state store. It is only accessed while holding the run lock.
+	blockedImages map[string]string
+
+	// vulnerable holds the scan results of images refused for their vulnerabilities, keyed by
+	// image ID and severity. It is only accessed while holding the run lock.
+	vulnerable map[string]error
+
+	// unverified holds the verification errors of images refused for their signature, keyed
+	// by image ID and policy. It is only accessed while holding the run lock.
+	unverified map[string]error
+
+	// appConfig is the app config of the running reconcile and protected the compiled
+	// protected_containers patterns, only valid while holding the run lock
+	appConfig config.AppConfig
+	protected []*regexp.Regexp
+
+	// windows are the maintenance windows of the running reconcile, only valid while holding
+	// the run lock
+	windows []*schedule.Schedule
+
+	// deferred holds the disruptive actions the last reconcile deferred, nextDeferred the ones
+	// of the running reconcile which is only accessed while holding the run lock
+	deferred     map[string]DeferredAction
+	nextDeferred map[string]DeferredAction
+	deferredMu   sync.RWMutex
+
+	// available holds the updates found for containers in notify update mode
+	available   map[string]AvailableUpdate
+	availableMu sync.RWMutex
+
+	// lastUpdateCheck is when each container last completed an update check, only accessed
+	// while holding the run lock
+	lastUpdateCheck map[string]time.Time
+
+	// platform is the platform of the Docker host, only accessed while holding the run lock
+	platform string
+
+	// lastPrune is when dangling images were last pruned, only accessed while holding the run lock
+	lastPrune time.Time
+
+	// lastRun is the outcome of the last reconcile of this process
+	lastRun   *RunSummary
+	lastRunMu sync.RWMutex
+
+	heal healer
+}
+
+// Options holds the dependencies of a Reconciler
+type Options struct {
+	// StateDir is where state surviving restarts is persisted
+	StateDir string
+	// Metrics records the manager's own metrics, it may be nil
+	Metrics *metrics.ManagerMetrics
+	// State records managed containers and the reconcile history, it may be nil
+	State *state.Store
+	// Audit records every mutating action, it may be nil
+	Audit *audit.Log
+	// Registry checks for new images without pulling them, without it update checks always pull
+	Registry *registry.Client
+	// Events receives the manager's events for subscribers such as /events, it may be nil
+	Events *events.Bus
+}
+
+// New creates a Reconciler using the given Docker client
+func New(cli *client.Client, opts Options) (*Reconciler, error) {
+	r := &Reconciler{
+		cli:      cli,
+		stateDir: opts.StateDir,
+		metrics:  opts.Metrics,
+		state:    opts.State,
+		auditLog: opts.Audit,
+		registry: opts.Registry,
+		events:   opts.Events,
+		lock:     make(chan struct{}, 1),
+
+		blockedImages: make(map[string]string),
+		vulnerable:    make(map[string]error),
+		unverified:    make(map[string]error),
+
+		lastUpdateCheck: make(map[string]time.Time),
+		available:       make(map[string]AvailableUpdate),
+		heal: healer{
+			containers: make(map[string]*healState),
+			unhealthy:  make(map[string]context.CancelFunc),
+		},
+	}
+
+	if err := r.loadPaused(); err != nil {
+		return nil, fmt.Errorf("error reading pause state: %v", err)
+	}
+	if err := r.loadFrozen(); err != nil {
+		return nil, fmt.Errorf("error reading frozen containers: %v", err)
+	}
+	if err := r.loadStopped(); err != nil {
+		return nil, fmt.Errorf("error reading stopped containers: %v", err)
+	}
+	if r.state != nil {
+		blocked, err := r.state.BlockedImages()
+		if err != nil {
+			return nil, fmt.Errorf("error reading blocked images: %v", err)
+		}
+		r.blockedImages = blocked
+	}
+
+	return r, nil
+}
+
+// acquire takes the run lock. With queue semantics it waits for a running reconcile
+// to finish (or ctx to be cancelled), otherwise it fails with ErrReconcileInProgress.
+func (r *Reconciler) acquire(ctx context.Context, queue bool) error {
+	if !queue {
+		select {
+		case r.lock <- struct{}{}:
+			return nil
+		default:
+			return ErrReconcileInProgress
+		}
+	}
+
+	select {
+	case r.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Reconciler) release() {
+	<-r.lock
+}
+
+// Shutdown waits for a running reconcile to finish and keeps the run lock, so no reconcile
+// starts afterwards. It fails if ctx ends first, the reconcile then keeps running.
+func (r *Reconciler) Shutdown(ctx context.Context) error {
+	return r.acquire(ctx, true)
+}
+
+// Reconcile removes unwanted containers (if enabled) and ensures every configured
+// container exists, matches its config and is running. A failing container does not
+// abort the run; its error is recorded in the report and the next container is handled.
+// An error is only returned when the run could not start at all, including when another
+// reconcile is already running (ErrReconcileInProgress) or reconciliation is paused (ErrPaused).
+func (r *Reconciler) Reconcile(ctx context.Context, cfg *config.Config) (*Report, error) {
+	if r.Paused() {
+		return nil, ErrPaused
+	}
+
+	if err := r.acquire(ctx, cfg.AppConfig.ConcurrentReconcile == config.ConcurrentReconcileQueue); err != nil {
+		return nil, err
+	}
+	defer r.release()
+
+	started := time.Now()
+	r.events.Publish(events.Event{Type: events.TypeReconcileStarted, Time: started})
+	report, err := r.reconcile(ctx, cfg, started)
+	r.recordRun(started, report, err)
+	return report, err
+}
+
+// reconcile is a single run of Reconcile, with the run lock held
+func (r *Reconciler) reconcile(ctx context.Context, cfg *config.Config, started time.Time) (*Report, error) {
+	r.appConfig = cfg.AppConfig
+	r.protected = compileProtected(cfg.AppConfig.ProtectedContainers)
+	windows, err := compileWindows(cfg.AppConfig.MaintenanceWindows)
+	if err != nil {
+		return nil, err
+	}
+	r.windows = windows
+	r.nextDeferred = make(map[string]DeferredAction)
+	defer r.publishDeferred()
+
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error converting config to Docker config: %v", err)
+	}
+
+	pins, err := r.Pinned()
+	if err != nil {
+		return nil, fmt.Errorf("error reading pins: %v", err)
+	}
+
+	report := &Report{}
+
+	// Remove replicas of configured entries that are no longer desired
+	if err := r.removeScaledDown(ctx, containers, report); err != nil {
+		return report, fmt.Errorf("error listing containers: %v", err)
+	}
+
+	// Delete unwanted containers
+	if cfg.AppConfig.RemoveUnwantedContainers {
+		if err := r.removeUnwantedContainers(ctx, containers, report); err != nil {
+			return report, fmt.Errorf("error listing containers: %v", err)
+		}
+	}
+
+	// Remove networks and volumes nothing has used for the retention period
+	if cfg.AppConfig.GarbageCollection.Enabled {
+		r.collectGarbage(ctx, containers)
+	}
+
+	// Create containers, fix drift and make sure they are running
+	var pending []pendingUpdate
+	digests := make(map[string]string)
+	for i, container := range containers {
+		// A cancelled reconcile stops between containers, never while one is recreated
+		if err := ctx.Err(); err != nil {
+			return report, fmt.Errorf("reconcile cancelled: %v", err)
+		}
+		progress(ctx, Event{Step: "ensure", Container: container.Name})
+
+		err := r.resolveSpec(ctx, &container, pins, digests)
+		containers[i] = container
+		if err != nil {
+			log.Errorf("Error resolving image of container %s: %v", container.Name, err)
+			report.add(container.Name, ActionFailed, err)
+			continue
+		}
+
+		action, ctid, err := r.ensureContainer(ctx, container)
+		if err != nil {
+			log.Errorf("Error ensuring container %s: %v", container.Name, err)
+			report.add(container.Name, action, err)
+			continue
+		}
+		report.add(container.Name, action, nil)
+
+		// Check if container is up to date
+		if container.UpdateCheck && container.UpdatePolicy != config.UpdatePolicyPinned &&
+			action != ActionCreated && action != ActionFrozen && action != ActionProtected && action != ActionDeferred && !container.Pinned {
+			// a skipped check keeps an update deferred by earlier reconciles
+			deferred, hasDeferred := r.deferredUpdate(container.Name)
+			if container.UpdateSchedule != nil && !container.UpdateSchedule.Matches(time.Now()) {
+				log.Debugf("Container %s is outside its update schedule %s, skipping update check", container.Name, container.UpdateSchedule)
+				if hasDeferred {
+					r.nextDeferred[container.Name] = deferred
+				}
+				continue
+			}
+			// a deferred update is checked again as soon as a maintenance window opens
+			if last, ok := r.lastUpdateCheck[container.Name]; ok && time.Since(last) < container.UpdateCheckInterval && !(hasDeferred && r.inMaintenanceWindow()) {
+				log.Debugf("Container %s was checked for updates %s ago, skipping update check", container.Name, time.Since(last).Round(time.Second))
+				if hasDeferred {
+					r.nextDeferred[container.Name] = deferred
+				}
+				continue
+			}
+			// the check is stamped once the registry answered, whether the update is applied or
+			// not, only failed checks are retried on the next reconcile
+			update, err := r.checkForUpdate(ctx, ctid, container)
+			if err != nil {
+				log.Errorf("Error checking container %s for updates: %v", container.Name, err)
+				report.set(container.Name, action, err)
+				continue
+			}
+			r.lastUpdateCheck[container.Name] = time.Now()
+			if update == nil {
+				r.clearAvailable(container.Name)
+				continue
+			}
+			if container.UpdateMode == config.UpdateModeNotify {
+				r.markAvailable(*update, "notify mode")
+				report.set(container.Name, ActionUpdateAvailable, nil)
+				continue
+			}
+			if !r.inMaintenanceWindow() {
+				r.deferAction(container.Name, "update", "newer image")
+				r.markAvailable(*update, "outside maintenance window")
+				report.set(container.Name, ActionDeferred, nil)
+				continue
+			}
+			pending = append(pending, *update)
+		}
+	}
+
+	// Apply image updates, one config entry or rolling update group at a time
+	for _, group := range groupUpdates(pending) {
+		if err := ctx.Err(); err != nil {
+			return report, fmt.Errorf("reconcile cancelled: %v", err)
+		}
+		for name, err := range r.applyUpdates(ctx, group) {
+			if err != nil {
+				log.Errorf("Error updating container %s: %v", name, err)
+				report.set(name, ActionFailed, err)
+			} else {
+				report.set(name, ActionUpdated, nil)
+				r.clearAvailable(name)
+			}
+		}
+	}
+
+	configured := make(map[string]bool)
+	for _, container := range containers {
+		configured[container.Name] = true
+	}
+	r.pruneAvailable(configured)
+	r.metrics.RetainContainers(configured)
+
+	r.recordState(ctx, started, containers, report)
+
+	// Remove superseded images now that the state knows the previous images, and make sure
+	// the retained ones are still there
+	if r.state != nil {
+		if cfg.AppConfig.ImageGC.Enabled {
+			r.collectImages(ctx, report)
+		}
+		r.restorePreviousImages(ctx)
+	}
+
+	return report, nil
+}
+
+// resolveSpec settles the image spec runs beyond its config, for reconciles and auto heal
+// alike: containers with an update policy keep the newer tag they were moved to, containers
+// pinned through the API keep their digest and pin_digest containers get the digest of their
+// tag. digests caches the resolved digests by image, so every container of a run uses the
+// same one.
+func (r *Reconciler) resolveSpec(ctx context.Context, spec *docker.ContainerConfig, pins map[string]string, digests map[string]string) error {
+	if tracksTags(*spec) {
+		spec.BaseImage = spec.Image
+		spec.Image = r.trackedImage(ctx, *spec)
+	}
+
+	if digest, ok := pins[spec.Name]; ok {
+		spec.Pinned, spec.Digest = true, digest
+		return nil
+	}
+	if !spec.PinDigest {
+		return nil
+	}
+	if _, ok := digests[spec.Image]; !ok {
+		resolve := docker.ResolveDigest
+		if spec.PullPolicy == config.PullPolicyNever {
+			resolve = func(cli *client.Client, ref string, _ string) (string, error) {
+				return docker.ImageDigest(cli, ref, ref)
+			}
+		}
+		digest, err := resolve(r.cli, spec.Image, spec.Platform)
+		if err != nil {
+			return fmt.Errorf("error resolving digest of %s: %v", spec.Image, err)
+		}
+		digests[spec.Image] = digest
+	}
+	spec.Digest = digests[spec.Image]
+	return nil
+}
+
+// ensureContainer creates a single container if needed, recreates it on config drift and
+// makes sure it is running. It returns the ID of the resulting container.
+func (r *Reconciler) ensureContainer(ctx context.Context, container docker.ContainerConfig) (Action, string, error) {
+	action := ActionUnchanged
+
+	// get running containers
+	runningContainers, err := docker.ListAllContariners(r.cli)
+	if err != nil {
+		return action, "", err
+	}
+
+	// check if container already exists
+	var existing *types.Container
+	for i, runningContainer := range runningContainers {
+		if runningContainer.Names[0] == "/"+container.Name {
+			log.Debugf("Container %s already exists\n", container.Name)
+			existing = &runningContainers[i]
+			break
+		}
+	}
+	found := existing != nil
+
+	// Frozen and protected containers are left alone as long as they exist, adoption included
+	if found && (container.Frozen || r.isFrozen(container.Name)) {
+		log.Infof("Container %s is frozen, skipping", container.Name)
+		return ActionFrozen, "", nil
+	}
+	if found && r.isProtected(container.Name) {
+		log.Infof("Container %s is protected, skipping", container.Name)
+		return ActionProtected, "", nil
+	}
+
+	if found && !r.owns(*existing) {
+		if !r.appConfig.AdoptExisting {
+			return action, "", fmt.Errorf("container %s exists but is not managed by docker-manager", container.Name)
+		}
+		if err := r.adopt(ctx, *existing, container); err != nil {
+			return action, "", err
+		}
+		action = ActionAdopted
+	}
+
+	if err := r.ensureNetworksAndVolumes(ctx, container); err != nil {
+		return action, "", err
+	}
+
+	// Create container if not found
+	var created bool
+	if !found {
+		if err := r.pullForCreate(ctx, container); err != nil {
+			return action, "", err
+		}
+		err, created = docker.CreateContainer(r.cli, container)
+		if created || err != nil {
+			r.audit(ctx, audit.Entry{Action: audit.ActionCreate, Container: container.Name, Reason: "missing", NewImage: container.Image}, err)
+		}
+		if err != nil {
+			return action, "", err
+		}
+		if created {
+			log.Infof("Container %s created", container.Name)
+			action = ActionCreated
+		}
+	}
+
+	if !created {
+		configAction, err := r.ensureContainerConfig(ctx, container)
+		if err != nil {
+			return configAction, "", fmt.Errorf("error ensuring container configuration: %v", err)
+		}
+		// A freshly adopted container matches config, keep reporting the adoption
+		if action != ActionAdopted || configAction != ActionUnchanged {
+			action = configAction
+		}
+	}
+
+	// Get container ID from name
+	ctid, err := docker.GetContainerIDByName(r.cli, container.Name)
+	if err != nil {
+		return action, "", err
+	}
+
+	// Ensure container is running
+	err = r.ensureRunning(ctx, container.Name, ctid)
+	if err != nil {
+		return action, ctid, err
+	}
+
+	if action == ActionCreated || action == ActionRecreated {
+		// New containers must become healthy before they count as ensured
+		if container.WaitHealthy {
+			if err := r.waitHealthy(container, ctid); err != nil {
+				return action, ctid, err
+			}
+		}
+		if err := r.runHook(container, "post_start", container.Hooks.PostStart, ctid); err != nil {
+			return action, ctid, err
+		}
+	}
+
+	log.Infof("Container %v ensured\n", container.Name)
+	return action, ctid, nil
+}
+
+// ensureContainerConfig checks if a running container matches the given ContainerConfig and
+// recreates it if necessary. With the in-place drift strategy, drift limited to resource limits
+// is applied with ContainerUpdate instead, avoiding downtime.
+func (r *Reconciler) ensureContainerConfig(ctx context.Context, config docker.ContainerConfig) (Action, error) {
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return ActionUnchanged, err
+	}
+
+	for _, container := range containers {
+		if container.Names[0] == "/"+config.Name {
+			inspect, err := r.cli.ContainerInspect(ctx, container.ID)
+			if err != nil {
+				return ActionUnchanged, err
+			}
+
+			// Validate container configuration
+			drift := detectDrift(inspect, config)
+			r.metrics.SetDrift(config.Name, driftReasons(drift))
+
+			if len(drift) == 0 {
+				log.Debugf("Config for container %s already up to date\n", config.Name)
+				return ActionUnchanged, nil
+			}
+
+			if updatableInPlace(config, drift) {
+				log.Infof("Container %s resources do not match (%s), updating in place...", config.Name, driftFields(drift))
+				err = docker.UpdateResources(r.cli, container.ID, config.Resources)
+				r.audit(ctx, audit.Entry{
+					Action:    audit.ActionUpdate,
+					Container: config.Name,
+					Reason:    "drift (in place): " + driftFields(drift),
+					OldImage:  inspect.Config.Image,
+					NewImage:  inspect.Config.Image,
+				}, err)
+				if err != nil {
+					return ActionUnchanged, err
+				}
+				r.metrics.SetDrift(config.Name, nil)
+				return ActionReconfigured, nil
+			}
+
+			if !r.inMaintenanceWindow() {
+				r.deferAction(config.Name, "recreate", "drift: "+driftFields(drift))
+				return ActionDeferred, nil
+			}
+
+			log.Infof("Container %s configuration does not match (%s), recreating it...\n", config.Name, driftFields(drift))
+
+			// create container with the correct configuration
+			err = r.recreate(ctx, container.ID, config)
+			r.audit(ctx, audit.Entry{
+				Action:    audit.ActionRecreate,
+				Container: config.Name,
+				Reason:    "drift: " + driftFields(drift),
+				OldImage:  inspect.Config.Image,
+				NewImage:  config.Image,
+			}, err)
+			if err != nil {
+				return ActionUnchanged, err
+			}
+			r.metrics.SetDrift(config.Name, nil)
+			log.Infof("Container %s recreated with the correct configuration\n", config.Name)
+			return ActionRecreated, nil
+		}
+	}
+
+	log.Infof("Container %s not found, creating it...\n", config.Name)
+	err, created := docker.CreateContainer(r.cli, config)
+	if created || err != nil {
+		r.audit(ctx, audit.Entry{Action: audit.ActionCreate, Container: config.Name, Reason: "missing", NewImage: config.Image}, err)
+	}
+	if created {
+		return ActionRecreated, err
+	}
+	return ActionUnchanged, err
+}
+
+// owns reports whether the manager may modify a container
+func (r *Reconciler) owns(container types.Container) bool {
+	return r.appConfig.ManageAllContainers || docker.IsManaged(container.Labels) || r.adopted(container)
+}
+
+// removeUnwantedContainers removes every managed container not specified in configs. Failures
+// to remove a single container are recorded in the report without stopping the run.
+func (r *Reconciler) removeUnwantedContainers(ctx context.Context, configs []docker.ContainerConfig, report *Report) error {
+	// get running containers
+	containers, err := docker.ListAllContariners(r.cli)
+	if err != nil {
+		return err
+	}
+
+	// check if container is not specified in configs
+	for _, container := range containers {
+		if !r.owns(container) || r.isProtected(strings.TrimPrefix(container.Names[0], "/")) {
+			continue
+		}
+
+		found := false
+		for _, config := range configs {
+			if container.Names[0] == "/"+config.Name {
+				found = true
+				break
+			}
+		}
+		if !found && !r.inMaintenanceWindow() {
+			r.deferAction(strings.TrimPrefix(container.Names[0], "/"), "remove", "unwanted")
+			report.add(strings.TrimPrefix(container.Names[0], "/"), ActionDeferred, nil)
+			continue
+		}
+		if !found {
+			log.Infof("Container %s (%s) not desired, removing ...\n", container.Names[0], container.ID)
+			err = docker.DeleteContainer(r.cli, container.ID)
+			r.audit(ctx, audit.Entry{Action: audit.ActionRemove, Container: strings.TrimPrefix(container.Names[0], "/"), Reason: "unwanted", OldImage: container.Image}, err)
+			if err != nil {
+				log.Errorf("Error removing container %s: %v", container.Names[0], err)
+			} else {
+				log.Debug("Container removed\n")
+			}
+			report.add(strings.TrimPrefix(container.Names[0], "/"), ActionRemoved, err)
+		}
+	}
+
+	return nil
+}
+
+// removeScaledDown removes managed containers created from a configured entry that are no
+// longer part of it, such as replicas above the configured replica count
+func (r *Reconciler) removeScaledDown(ctx context.Context, configs []docker.ContainerConfig, report *Report) error {
+	entries := make(map[string]bool)
+	desired := make(map[string]bool)
+	for _, config := range configs {
+		entries[config.Entry] = true
+		desired[config.Name] = true
+	}
+
+	containers, err := docker.ListAllContariners(r.cli)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		name := strings.TrimPrefix(container.Names[0], "/")
+		if !docker.IsManaged(container.Labels) || !entries[container.Labels[docker.LabelEntry]] ||
+			desired[name] || strings.HasSuffix(name, blueGreenSuffix) || r.isProtected(name) {
+			continue
+		}
+
+		if !r.inMaintenanceWindow() {
+			r.deferAction(name, "remove", "scaled down")
+			report.add(name, ActionDeferred, nil)
+			continue
+		}
+
+		log.Infof("Container %s is no longer part of %s, removing ...", name, container.Labels[docker.LabelEntry])
+		err = docker.DeleteContainer(r.cli, container.ID)
+		r.audit(ctx, audit.Entry{Action: audit.ActionRemove, Container: name, Reason: "scaled down", OldImage: container.Image}, err)
+		if err != nil {
+			log.Errorf("Error removing container %s: %v", name, err)
+		}
+		report.add(name, ActionRemoved, err)
+	}
+
+	return nil
+}
+
+// ensureRunning starts a container if it is not running, recording the start in the audit log
+func (r *Reconciler) ensureRunning(ctx context.Context, name string, containerID string) error {
+	inspect, err := r.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State != nil && inspect.State.Running {
+		return nil
+	}
+	if r.isStopped(name) {
+		log.Infof("Container %s was stopped through the API, not starting it", name)
+		return nil
+	}
+
+	err = docker.EnsureRunningContainers(r.cli, containerID)
+	r.audit(ctx, audit.Entry{Action: audit.ActionStart, Container: name, Reason: "not running", NewImage: inspect.Config.Image}, err)
+	return err
+}
@@ -0,0 +1,175 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/events"
+)
+
+// Action describes what happened to a container during a reconcile
+type Action string
+
+const (
+	ActionCreated      Action = "created"
+	ActionRecreated    Action = "recreated"
+	ActionReconfigured Action = "reconfigured"
+	ActionUpdated      Action = "updated"
+	ActionRemoved      Action = "removed"
+	ActionUnchanged    Action = "unchanged"
+	ActionFrozen       Action = "frozen"
+	ActionProtected    Action = "protected"
+	ActionAdopted      Action = "adopted"
+	// ActionDeferred is a recreate, update or removal postponed until a maintenance window
+	ActionDeferred Action = "deferred"
+	// ActionUpdateAvailable is a newer image found for a container in notify update mode
+	ActionUpdateAvailable Action = "update-available"
+	ActionFailed          Action = "failed"
+)
+
+// Result is the outcome of reconciling a single container
+type Result struct {
+	Container string
+	Action    Action
+	Err       error
+}
+
+// Report aggregates the results of a reconcile run
+type Report struct {
+	Results []Result
+}
+
+func (r *Report) add(container string, action Action, err error) {
+	if err != nil {
+		action = ActionFailed
+	}
+	r.Results = append(r.Results, Result{Container: container, Action: action, Err: err})
+}
+
+// set replaces the result recorded earlier for a container
+func (r *Report) set(container string, action Action, err error) {
+	if err != nil {
+		action = ActionFailed
+	}
+	for i := range r.Results {
+		if r.Results[i].Container == container {
+			r.Results[i] = Result{Container: container, Action: action, Err: err}
+			return
+		}
+	}
+	r.Results = append(r.Results, Result{Container: container, Action: action, Err: err})
+}
+
+// Failed returns the results that ended in an error
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Changed returns the results of containers the reconcile created, recreated, reconfigured,
+// updated, adopted or removed
+func (r *Report) Changed() []Result {
+	var changed []Result
+	for _, result := range r.Results {
+		switch result.Action {
+		case ActionCreated, ActionRecreated, ActionReconfigured, ActionUpdated, ActionAdopted, ActionRemoved:
+			changed = append(changed, result)
+		}
+	}
+	return changed
+}
+
+// Deferred returns the results of changes postponed until a maintenance window
+func (r *Report) Deferred() []Result {
+	var deferred []Result
+	for _, result := range r.Results {
+		if result.Action == ActionDeferred {
+			deferred = append(deferred, result)
+		}
+	}
+	return deferred
+}
+
+// Err joins all per-container errors, or returns nil if every container reconciled
+func (r *Report) Err() error {
+	var errs []error
+	for _, result := range r.Failed() {
+		errs = append(errs, fmt.Errorf("%s: %v", result.Container, result.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// String renders the report as one line per container
+func (r *Report) String() string {
+	var b strings.Builder
+	for _, result := range r.Results {
+		if result.Err != nil {
+			fmt.Fprintf(&b, "%s: %s (%v)\n", result.Container, result.Action, result.Err)
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", result.Container, result.Action)
+		}
+	}
+	failed := len(r.Failed())
+	fmt.Fprintf(&b, "%d containers reconciled, %d failed\n", len(r.Results)-failed, failed)
+	return b.String()
+}
+
+// recordRun records the outcome of a reconcile run in the manager metrics and for Status
+func (r *Reconciler) recordRun(started time.Time, report *Report, err error) {
+	result := "success"
+	switch {
+	case err != nil:
+		result = "error"
+	case len(report.Failed()) > 0:
+		result = "partial"
+	}
+	r.metrics.ReconcileRun(time.Since(started), result)
+
+	summary := &RunSummary{Started: started, Finished: time.Now(), Result: result}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	if report != nil {
+		summary.Failed = len(report.Failed())
+	}
+	r.lastRunMu.Lock()
+	r.lastRun = summary
+	r.lastRunMu.Unlock()
+	r.publishRun(summary, report)
+
+	if report == nil {
+		return
+	}
+	for _, res := range report.Results {
+		switch res.Action {
+		case ActionCreated:
+			r.metrics.ContainerCreated()
+		case ActionRecreated, ActionUpdated:
+			r.metrics.ContainerRecreated()
+		case ActionRemoved:
+			r.metrics.ContainerRemoved()
+		}
+	}
+}
+
+// publishRun publishes the end of a reconcile run and every container that failed in it
+func (r *Reconciler) publishRun(summary *RunSummary, report *Report) {
+	if report != nil {
+		for _, result := range report.Failed() {
+			r.events.Publish(events.Event{Type: events.TypeError, Container: result.Container, Error: result.Err.Error()})
+		}
+	}
+
+	message := summary.Result
+	if report != nil {
+		message = fmt.Sprintf("%s: %d containers reconciled, %d failed", summary.Result, len(report.Results)-summary.Failed, summary.Failed)
+	}
+	r.events.Publish(events.Event{Type: events.TypeReconcileFinished, Time: summary.Finished, Message: message, Error: summary.Error})
+}
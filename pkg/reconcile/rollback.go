@@ -0,0 +1,124 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotConfigured is returned for actions on containers that are not in the config
+	ErrNotConfigured = errors.New("container is not configured")
+	// ErrNoPreviousImage is returned when rolling back a container without a recorded previous image
+	ErrNoPreviousImage = errors.New("no previous image recorded")
+)
+
+// Rollback recreates a container from the image it ran before, as recorded in the state store,
+// and holds its current image back until a newer one is released. It returns the image ID
+// rolled back to.
+func (r *Reconciler) Rollback(ctx context.Context, cfg *config.Config, name string) (string, error) {
+	if r.state == nil {
+		return "", fmt.Errorf("rollbacks require a state store")
+	}
+
+	if err := r.acquire(ctx, cfg.AppConfig.ConcurrentReconcile == config.ConcurrentReconcileQueue); err != nil {
+		return "", err
+	}
+	defer r.release()
+	r.appConfig = cfg.AppConfig
+	r.protected = compileProtected(cfg.AppConfig.ProtectedContainers)
+
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return "", fmt.Errorf("error converting config to Docker config: %v", err)
+	}
+	var spec *docker.ContainerConfig
+	for i := range containers {
+		if containers[i].Name == name {
+			spec = &containers[i]
+		}
+	}
+	if spec == nil {
+		return "", ErrNotConfigured
+	}
+
+	record, err := r.state.Container(name)
+	if err != nil {
+		return "", err
+	}
+	if record == nil || len(record.PreviousImages) == 0 {
+		return "", ErrNoPreviousImage
+	}
+	target := record.PreviousImages[0]
+
+	ctid, err := docker.GetContainerIDByName(r.cli, name)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.rollbackContainer(ctx, *spec, ctid, target, record.PreviousDigests[target])
+	r.audit(ctx, audit.Entry{
+		Action:    audit.ActionRollback,
+		Container: name,
+		Reason:    "manual",
+		OldImage:  record.ImageID,
+		NewImage:  target,
+	}, err)
+	if err != nil {
+		return "", err
+	}
+
+	// Don't update straight back to the image that was rolled back from
+	r.blockImage(spec.Entry, record.ImageID)
+
+	if err := r.recordContainer(ctx, *spec); err != nil {
+		log.Errorf("Error recording state of container %s: %v", name, err)
+	}
+	log.Infof("Container %s rolled back to image %s", name, target)
+	return target, nil
+}
+
+// rollbackContainer recreates a container from targetImage using its update strategy.
+// Pinned containers are created from the digest of the image, others get their configured
+// tag pointed back at it. Missing images are pulled again by digest.
+func (r *Reconciler) rollbackContainer(ctx context.Context, spec docker.ContainerConfig, containerID string, targetImage string, digest string) error {
+	if _, _, err := r.cli.ImageInspectWithRaw(ctx, targetImage); docker.IsNotFound(err) {
+		if digest == "" {
+			return fmt.Errorf("image %s was removed and has no recorded digest to pull it again", targetImage)
+		}
+		if err := docker.PullPlatformImage(r.cli, digest, spec.Platform); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if spec.PinDigest {
+		spec.Digest = digest
+		if spec.Digest == "" {
+			var err error
+			if spec.Digest, err = docker.ImageDigest(r.cli, targetImage, spec.Image); err != nil {
+				return err
+			}
+		}
+	} else if err := r.cli.ImageTag(ctx, targetImage, spec.Image); err != nil {
+		return err
+	}
+
+	// The image is already local, the pull policy must not move the tag forward again
+	spec.PullPolicy = config.PullPolicyNever
+	if err := r.recreate(ctx, containerID, spec); err != nil {
+		return err
+	}
+
+	ctid, err := docker.GetContainerIDByName(r.cli, spec.Name)
+	if err != nil {
+		return err
+	}
+	return r.ensureRunning(ctx, spec.Name, ctid)
+}
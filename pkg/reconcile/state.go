@@ -0,0 +1,153 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	"github.com/huxcrux/docker-manager/pkg/events"
+	"github.com/huxcrux/docker-manager/pkg/scan"
+	"github.com/huxcrux/docker-manager/pkg/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// recordState stores the managed containers and the run in the state store
+func (r *Reconciler) recordState(ctx context.Context, started time.Time, containers []docker.ContainerConfig, report *Report) {
+	if r.state == nil {
+		return
+	}
+
+	specs := make(map[string]docker.ContainerConfig)
+	for _, container := range containers {
+		specs[container.Name] = container
+	}
+
+	run := state.RunRecord{Started: started, Finished: time.Now()}
+	for _, result := range report.Results {
+		record := state.ResultRecord{Container: result.Container, Action: string(result.Action)}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+		}
+		run.Results = append(run.Results, record)
+
+		if result.Action == ActionRemoved && result.Err == nil {
+			if err := r.state.DeleteContainer(result.Container); err != nil {
+				log.Errorf("Error removing %s from state: %v", result.Container, err)
+			}
+			continue
+		}
+
+		spec, ok := specs[result.Container]
+		if !ok || result.Err != nil {
+			continue
+		}
+		if err := r.recordContainer(ctx, spec); err != nil {
+			log.Errorf("Error recording state of container %s: %v", spec.Name, err)
+		}
+	}
+
+	if err := r.state.AddRun(run); err != nil {
+		log.Errorf("Error recording reconcile run: %v", err)
+	}
+}
+
+// recordContainer stores the current state of a managed container
+func (r *Reconciler) recordContainer(ctx context.Context, spec docker.ContainerConfig) error {
+	ctid, err := docker.GetContainerIDByName(r.cli, spec.Name)
+	if err != nil {
+		return err
+	}
+	inspect, err := r.cli.ContainerInspect(ctx, ctid)
+	if err != nil {
+		return err
+	}
+
+	// Pinned containers record the digest they were created from, others the digest of their image
+	digest := inspect.Config.Labels[docker.LabelDigest]
+	if digest == "" {
+		digest, _ = docker.ImageDigest(r.cli, inspect.Image, spec.Image)
+	}
+
+	err = r.state.PutContainer(state.ContainerRecord{
+		Name:        spec.Name,
+		Entry:       spec.Entry,
+		ContainerID: ctid,
+		ConfigHash:  spec.Hash(),
+		Image:       spec.Image,
+		ImageID:     inspect.Image,
+		Digest:      digest,
+		UpdatedAt:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return r.recordImage(ctx, spec, inspect.Image, digest)
+}
+
+// recordImage stores the provenance of the image a container runs: its digest, labels and,
+// if enabled, an SBOM. It is only captured when the container runs a different image than
+// recorded before.
+func (r *Reconciler) recordImage(ctx context.Context, spec docker.ContainerConfig, imageID string, digest string) error {
+	previous, err := r.state.Image(spec.Name)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.ImageID == imageID {
+		return nil
+	}
+
+	inspect, _, err := r.cli.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	record := state.ImageRecord{
+		Container:  spec.Name,
+		Image:      spec.Image,
+		ImageID:    imageID,
+		Digest:     digest,
+		Created:    inspect.Created,
+		DeployedAt: time.Now(),
+	}
+	if inspect.Config != nil {
+		record.Labels = inspect.Config.Labels
+	}
+
+	// An SBOM that can't be generated doesn't stop recording the rest
+	if sbom := r.appConfig.SBOM; sbom.Enabled {
+		ref := digest
+		if ref == "" {
+			ref = spec.Image
+		}
+		timeout := sbom.Timeout
+		if timeout <= 0 {
+			timeout = DefaultScanTimeout
+		}
+		sbomCtx, cancel := context.WithTimeout(ctx, timeout)
+		record.SBOMFormat, record.SBOM, err = scan.SBOM(sbomCtx, sbom.Generator, sbom.Path, ref)
+		cancel()
+		if err != nil {
+			log.Errorf("Error generating SBOM of container %s: %v", spec.Name, err)
+		}
+	}
+
+	return r.state.PutImage(record)
+}
+
+// audit records a mutating action in the audit log and reports it as progress, err is the
+// outcome of the action
+func (r *Reconciler) audit(ctx context.Context, entry audit.Entry, err error) {
+	entry.Requester = audit.Requester(ctx)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	auditProgress(ctx, entry)
+	r.events.Publish(events.Event{Type: entry.Action, Container: entry.Container, Message: auditMessage(entry), Error: entry.Error})
+
+	if r.auditLog == nil {
+		return
+	}
+	if err := r.auditLog.Record(entry); err != nil {
+		log.Errorf("Error writing audit log: %v", err)
+	}
+}
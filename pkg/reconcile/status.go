@@ -0,0 +1,255 @@
+package reconcile
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status summarises what the manager holds back or waits for and how each configured
+// container compares to its config
+type Status struct {
+	Paused bool `json:"paused"`
+	// Frozen are the containers frozen through the API
+	Frozen []string `json:"frozen"`
+	// Stopped are the containers stopped through the API
+	Stopped []string `json:"stopped"`
+	// UpdatesAvailable are newer images found but not applied
+	UpdatesAvailable []AvailableUpdate `json:"updates_available"`
+	// Deferred are actions waiting for a maintenance window
+	Deferred []DeferredAction `json:"deferred"`
+	// Pinned are the digests containers are pinned to through the API
+	Pinned map[string]string `json:"pinned,omitempty"`
+	// LastReconcile is the most recent reconcile run, if there was one
+	LastReconcile *RunSummary `json:"last_reconcile,omitempty"`
+	// Containers are the configured containers, in config order
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// RunSummary is the outcome of a reconcile run
+type RunSummary struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Result is success, partial (some containers failed) or error (the run didn't complete)
+	Result string `json:"result"`
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ContainerStatus compares a configured container with the one running on the host
+type ContainerStatus struct {
+	Name    string       `json:"name"`
+	Desired DesiredState `json:"desired"`
+	// Actual is missing if the container doesn't exist
+	Actual *ActualState `json:"actual,omitempty"`
+	// InSync is set if the container exists, runs (or is stopped through the API), has no
+	// drift and no pending update
+	InSync bool `json:"in_sync"`
+	// Drift are the settings recreating the container would change
+	Drift           []Drift          `json:"drift,omitempty"`
+	Frozen          bool             `json:"frozen,omitempty"`
+	Stopped         bool             `json:"stopped,omitempty"`
+	UpdateAvailable *AvailableUpdate `json:"update_available,omitempty"`
+	Deferred        *DeferredAction  `json:"deferred,omitempty"`
+}
+
+// DesiredState is what the config asks for, environment variables are left out as they may
+// hold secrets
+type DesiredState struct {
+	// Entry is the config entry, which differs from the name for replicas
+	Entry string `json:"entry"`
+	Image string `json:"image"`
+	// Digest is set for containers pinned through the API
+	Digest string `json:"digest,omitempty"`
+	// Ports are the port bindings as host_ip:host_port:container_port/protocol
+	Ports      []string `json:"ports,omitempty"`
+	Networks   []string `json:"networks,omitempty"`
+	Memory     int64    `json:"memory,omitempty"`
+	CPUs       float64  `json:"cpus,omitempty"`
+	UpdateMode string   `json:"update_mode"`
+	// UpdatePolicy is the tag policy, empty for containers tracking their configured tag
+	UpdatePolicy string `json:"update_policy,omitempty"`
+}
+
+// ActualState is what the container on the host runs
+type ActualState struct {
+	ID string `json:"id"`
+	// State is the Docker state, such as running or exited
+	State string `json:"state"`
+	// Health is the health check status, if the container has a health check
+	Health    string    `json:"health,omitempty"`
+	Image     string    `json:"image"`
+	ImageID   string    `json:"image_id"`
+	Digest    string    `json:"digest,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	// UptimeSeconds is how long a running container has been up
+	UptimeSeconds float64 `json:"uptime_seconds,omitempty"`
+}
+
+// Status returns the current status of the manager, inspecting the containers of cfg
+func (r *Reconciler) Status(ctx context.Context, cfg *config.Config) (Status, error) {
+	pinned, err := r.Pinned()
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{
+		Paused:           r.Paused(),
+		Frozen:           r.Frozen(),
+		Stopped:          r.Stopped(),
+		UpdatesAvailable: r.Available(),
+		Deferred:         r.Deferred(),
+		Pinned:           pinned,
+		LastReconcile:    r.lastReconcile(),
+	}
+	status.Containers, err = r.containers(ctx, cfg, pinned, status.UpdatesAvailable, status.Deferred)
+	if err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Containers compares every configured container of cfg with the one on the host
+func (r *Reconciler) Containers(ctx context.Context, cfg *config.Config) ([]ContainerStatus, error) {
+	pinned, err := r.Pinned()
+	if err != nil {
+		return nil, err
+	}
+	return r.containers(ctx, cfg, pinned, r.Available(), r.Deferred())
+}
+
+func (r *Reconciler) containers(ctx context.Context, cfg *config.Config, pinned map[string]string, updates []AvailableUpdate, actions []DeferredAction) ([]ContainerStatus, error) {
+	containers, err := config.ConfigToDockerConfig(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	running, err := docker.ListAllContariners(r.cli)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string)
+	for _, container := range running {
+		ids[strings.TrimPrefix(container.Names[0], "/")] = container.ID
+	}
+
+	available := make(map[string]AvailableUpdate)
+	for _, update := range updates {
+		available[update.Container] = update
+	}
+	deferred := make(map[string]DeferredAction)
+	for _, action := range actions {
+		deferred[action.Container] = action
+	}
+
+	statuses := make([]ContainerStatus, 0, len(containers))
+
+	for _, container := range containers {
+		if tracksTags(container) {
+			container.Image = r.trackedImage(ctx, container)
+		}
+		if digest, ok := pinned[container.Name]; ok {
+			container.Pinned, container.Digest = true, digest
+		}
+
+		entry := ContainerStatus{
+			Name:    container.Name,
+			Desired: desiredState(container),
+			Frozen:  container.Frozen || r.isFrozen(container.Name),
+			Stopped: r.isStopped(container.Name),
+		}
+		if update, ok := available[container.Name]; ok {
+			entry.UpdateAvailable = &update
+		}
+		if action, ok := deferred[container.Name]; ok {
+			entry.Deferred = &action
+		}
+
+		if id, ok := ids[container.Name]; ok {
+			inspect, err := r.cli.ContainerInspect(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			entry.Actual = actualState(inspect)
+			if digest, err := docker.ImageDigest(r.cli, inspect.Image, inspect.Config.Image); err == nil {
+				entry.Actual.Digest = digest
+			} else {
+				log.Debugf("No digest for image of container %s: %v", container.Name, err)
+			}
+			entry.Drift = detectDrift(inspect, container)
+			entry.InSync = (entry.Actual.State == "running") != entry.Stopped && len(entry.Drift) == 0 && entry.UpdateAvailable == nil && entry.Deferred == nil
+		}
+		statuses = append(statuses, entry)
+	}
+	return statuses, nil
+}
+
+func desiredState(container docker.ContainerConfig) DesiredState {
+	desired := DesiredState{
+		Entry:        container.Entry,
+		Image:        container.Image,
+		Digest:       container.Digest,
+		Networks:     container.Networks,
+		Memory:       container.Resources.Memory,
+		CPUs:         float64(container.Resources.NanoCPUs) / 1e9,
+		UpdateMode:   cmp.Or(container.UpdateMode, config.UpdateModeAuto),
+		UpdatePolicy: container.UpdatePolicy,
+	}
+	for port, bindings := range container.PortBindings {
+		for _, binding := range bindings {
+			desired.Ports = append(desired.Ports, fmt.Sprintf("%s:%s:%s", binding.HostIP, binding.HostPort, port))
+		}
+	}
+	sort.Strings(desired.Ports)
+	return desired
+}
+
+func actualState(inspect types.ContainerJSON) *ActualState {
+	actual := &ActualState{ID: inspect.ID, Image: inspect.Config.Image, ImageID: inspect.Image}
+	if inspect.State != nil {
+		actual.State = inspect.State.Status
+		if inspect.State.Health != nil {
+			actual.Health = inspect.State.Health.Status
+		}
+		if started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt); err == nil && !started.IsZero() && actual.State == "running" {
+			actual.StartedAt = started
+			actual.UptimeSeconds = time.Since(started).Round(time.Second).Seconds()
+		}
+	}
+	return actual
+}
+
+// lastReconcile returns the last run of this process, or the last one recorded in the state
+// store after a restart
+func (r *Reconciler) lastReconcile() *RunSummary {
+	r.lastRunMu.RLock()
+	last := r.lastRun
+	r.lastRunMu.RUnlock()
+	if last != nil || r.state == nil {
+		return last
+	}
+
+	runs, err := r.state.Runs(1)
+	if err != nil || len(runs) == 0 {
+		return nil
+	}
+	summary := &RunSummary{Started: runs[0].Started, Finished: runs[0].Finished, Result: "success", Error: runs[0].Error}
+	for _, result := range runs[0].Results {
+		if result.Error != "" {
+			summary.Failed++
+		}
+	}
+	switch {
+	case summary.Error != "":
+		summary.Result = "error"
+	case summary.Failed > 0:
+		summary.Result = "partial"
+	}
+	return summary
+}
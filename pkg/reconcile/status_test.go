@@ -0,0 +1,29 @@
+package reconcile
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+)
+
+func TestDesiredState(t *testing.T) {
+	spec := docker.ContainerConfig{
+		Entry: "web",
+		Image: "nginx:1.27",
+		PortBindings: nat.PortMap{
+			"80/tcp":  {{HostIP: "127.0.0.1", HostPort: "8080"}},
+			"443/tcp": {{HostPort: "8443"}},
+		},
+		Resources: docker.Resources{NanoCPUs: 1500000000},
+	}
+
+	desired := desiredState(spec)
+	if want := []string{"127.0.0.1:8080:80/tcp", ":8443:443/tcp"}; !slices.Equal(desired.Ports, want) {
+		t.Errorf("Expected ports %v, got %v", want, desired.Ports)
+	}
+	if desired.CPUs != 1.5 || desired.UpdateMode != "auto" || desired.Entry != "web" {
+		t.Errorf("Unexpected desired state %+v", desired)
+	}
+}
@@ -0,0 +1,204 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// blueGreenSuffix is appended to the name of the replacement container while it is verified
+const blueGreenSuffix = "_docker-manager-new"
+
+// renameSuffix is appended to the name of the old container while its replacement is verified
+const renameSuffix = "_docker-manager-old"
+
+// recreate replaces the container oldID with a new container built from spec using the
+// container's configured strategy
+func (r *Reconciler) recreate(ctx context.Context, oldID string, spec docker.ContainerConfig) error {
+	if err := r.pullForCreate(ctx, spec); err != nil {
+		return err
+	}
+
+	switch spec.Strategy {
+	case config.StrategyBlueGreen:
+		// Two containers can't bind the same host port at the same time
+		if hasHostPorts(spec) {
+			log.Warnf("Container %s publishes host ports, falling back to the recreate strategy", spec.Name)
+			break
+		}
+		return r.recreateBlueGreen(ctx, oldID, spec)
+	case config.StrategyRename:
+		return r.recreateRename(ctx, oldID, spec)
+	}
+	return r.recreateInPlace(ctx, oldID, spec)
+}
+
+// recreateInPlace deletes the old container and creates the new one in its place
+func (r *Reconciler) recreateInPlace(ctx context.Context, oldID string, spec docker.ContainerConfig) error {
+	err := r.stopHook(spec, oldID)
+	if err != nil {
+		return err
+	}
+
+	err = docker.DeleteContainer(r.cli, oldID)
+	if err != nil {
+		return err
+	}
+
+	err, _ = docker.CreateContainer(r.cli, spec)
+	return err
+}
+
+// recreateBlueGreen starts the new container under a temporary name and waits for it to
+// become healthy before the old container is removed and the new one takes over its name.
+// If the new container fails to start or become healthy it is removed and the old one is kept.
+func (r *Reconciler) recreateBlueGreen(ctx context.Context, oldID string, spec docker.ContainerConfig) error {
+	green := spec
+	green.Name = spec.Name + blueGreenSuffix
+
+	// Remove leftovers from an earlier failed attempt
+	if leftover, err := docker.GetContainerIDByName(r.cli, green.Name); err == nil {
+		if err := docker.DeleteContainer(r.cli, leftover); err != nil {
+			return fmt.Errorf("error removing leftover container %s: %v", green.Name, err)
+		}
+	}
+
+	err, _ := docker.CreateContainer(r.cli, green)
+	if err != nil {
+		return err
+	}
+	greenID, err := docker.GetContainerIDByName(r.cli, green.Name)
+	if err != nil {
+		return err
+	}
+
+	err = docker.EnsureRunningContainers(r.cli, greenID)
+	if err == nil {
+		err = r.waitHealthy(spec, greenID)
+	}
+	if err != nil {
+		log.Warnf("Replacement for container %s failed, keeping the old container: %v", spec.Name, err)
+		if rmErr := docker.DeleteContainer(r.cli, greenID); rmErr != nil {
+			log.Errorf("Error removing failed replacement %s: %v", green.Name, rmErr)
+		}
+		return err
+	}
+
+	err = r.stopHook(spec, oldID)
+	if err == nil {
+		err = docker.DeleteContainer(r.cli, oldID)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Renaming container %s to %s", green.Name, spec.Name)
+	return docker.RenameContainer(r.cli, greenID, spec.Name)
+}
+
+// recreateRename renames the old container out of the way and creates the new one under the
+// real name, so the name is never vacant. The old container is stopped only once the new one
+// exists, and removed only once the new one is running and healthy. On failure the new container
+// is removed and the old one gets its name back and is started again.
+func (r *Reconciler) recreateRename(ctx context.Context, oldID string, spec docker.ContainerConfig) error {
+	oldName := spec.Name + renameSuffix
+
+	// Remove leftovers from an earlier attempt that was interrupted after the new container took over
+	if leftover, err := docker.GetContainerIDByName(r.cli, oldName); err == nil && leftover != oldID {
+		if err := docker.DeleteContainer(r.cli, leftover); err != nil {
+			return fmt.Errorf("error removing leftover container %s: %v", oldName, err)
+		}
+	}
+
+	log.Debugf("Renaming container %s to %s", spec.Name, oldName)
+	if err := docker.RenameContainer(r.cli, oldID, oldName); err != nil {
+		return err
+	}
+
+	err, _ := docker.CreateContainer(r.cli, spec)
+	if err != nil {
+		return r.restoreRenamed(oldID, spec, "", err)
+	}
+	newID, err := docker.GetContainerIDByName(r.cli, spec.Name)
+	if err != nil {
+		return r.restoreRenamed(oldID, spec, "", err)
+	}
+
+	// The old container has to release its ports before the new one can bind them
+	err = r.stopHook(spec, oldID)
+	if err == nil {
+		err = docker.StopContainer(r.cli, oldID)
+	}
+	if err == nil {
+		err = docker.EnsureRunningContainers(r.cli, newID)
+	}
+	if err == nil {
+		err = r.waitHealthy(spec, newID)
+	}
+	if err != nil {
+		return r.restoreRenamed(oldID, spec, newID, err)
+	}
+
+	return docker.DeleteContainer(r.cli, oldID)
+}
+
+// restoreRenamed undoes a failed rename recreate: it removes the new container if one was
+// created, gives the old container its name back and starts it. It returns the original error.
+func (r *Reconciler) restoreRenamed(oldID string, spec docker.ContainerConfig, newID string, cause error) error {
+	log.Warnf("Replacement for container %s failed, restoring the old container: %v", spec.Name, cause)
+
+	if newID != "" {
+		if err := docker.DeleteContainer(r.cli, newID); err != nil {
+			log.Errorf("Error removing failed replacement %s: %v", spec.Name, err)
+			return cause
+		}
+	}
+	if err := docker.RenameContainer(r.cli, oldID, spec.Name); err != nil {
+		log.Errorf("Error renaming container %s back: %v", spec.Name, err)
+		return cause
+	}
+	if err := docker.EnsureRunningContainers(r.cli, oldID); err != nil {
+		log.Errorf("Error starting restored container %s: %v", spec.Name, err)
+	}
+	return cause
+}
+
+// stopHook runs the pre_stop hook in the old container if it is still running
+func (r *Reconciler) stopHook(spec docker.ContainerConfig, containerID string) error {
+	if len(spec.Hooks.PreStop) == 0 {
+		return nil
+	}
+	inspect, err := r.cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return nil
+	}
+	return r.runHook(spec, "pre_stop", spec.Hooks.PreStop, containerID)
+}
+
+func hasHostPorts(spec docker.ContainerConfig) bool {
+	for _, bindings := range spec.PortBindings {
+		for _, binding := range bindings {
+			if binding.HostPort != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitHealthy waits for a container to become healthy, recording timeouts in the metrics
+func (r *Reconciler) waitHealthy(spec docker.ContainerConfig, containerID string) error {
+	log.Debugf("Waiting for container %s to become healthy", spec.Name)
+	err := docker.WaitForHealthy(r.cli, containerID, spec.HealthTimeout)
+	if errors.Is(err, docker.ErrHealthTimeout) {
+		r.metrics.HealthTimeout(spec.Name)
+	}
+	return err
+}
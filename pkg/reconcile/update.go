@@ -0,0 +1,382 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingUpdate is a container running an older image than the latest available one
+type pendingUpdate struct {
+	spec         docker.ContainerConfig
+	containerID  string
+	runningImage string
+	latestImage  string
+	// previousImage is the image reference the container ran before, if the update moves it to another tag
+	previousImage string
+}
+
+// checkForUpdate pulls the configured image and returns a pendingUpdate if the running
+// container uses a different image, or nil if it is up to date
+func (r *Reconciler) checkForUpdate(ctx context.Context, containerID string, config docker.ContainerConfig) (*pendingUpdate, error) {
+	// Get the running container's image ID
+	inspect, err := r.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	runningImageID := inspect.Image
+
+	// Move to the newest tag the update policy allows
+	var previousImage string
+	pull := pulls(config)
+	if pull && tracksTags(config) {
+		if newest := r.newestImage(ctx, config, config.BaseImage); newest != config.Image {
+			log.Infof("Container %s: found newer tag %s", config.Name, newest)
+			previousImage, config.Image = config.Image, newest
+		}
+	}
+
+	// Ask the registry first, so images that didn't change aren't pulled
+	if pull && r.registry != nil {
+		latest, err := r.runningLatest(ctx, runningImageID, config.Image, config.Platform)
+		if err != nil {
+			log.Debugf("Registry check of %s failed, pulling instead: %v", config.Image, err)
+		} else if latest {
+			log.Debugf("Container %s is up to date\n", config.Name)
+			return nil, nil
+		}
+	}
+
+	// Pull the latest image, without pulls the local image of the tag is the latest
+	if pull {
+		progress(ctx, Event{Step: "pulling", Container: config.Name, Message: "image " + config.Image})
+		err = docker.PullPlatformImage(r.cli, config.Image, config.Platform)
+		r.audit(ctx, audit.Entry{Action: audit.ActionPull, Container: config.Name, Reason: "update check", NewImage: config.Image}, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the latest image ID
+	images, err := r.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var latestImageID string
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag == config.Image {
+				latestImageID = img.ID
+				break
+			}
+		}
+	}
+
+	if latestImageID == "" {
+		return nil, fmt.Errorf("could not find the latest image for %s", config.Image)
+	}
+
+	// Compare the image IDs
+	if runningImageID == latestImageID {
+		log.Debugf("Container %s is up to date\n", config.Name)
+		return nil, nil
+	}
+
+	// Don't retry an image that already failed a canary
+	if r.blockedImages[config.Entry] == latestImageID {
+		log.Warnf("Container %s is not up to date, but image %s failed a canary and is held back", config.Name, latestImageID)
+		return nil, nil
+	}
+
+	// Pinned containers move to the digest of the image just pulled
+	if config.PinDigest {
+		config.Digest, err = docker.ImageDigest(r.cli, latestImageID, config.Image)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Refuse images whose signature doesn't verify or that have severe vulnerabilities
+	if err := r.gateImage(ctx, config, latestImageID); err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Container %s is not up to date\n", config.Name)
+	return &pendingUpdate{
+		spec:          config,
+		containerID:   containerID,
+		runningImage:  runningImageID,
+		latestImage:   latestImageID,
+		previousImage: previousImage,
+	}, nil
+}
+
+// runningLatest reports whether the registry digest of ref is one the running image was pulled
+// as. Manifest lists are resolved to the manifest of platform or the host's platform, so images
+// pulled by either digest compare as up to date.
+func (r *Reconciler) runningLatest(ctx context.Context, runningImageID string, ref string, platform string) (bool, error) {
+	if platform == "" {
+		var err error
+		if platform, err = r.hostPlatform(); err != nil {
+			return false, err
+		}
+	}
+	digests, err := r.registry.PlatformDigests(ctx, ref, platform)
+	if err != nil {
+		return false, err
+	}
+
+	inspect, _, err := r.cli.ImageInspectWithRaw(ctx, runningImageID)
+	if err != nil {
+		return false, err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		for _, digest := range digests {
+			if strings.HasSuffix(repoDigest, "@"+digest) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// hostPlatform returns the platform of the Docker host, looked up once
+func (r *Reconciler) hostPlatform() (string, error) {
+	if r.platform != "" {
+		return r.platform, nil
+	}
+	platform, err := docker.HostPlatform(r.cli)
+	if err != nil {
+		return "", fmt.Errorf("error reading host platform: %v", err)
+	}
+	r.platform = platform
+	return platform, nil
+}
+
+// groupUpdates splits pending updates into groups that are rolled out together: containers
+// sharing a rolling update group, or otherwise containers created from the same config entry
+func groupUpdates(pending []pendingUpdate) [][]pendingUpdate {
+	var groups [][]pendingUpdate
+	index := make(map[string]int)
+	for _, update := range pending {
+		key := "entry:" + update.spec.Entry
+		if update.spec.Group != "" {
+			key = "group:" + update.spec.Group
+		}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], update)
+	}
+	return groups
+}
+
+// applyUpdates updates a group of containers, starting with a canary when configured.
+// Containers in a rolling update group are updated in batches of MaxUnavailable and each
+// batch must become healthy before the next one starts. It returns the outcome per container name.
+func (r *Reconciler) applyUpdates(ctx context.Context, group []pendingUpdate) map[string]error {
+	results := make(map[string]error)
+
+	holdBack := func(remaining []pendingUpdate, reason string) map[string]error {
+		for _, update := range remaining {
+			results[update.spec.Name] = fmt.Errorf("update held back, %s", reason)
+		}
+		return results
+	}
+
+	if group[0].spec.Canary.Enabled {
+		canary := group[0]
+		err := r.updateCanary(ctx, canary)
+		results[canary.spec.Name] = err
+		if err != nil {
+			return holdBack(group[1:], fmt.Sprintf("canary %s failed", canary.spec.Name))
+		}
+		group = group[1:]
+	}
+
+	// Containers outside a rolling update group are updated one after the other without waiting
+	if group == nil || group[0].spec.Group == "" {
+		for _, update := range group {
+			_, err := r.updateContainer(ctx, update, update.spec.WaitHealthy)
+			results[update.spec.Name] = err
+		}
+		return results
+	}
+
+	batchSize := group[0].spec.MaxUnavailable
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(group); start += batchSize {
+		end := min(start+batchSize, len(group))
+		batch := group[start:end]
+		log.Infof("Rolling update of group %s: updating %d of %d containers", batch[0].spec.Group, end, len(group))
+
+		failed := false
+		for _, update := range batch {
+			_, err := r.updateContainer(ctx, update, true)
+			results[update.spec.Name] = err
+			if err != nil {
+				failed = true
+			}
+		}
+
+		if failed {
+			return holdBack(group[end:], fmt.Sprintf("rolling update of group %s failed", batch[0].spec.Group))
+		}
+	}
+
+	return results
+}
+
+// updateContainer recreates a container with the latest image and starts it, optionally
+// waiting for it to become healthy
+func (r *Reconciler) updateContainer(ctx context.Context, update pendingUpdate, wait bool) (string, error) {
+	log.Infof("Container %v is not up to date, recreating ...\n", update.spec.Name)
+	if err := r.preUpdateHook(ctx, update); err != nil {
+		return "", err
+	}
+
+	err := r.recreate(ctx, update.containerID, update.spec)
+	r.audit(ctx, audit.Entry{
+		Action:    audit.ActionUpdate,
+		Container: update.spec.Name,
+		Reason:    "newer image",
+		OldImage:  update.runningImage,
+		NewImage:  update.latestImage,
+	}, err)
+	if err != nil {
+		return "", err
+	}
+
+	// Fetch new container ID
+	ctid, err := docker.GetContainerIDByName(r.cli, update.spec.Name)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.ensureRunning(ctx, update.spec.Name, ctid)
+	if err == nil && wait {
+		err = r.waitHealthy(update.spec, ctid)
+	}
+	if err == nil {
+		err = r.runHook(update.spec, "post_start", update.spec.Hooks.PostStart, ctid)
+	}
+	return ctid, err
+}
+
+// updateCanary updates a single container, waits for it to become healthy and stay healthy
+// for the soak period. If it fails the container is rolled back to the image it ran before
+// and the new image is held back until a newer one is released.
+func (r *Reconciler) updateCanary(ctx context.Context, canary pendingUpdate) error {
+	log.Infof("Updating canary %s", canary.spec.Name)
+
+	ctid, err := r.updateContainer(ctx, canary, true)
+	if err == nil {
+		err = r.soak(ctx, ctid, canary.spec.Canary.Soak)
+	}
+	if err == nil {
+		log.Infof("Canary %s is healthy, rolling out to remaining containers", canary.spec.Name)
+		return nil
+	}
+
+	log.Warnf("Canary %s failed, rolling back to image %s: %v", canary.spec.Name, canary.runningImage, err)
+	r.blockImage(canary.spec.Entry, canary.latestImage)
+
+	if rollbackErr := r.rollbackCanary(ctx, canary); rollbackErr != nil {
+		return fmt.Errorf("canary failed: %v, rollback failed: %v", err, rollbackErr)
+	}
+	return fmt.Errorf("canary failed and was rolled back: %v", err)
+}
+
+// soak waits for the soak period and verifies the container is still running and healthy
+func (r *Reconciler) soak(ctx context.Context, containerID string, period time.Duration) error {
+	if period > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(period):
+		}
+	}
+
+	inspect, err := r.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return fmt.Errorf("container stopped during soak period")
+	}
+	if inspect.RestartCount > 0 {
+		return fmt.Errorf("container restarted %d times during soak period", inspect.RestartCount)
+	}
+	if inspect.State.Health != nil && inspect.State.Health.Status != types.Healthy {
+		return fmt.Errorf("container is %s after soak period", inspect.State.Health.Status)
+	}
+	return nil
+}
+
+// blockImage holds imageID back from updates of entry until a newer image is released, across
+// restarts when there is a state store. The caller must hold the run lock.
+func (r *Reconciler) blockImage(entry string, imageID string) {
+	r.blockedImages[entry] = imageID
+	if r.state == nil {
+		return
+	}
+	if err := r.state.BlockImage(entry, imageID); err != nil {
+		log.Errorf("Error persisting blocked image %s of %s: %v", imageID, entry, err)
+	}
+}
+
+// preUpdateHook runs the pre_update hook of update. Stopped containers can't exec, their hook
+// is skipped so they can still be updated.
+func (r *Reconciler) preUpdateHook(ctx context.Context, update pendingUpdate) error {
+	if len(update.spec.Hooks.PreUpdate) == 0 {
+		return nil
+	}
+	inspect, err := r.cli.ContainerInspect(ctx, update.containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		log.Infof("Container %s is not running, skipping its pre_update hook", update.spec.Name)
+		return nil
+	}
+	return r.runHook(update.spec, "pre_update", update.spec.Hooks.PreUpdate, update.containerID)
+}
+
+// rollbackCanary recreates the canary from the image it ran before the update
+func (r *Reconciler) rollbackCanary(ctx context.Context, canary pendingUpdate) (err error) {
+	defer func() {
+		r.audit(ctx, audit.Entry{
+			Action:    audit.ActionRollback,
+			Container: canary.spec.Name,
+			Reason:    "canary failed",
+			OldImage:  canary.latestImage,
+			NewImage:  canary.runningImage,
+		}, err)
+	}()
+
+	// Containers that moved to a newer tag go back to their old tag
+	spec := canary.spec
+	if canary.previousImage != "" {
+		spec.Image = canary.previousImage
+	}
+
+	ctid, err := docker.GetContainerIDByName(r.cli, canary.spec.Name)
+	if err != nil {
+		return err
+	}
+	return r.rollbackContainer(ctx, spec, ctid, canary.runningImage, "")
+}
@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+)
+
+// Credentials authenticate to a registry with a username and password, or with a token
+// that is sent as bearer token as is
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Host configures how a registry is accessed
+type Host struct {
+	Credentials
+	// Mirror is a pull-through mirror such as mirror.local:5000 or http://mirror.local:5000
+	// used instead of the registry, a http:// mirror is accessed insecurely
+	Mirror string
+	// Insecure accesses the registry over plain HTTP
+	Insecure bool
+}
+
+var (
+	hosts   map[string]Host
+	hostsMu sync.RWMutex
+)
+
+// Configure replaces the registry configuration, keyed by registry host
+func Configure(config map[string]Host) {
+	normalized := make(map[string]Host, len(config))
+	for host, h := range config {
+		normalized[NormalizeHost(host)] = h
+	}
+
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	hosts = normalized
+}
+
+// CredentialsFor returns the credentials for the registry an image reference points to
+func CredentialsFor(ref string) (Credentials, bool) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return Credentials{}, false
+	}
+	return credentialsForHost(reference.Domain(named))
+}
+
+// MirrorRef returns the reference of ref on the mirror of its registry, ok is false if the
+// registry has no mirror
+func MirrorRef(ref string) (string, bool) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", false
+	}
+	h, ok := hostConfig(reference.Domain(named))
+	if !ok || h.Mirror == "" {
+		return "", false
+	}
+
+	mirror, _ := mirrorHost(h.Mirror)
+	mirrored := mirror + "/" + reference.Path(named)
+	if digested, ok := named.(reference.Digested); ok {
+		return mirrored + "@" + digested.Digest().String(), true
+	}
+	return mirrored + ":" + reference.TagNameOnly(named).(reference.Tagged).Tag(), true
+}
+
+// HostOf returns the normalized registry host of an image reference, such as docker.io for nginx
+func HostOf(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return NormalizeHost(reference.Domain(named))
+}
+
+// mirrorHost strips the scheme of a mirror, insecure is true for http:// mirrors
+func mirrorHost(mirror string) (string, bool) {
+	if host, ok := strings.CutPrefix(mirror, "http://"); ok {
+		return strings.TrimSuffix(host, "/"), true
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(mirror, "https://"), "/"), false
+}
+
+func hostConfig(host string) (Host, bool) {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+	h, ok := hosts[NormalizeHost(host)]
+	return h, ok
+}
+
+func credentialsForHost(host string) (Credentials, bool) {
+	h, ok := hostConfig(host)
+	if !ok || (h.Username == "" && h.Token == "") {
+		return Credentials{}, false
+	}
+	return h.Credentials, true
+}
+
+// NormalizeHost maps the different names of a registry to one, such as all Docker Hub
+// hosts to docker.io
+func NormalizeHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return host
+}
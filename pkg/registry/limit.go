@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	limiter   = rate.NewLimiter(rate.Inf, 0)
+	limiterMu sync.RWMutex
+)
+
+// SetRateLimit limits requests to registries, including pulls, to requestsPerSecond with
+// bursts of up to burst requests. A rate of 0 or less removes the limit.
+func SetRateLimit(requestsPerSecond float64, burst int) {
+	limit := rate.Inf
+	if requestsPerSecond > 0 {
+		limit = rate.Limit(requestsPerSecond)
+		burst = max(burst, 1)
+	}
+
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	limiter = rate.NewLimiter(limit, burst)
+}
+
+// Wait blocks until the rate limit allows another registry request
+func Wait(ctx context.Context) error {
+	limiterMu.RLock()
+	l := limiter
+	limiterMu.RUnlock()
+	return l.Wait(ctx)
+}
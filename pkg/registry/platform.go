@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxManifestSize bounds the manifests read from registries
+const maxManifestSize = 4 << 20
+
+// manifestList is the part of an OCI index or Docker manifest list needed to pick a platform
+type manifestList struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// PlatformDigests returns the digests the tag of ref currently points to in the registry: the
+// digest of the manifest and, for manifest lists, the digest of the manifest of platform
+// (os/arch[/variant], such as linux/arm64). An image pulled for platform is up to date if it
+// was pulled as either of them.
+func (c *Client) PlatformDigests(ctx context.Context, ref string, platform string) ([]string, error) {
+	repo, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, repo, "/manifests/"+repo.tag, manifestTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	digests := []string{digest}
+
+	var list manifestList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("invalid manifest for %s: %v", ref, err)
+	}
+	if len(list.Manifests) == 0 {
+		return digests, nil
+	}
+
+	goos, arch, variant := ParsePlatform(platform)
+	for _, manifest := range list.Manifests {
+		p := manifest.Platform
+		if p.OS == goos && p.Architecture == arch && sameVariant(arch, p.Variant, variant) {
+			return append(digests, manifest.Digest), nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no image for platform %s", ref, platform)
+}
+
+// ParsePlatform splits a platform such as linux/arm/v7 into os, architecture and variant
+func ParsePlatform(platform string) (string, string, string) {
+	parts := strings.SplitN(platform, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// sameVariant compares architecture variants, an unset variant matches any. arm64 images
+// are v8 unless stated otherwise.
+func sameVariant(arch string, a string, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+	if arch == "arm64" {
+		a, b = strings.TrimPrefix(a, "v8"), strings.TrimPrefix(b, "v8")
+	}
+	return a == b
+}
@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+// manifestTypes are the manifest media types accepted when resolving a tag, manifest lists
+// first so the digest matches the one Docker records when pulling multi-platform images
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Client talks to container registries using the distribution (v2) API
+type Client struct {
+	http *http.Client
+
+	// tokens caches Authorization headers per registry and scope
+	tokens   map[string]string
+	tokensMu sync.Mutex
+}
+
+// New creates a registry client
+func New() *Client {
+	return &Client{
+		http:   &http.Client{Timeout: 30 * time.Second},
+		tokens: make(map[string]string),
+	}
+}
+
+// repository is a parsed image reference
+type repository struct {
+	// host is the registry host used for API requests
+	host string
+	// path is the repository path, such as library/nginx
+	path string
+	// tag is the tag of the reference, latest if none was given
+	tag string
+	// insecure repositories are accessed over plain HTTP
+	insecure bool
+}
+
+func parseReference(ref string) (repository, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return repository{}, fmt.Errorf("invalid image reference %s: %v", ref, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	repo := repository{host: reference.Domain(named), path: reference.Path(named)}
+	if tagged, ok := named.(reference.Tagged); ok {
+		repo.tag = tagged.Tag()
+	}
+
+	h, _ := hostConfig(repo.host)
+	switch {
+	case h.Mirror != "":
+		repo.host, repo.insecure = mirrorHost(h.Mirror)
+		if mirror, ok := hostConfig(repo.host); ok && mirror.Insecure {
+			repo.insecure = true
+		}
+	case repo.host == "docker.io":
+		// Docker Hub serves the API from a different host than its image names use
+		repo.host = "registry-1.docker.io"
+		repo.insecure = h.Insecure
+	default:
+		repo.insecure = h.Insecure
+	}
+	return repo, nil
+}
+
+// Digest returns the manifest digest the tag of ref currently points to in the registry,
+// without downloading the image
+func (c *Client) Digest(ctx context.Context, ref string) (string, error) {
+	repo, err := parseReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(ctx, http.MethodHead, repo, "/manifests/"+repo.tag, manifestTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry %s returned no digest for %s", repo.host, ref)
+	}
+	return digest, nil
+}
+
+// Tags lists the tags of the repository of ref
+func (c *Client) Tags(ctx context.Context, ref string) ([]string, error) {
+	repo, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	path := "/tags/list"
+	for path != "" {
+		resp, err := c.do(ctx, http.MethodGet, repo, path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+		path = nextPage(resp.Header.Get("Link"), repo.path)
+	}
+	return tags, nil
+}
+
+// nextPage returns the path of the next tag page from a Link header such as
+// `</v2/library/nginx/tags/list?last=1.25&n=100>; rel="next"`, relative to the repository
+func nextPage(link string, repoPath string) string {
+	target, params, ok := strings.Cut(link, ";")
+	if !ok || !strings.Contains(params, `rel="next"`) {
+		return ""
+	}
+	target = strings.Trim(strings.TrimSpace(target), "<>")
+	return strings.TrimPrefix(target, "/v2/"+repoPath)
+}
+
+// do sends a request to the repository API, authenticating when the registry asks for it
+func (c *Client) do(ctx context.Context, method string, repo repository, path string, accept []string) (*http.Response, error) {
+	// path is relative to the repository and may carry a query
+	scheme := "https"
+	if repo.insecure {
+		scheme = "http"
+	}
+	endpoint := fmt.Sprintf("%s://%s/v2/%s%s", scheme, repo.host, repo.path, path)
+	scope := "repository:" + repo.path + ":pull"
+
+	send := func(token string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if err := Wait(ctx); err != nil {
+			return nil, err
+		}
+		return c.http.Do(req)
+	}
+
+	creds, hasCreds := credentialsForHost(repo.host)
+	token := c.cachedToken(repo.host, scope)
+	if token == "" && hasCreds && creds.Token != "" {
+		token = "Bearer " + creds.Token
+	}
+
+	resp, err := send(token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		token, err := c.authorize(ctx, challenge, scope, creds, hasCreds)
+		if err != nil {
+			return nil, fmt.Errorf("error authenticating to %s: %v", repo.host, err)
+		}
+		c.tokensMu.Lock()
+		c.tokens[repo.host+" "+scope] = token
+		c.tokensMu.Unlock()
+
+		resp, err = send(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry %s returned %s for %s", repo.host, resp.Status, endpoint)
+	}
+	return resp, nil
+}
+
+func (c *Client) cachedToken(host string, scope string) string {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	return c.tokens[host+" "+scope]
+}
+
+// authorize answers a WWW-Authenticate challenge with the Authorization header to send.
+// Basic challenges are answered with the configured credentials, bearer challenges with a
+// token fetched from the realm, using the credentials if there are any.
+func (c *Client) authorize(ctx context.Context, challenge string, scope string, creds Credentials, hasCreds bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "basic"):
+		if !hasCreds || creds.Username == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password)), nil
+	case !strings.EqualFold(scheme, "bearer") || params["realm"] == "":
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCreds && creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	if err := Wait(ctx); err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return "Bearer " + token.Token, nil
+	}
+	return "Bearer " + token.AccessToken, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header like
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"` into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return scheme, params
+}
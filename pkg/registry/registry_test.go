@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`)
+	if scheme != "Bearer" {
+		t.Errorf("Expected scheme Bearer, got %s", scheme)
+	}
+	if params["realm"] != "https://auth.docker.io/token" || params["service"] != "registry.docker.io" || params["scope"] != "repository:library/nginx:pull" {
+		t.Errorf("Unexpected challenge params %v", params)
+	}
+}
+
+func TestDigestAuthenticatesWithBearerToken(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			w.Write([]byte(`{"token":"secret"}`))
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/team/app/manifests/1.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := New()
+	client.http = server.Client()
+
+	ref := strings.TrimPrefix(server.URL, "https://") + "/team/app:1.0"
+	digest, err := client.Digest(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("Failed to resolve digest: %v", err)
+	}
+	if digest != "sha256:abc" {
+		t.Errorf("Expected digest sha256:abc, got %s", digest)
+	}
+}
+
+func TestMirrorRef(t *testing.T) {
+	Configure(map[string]Host{"index.docker.io": {Mirror: "http://mirror.local:5000"}})
+	defer Configure(nil)
+
+	mirrored, ok := MirrorRef("nginx")
+	if !ok || mirrored != "mirror.local:5000/library/nginx:latest" {
+		t.Errorf("Expected mirror.local:5000/library/nginx:latest, got %s", mirrored)
+	}
+	if _, ok := MirrorRef("ghcr.io/team/app:1.0"); ok {
+		t.Error("Expected no mirror for ghcr.io")
+	}
+
+	repo, err := parseReference("nginx:1.27")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if repo.host != "mirror.local:5000" || !repo.insecure || repo.path != "library/nginx" {
+		t.Errorf("Unexpected repository %+v", repo)
+	}
+}
+
+func TestPlatformDigestsResolvesManifestList(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/team/app/manifests/1.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:index")
+		w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [
+			{"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+			{"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := New()
+	client.http = server.Client()
+
+	ref := strings.TrimPrefix(server.URL, "https://") + "/team/app:1.0"
+	digests, err := client.PlatformDigests(context.Background(), ref, "linux/arm64")
+	if err != nil {
+		t.Fatalf("Failed to resolve digests: %v", err)
+	}
+	if len(digests) != 2 || digests[0] != "sha256:index" || digests[1] != "sha256:arm64" {
+		t.Errorf("Expected index and arm64 digests, got %v", digests)
+	}
+
+	if _, err := client.PlatformDigests(context.Background(), ref, "linux/s390x"); err == nil {
+		t.Errorf("Expected an error for a platform without an image")
+	}
+}
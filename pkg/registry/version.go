@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version is a semver-like image tag such as 1.27.3, v2.1 or 1.27.3-alpine. The part after
+// the first dash is treated as a variant that must match, not as a pre-release.
+type Version struct {
+	Prefix  string
+	Parts   []int
+	Variant string
+}
+
+// ParseVersion parses a tag as a version, ok is false for tags like latest or stable
+func ParseVersion(tag string) (Version, bool) {
+	var v Version
+	if strings.HasPrefix(tag, "v") {
+		v.Prefix = "v"
+		tag = tag[1:]
+	}
+	tag, v.Variant, _ = strings.Cut(tag, "-")
+
+	fields := strings.Split(tag, ".")
+	if len(fields) > 3 {
+		return Version{}, false
+	}
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return Version{}, false
+		}
+		v.Parts = append(v.Parts, n)
+	}
+	return v, true
+}
+
+// Compatible reports whether two versions share prefix, variant and precision, so that one
+// can replace the other
+func (v Version) Compatible(other Version) bool {
+	return v.Prefix == other.Prefix && v.Variant == other.Variant && len(v.Parts) == len(other.Parts)
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or higher than other
+func (v Version) Compare(other Version) int {
+	for i := 0; i < len(v.Parts) && i < len(other.Parts); i++ {
+		switch {
+		case v.Parts[i] < other.Parts[i]:
+			return -1
+		case v.Parts[i] > other.Parts[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(v.Parts) < len(other.Parts):
+		return -1
+	case len(v.Parts) > len(other.Parts):
+		return 1
+	}
+	return 0
+}
+
+// SharesPrefix reports whether the first n parts of both versions are equal
+func (v Version) SharesPrefix(other Version, n int) bool {
+	for i := 0; i < n; i++ {
+		if i >= len(v.Parts) || i >= len(other.Parts) || v.Parts[i] != other.Parts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package registry
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	v, ok := ParseVersion("v1.27.3-alpine")
+	if !ok {
+		t.Fatal("Expected v1.27.3-alpine to parse")
+	}
+	if v.Prefix != "v" || v.Variant != "alpine" || len(v.Parts) != 3 || v.Parts[0] != 1 || v.Parts[1] != 27 || v.Parts[2] != 3 {
+		t.Errorf("Unexpected version %+v", v)
+	}
+
+	for _, tag := range []string{"latest", "1.2.3.4", "stable-alpine", "1.x"} {
+		if _, ok := ParseVersion(tag); ok {
+			t.Errorf("Expected %s not to parse", tag)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	parse := func(tag string) Version {
+		v, _ := ParseVersion(tag)
+		return v
+	}
+
+	if parse("1.27.10").Compare(parse("1.27.9")) != 1 {
+		t.Error("Expected 1.27.10 to be higher than 1.27.9")
+	}
+	if parse("1.2").Compare(parse("1.2")) != 0 {
+		t.Error("Expected 1.2 to equal 1.2")
+	}
+	if parse("1.2.3-alpine").Compatible(parse("1.2.4")) {
+		t.Error("Expected different variants to be incompatible")
+	}
+	if !parse("1.27.3").SharesPrefix(parse("1.27.0"), 2) || parse("1.28.0").SharesPrefix(parse("1.27.0"), 2) {
+		t.Error("Unexpected SharesPrefix result")
+	}
+}
@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Syft generates SBOMs, trivy can generate them as well
+const Syft = "syft"
+
+// SBOM generates a software bill of materials of the image ref with generator (syft by
+// default, or trivy) and returns its format and content. binary overrides the generator's
+// binary in PATH.
+func SBOM(ctx context.Context, generator string, binary string, ref string) (string, []byte, error) {
+	var args []string
+	var format string
+	switch generator {
+	case Syft, "":
+		generator = Syft
+		format = "spdx-json"
+		args = []string{ref, "--quiet", "--output", format}
+	case Trivy:
+		format = "cyclonedx"
+		args = []string{"image", "--quiet", "--format", format, ref}
+	default:
+		return "", nil, fmt.Errorf("unknown SBOM generator %s", generator)
+	}
+	if binary == "" {
+		binary = generator
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", nil, fmt.Errorf("%s SBOM of %s failed: %v: %s", generator, ref, err, msg)
+		}
+		return "", nil, fmt.Errorf("%s SBOM of %s failed: %v", generator, ref, err)
+	}
+
+	if !json.Valid(stdout.Bytes()) {
+		return "", nil, fmt.Errorf("%s returned an invalid SBOM for %s", generator, ref)
+	}
+	return format, stdout.Bytes(), nil
+}
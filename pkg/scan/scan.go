@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Supported scanners, both are run as external binaries
+const (
+	Trivy = "trivy"
+	Grype = "grype"
+)
+
+// Vulnerability is a single finding of a scan
+type Vulnerability struct {
+	ID       string
+	Package  string
+	Severity string
+}
+
+// severities ranks severities from least to most severe, anything else counts as unknown
+var severities = map[string]int{
+	"unknown":    0,
+	"negligible": 1,
+	"low":        2,
+	"medium":     3,
+	"high":       4,
+	"critical":   5,
+}
+
+// ValidSeverity reports whether severity is one of unknown, negligible, low, medium, high or critical
+func ValidSeverity(severity string) bool {
+	_, ok := severities[strings.ToLower(severity)]
+	return ok
+}
+
+// AtLeast returns the vulnerabilities with a severity of threshold or higher
+func AtLeast(vulnerabilities []Vulnerability, threshold string) []Vulnerability {
+	limit := severities[strings.ToLower(threshold)]
+	var found []Vulnerability
+	for _, v := range vulnerabilities {
+		if severities[strings.ToLower(v.Severity)] >= limit {
+			found = append(found, v)
+		}
+	}
+	return found
+}
+
+// Scan scans the image ref with scanner, binary overrides the scanner's binary in PATH
+func Scan(ctx context.Context, scanner string, binary string, ref string) ([]Vulnerability, error) {
+	var args []string
+	var parse func([]byte) ([]Vulnerability, error)
+	switch scanner {
+	case Trivy, "":
+		scanner = Trivy
+		args = []string{"image", "--quiet", "--format", "json", ref}
+		parse = parseTrivy
+	case Grype:
+		args = []string{ref, "--quiet", "--output", "json"}
+		parse = parseGrype
+	default:
+		return nil, fmt.Errorf("unknown scanner %s", scanner)
+	}
+	if binary == "" {
+		binary = scanner
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s scan of %s failed: %v: %s", scanner, ref, err, msg)
+		}
+		return nil, fmt.Errorf("%s scan of %s failed: %v", scanner, ref, err)
+	}
+
+	vulnerabilities, err := parse(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s report of %s: %v", scanner, ref, err)
+	}
+	return vulnerabilities, nil
+}
+
+// parseTrivy reads the vulnerabilities of a trivy JSON report
+func parseTrivy(data []byte) ([]Vulnerability, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID string
+				PkgName         string
+				Severity        string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	var vulnerabilities []Vulnerability
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			vulnerabilities = append(vulnerabilities, Vulnerability{ID: v.VulnerabilityID, Package: v.PkgName, Severity: v.Severity})
+		}
+	}
+	return vulnerabilities, nil
+}
+
+// parseGrype reads the vulnerabilities of a grype JSON report
+func parseGrype(data []byte) ([]Vulnerability, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name string `json:"name"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	var vulnerabilities []Vulnerability
+	for _, match := range report.Matches {
+		vulnerabilities = append(vulnerabilities, Vulnerability{ID: match.Vulnerability.ID, Package: match.Artifact.Name, Severity: match.Vulnerability.Severity})
+	}
+	return vulnerabilities, nil
+}
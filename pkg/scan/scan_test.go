@@ -0,0 +1,52 @@
+package scan
+
+import "testing"
+
+func TestParseTrivy(t *testing.T) {
+	report := `{"Results": [
+		{"Target": "debian", "Vulnerabilities": [
+			{"VulnerabilityID": "CVE-2024-1", "PkgName": "openssl", "Severity": "CRITICAL"},
+			{"VulnerabilityID": "CVE-2024-2", "PkgName": "zlib", "Severity": "LOW"}
+		]},
+		{"Target": "app"}
+	]}`
+
+	vulnerabilities, err := parseTrivy([]byte(report))
+	if err != nil {
+		t.Fatalf("Error parsing report: %v", err)
+	}
+	if len(vulnerabilities) != 2 || vulnerabilities[0].ID != "CVE-2024-1" || vulnerabilities[0].Package != "openssl" {
+		t.Errorf("Unexpected vulnerabilities %+v", vulnerabilities)
+	}
+}
+
+func TestParseGrype(t *testing.T) {
+	report := `{"matches": [
+		{"vulnerability": {"id": "CVE-2024-3", "severity": "High"}, "artifact": {"name": "curl"}}
+	]}`
+
+	vulnerabilities, err := parseGrype([]byte(report))
+	if err != nil {
+		t.Fatalf("Error parsing report: %v", err)
+	}
+	if len(vulnerabilities) != 1 || vulnerabilities[0].ID != "CVE-2024-3" || vulnerabilities[0].Severity != "High" {
+		t.Errorf("Unexpected vulnerabilities %+v", vulnerabilities)
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	vulnerabilities := []Vulnerability{
+		{ID: "a", Severity: "CRITICAL"},
+		{ID: "b", Severity: "High"},
+		{ID: "c", Severity: "medium"},
+		{ID: "d", Severity: "whatever"},
+	}
+
+	found := AtLeast(vulnerabilities, "high")
+	if len(found) != 2 || found[0].ID != "a" || found[1].ID != "b" {
+		t.Errorf("Expected a and b at or above high, got %+v", found)
+	}
+	if found := AtLeast(vulnerabilities, "unknown"); len(found) != 4 {
+		t.Errorf("Expected every vulnerability at or above unknown, got %+v", found)
+	}
+}
@@ -0,0 +1,48 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	// 2024-06-02 is a Sunday
+	sunday := func(hour, minute int) time.Time { return time.Date(2024, 6, 2, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* 3-4 * * sun", sunday(3, 0), true},
+		{"* 3-4 * * sun", sunday(4, 59), true},
+		{"* 3-4 * * sun", sunday(5, 0), false},
+		{"* 3-4 * * sun", sunday(3, 0).AddDate(0, 0, 1), false},
+		{"* 3-4 * * 7", sunday(3, 30), true},
+		{"*/15 * * * *", sunday(10, 45), true},
+		{"*/15 * * * *", sunday(10, 46), false},
+		{"0 0 1 * *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), true},
+		// Day of month or day of week
+		{"* * 1 * mon", sunday(0, 0).AddDate(0, 0, 1), true},
+		{"* * 1 * mon", sunday(0, 0), false},
+		{"* * * jun,dec *", sunday(12, 0), true},
+		{"* * * jan-may *", sunday(12, 0), false},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.expr, err)
+		}
+		if got := s.Matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %v: expected %v, got %v", tt.expr, tt.t, tt.want, got)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-3 * * *", "*/0 * * * *", "* * * * funday"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected %q to be invalid", expr)
+		}
+	}
+}
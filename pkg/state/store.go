@@ -0,0 +1,376 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	containersBucket = []byte("containers")
+	runsBucket       = []byte("runs")
+	unusedBucket     = []byte("unused")
+	imagesBucket     = []byte("images")
+	pinsBucket       = []byte("pins")
+	blockedBucket    = []byte("blocked")
+)
+
+// MaxRuns is the number of reconcile runs kept in the history
+const MaxRuns = 500
+
+// MaxPreviousImages is the number of previous images kept per container
+const MaxPreviousImages = 10
+
+// Store is a small embedded database for state that must survive restarts
+type Store struct {
+	db *bolt.DB
+}
+
+// ContainerRecord describes a container managed by docker-manager
+type ContainerRecord struct {
+	Name        string `json:"name"`
+	Entry       string `json:"entry"`
+	ContainerID string `json:"container_id"`
+	ConfigHash  string `json:"config_hash"`
+	Image       string `json:"image"`
+	ImageID     string `json:"image_id"`
+	// Digest is the repository digest reference (repo@sha256:...) of the image
+	Digest    string    `json:"digest,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// PreviousImages holds the IDs of images the container ran before, newest first
+	PreviousImages []string `json:"previous_images,omitempty"`
+	// PreviousDigests maps previous image IDs to their digest references, so they can be
+	// pulled again if they were removed locally
+	PreviousDigests map[string]string `json:"previous_digests,omitempty"`
+	// Adopted is set for containers created outside docker-manager that it took ownership of
+	Adopted bool `json:"adopted,omitempty"`
+}
+
+// ImageRecord describes the image a container was deployed with
+type ImageRecord struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	ImageID   string `json:"image_id"`
+	// Digest is the repository digest reference (repo@sha256:...) of the image
+	Digest  string            `json:"digest,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Created string            `json:"created,omitempty"`
+	// DeployedAt is when the container was first seen running the image
+	DeployedAt time.Time `json:"deployed_at"`
+	// SBOMFormat is the format of SBOM, such as spdx-json or cyclonedx
+	SBOMFormat string          `json:"sbom_format,omitempty"`
+	SBOM       json.RawMessage `json:"sbom,omitempty"`
+}
+
+// RunRecord is an entry in the reconcile history
+type RunRecord struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Error    string         `json:"error,omitempty"`
+	Results  []ResultRecord `json:"results"`
+}
+
+// ResultRecord is the outcome of a single container in a reconcile run
+type ResultRecord struct {
+	Container string `json:"container"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Open opens (or creates) the store at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{containersBucket, runsBucket, unusedBucket, imagesBucket, pinsBucket, blockedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Container returns the record of a container, or nil if there is none
+func (s *Store) Container(name string) (*ContainerRecord, error) {
+	var record *ContainerRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(containersBucket).Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		record = &ContainerRecord{}
+		return json.Unmarshal(data, record)
+	})
+	return record, err
+}
+
+// Containers returns all container records ordered by name
+func (s *Store) Containers() ([]ContainerRecord, error) {
+	var records []ContainerRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(containersBucket).ForEach(func(_, data []byte) error {
+			var record ContainerRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// PutContainer stores a container record. If the image changed since the last record the
+// old image is added to PreviousImages, with its digest in PreviousDigests.
+func (s *Store) PutContainer(record ContainerRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(containersBucket)
+
+		if data := bucket.Get([]byte(record.Name)); data != nil {
+			var previous ContainerRecord
+			if err := json.Unmarshal(data, &previous); err != nil {
+				return err
+			}
+			record.PreviousImages = previous.PreviousImages
+			record.PreviousDigests = previous.PreviousDigests
+			// Adoption sticks until the container is replaced
+			if previous.Adopted && previous.ContainerID == record.ContainerID {
+				record.Adopted = true
+			}
+			if previous.ImageID != "" && previous.ImageID != record.ImageID {
+				record.PreviousImages = prependUnique(record.PreviousImages, previous.ImageID, record.ImageID)
+				if previous.Digest != "" {
+					if record.PreviousDigests == nil {
+						record.PreviousDigests = make(map[string]string)
+					}
+					record.PreviousDigests[previous.ImageID] = previous.Digest
+				}
+			}
+		}
+		if len(record.PreviousImages) > MaxPreviousImages {
+			record.PreviousImages = record.PreviousImages[:MaxPreviousImages]
+		}
+		for id := range record.PreviousDigests {
+			if !slices.Contains(record.PreviousImages, id) {
+				delete(record.PreviousDigests, id)
+			}
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(record.Name), data)
+	})
+}
+
+// DeleteContainer removes the record of a container and of its image
+func (s *Store) DeleteContainer(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(imagesBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return tx.Bucket(containersBucket).Delete([]byte(name))
+	})
+}
+
+// Image returns the record of the image a container runs, or nil if there is none
+func (s *Store) Image(container string) (*ImageRecord, error) {
+	var record *ImageRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(imagesBucket).Get([]byte(container))
+		if data == nil {
+			return nil
+		}
+		record = &ImageRecord{}
+		return json.Unmarshal(data, record)
+	})
+	return record, err
+}
+
+// PutImage stores the record of the image a container runs
+func (s *Store) PutImage(record ImageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Put([]byte(record.Container), data)
+	})
+}
+
+// AddRun appends a reconcile run to the history, dropping the oldest runs above MaxRuns
+func (s *Store) AddRun(run RunRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(seq), data); err != nil {
+			return err
+		}
+
+		// Drop the oldest runs
+		if seq <= MaxRuns {
+			return nil
+		}
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && binary.BigEndian.Uint64(key) <= seq-MaxRuns; key, _ = cursor.Next() {
+			expired = append(expired, append([]byte(nil), key...))
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs returns up to limit reconcile runs, newest first
+func (s *Store) Runs(limit int) ([]RunRecord, error) {
+	var runs []RunRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(runsBucket).Cursor()
+		for key, data := cursor.Last(); key != nil && (limit <= 0 || len(runs) < limit); key, data = cursor.Prev() {
+			var run RunRecord
+			if err := json.Unmarshal(data, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Unused returns when each tracked resource was first seen unused, keyed as given to MarkUnused
+func (s *Store) Unused() (map[string]time.Time, error) {
+	unused := make(map[string]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(unusedBucket).ForEach(func(key, data []byte) error {
+			var since time.Time
+			if err := since.UnmarshalText(data); err != nil {
+				return err
+			}
+			unused[string(key)] = since
+			return nil
+		})
+	})
+	return unused, err
+}
+
+// MarkUnused records that a resource is unused since the given time, unless it already is
+func (s *Store) MarkUnused(key string, since time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(unusedBucket)
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		data, err := since.MarshalText()
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// ClearUnused stops tracking a resource that is used again or was removed
+func (s *Store) ClearUnused(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(unusedBucket).Delete([]byte(key))
+	})
+}
+
+// Pins returns the digest references containers are pinned to, keyed by container name
+func (s *Store) Pins() (map[string]string, error) {
+	pins := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).ForEach(func(key, data []byte) error {
+			pins[string(key)] = string(data)
+			return nil
+		})
+	})
+	return pins, err
+}
+
+// Pin pins a container to a digest reference
+func (s *Store) Pin(name string, digest string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Put([]byte(name), []byte(digest))
+	})
+}
+
+// Unpin removes the pin of a container
+func (s *Store) Unpin(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Delete([]byte(name))
+	})
+}
+
+// BlockedImages returns the image IDs held back from updates, keyed by config entry
+func (s *Store) BlockedImages() (map[string]string, error) {
+	blocked := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blockedBucket).ForEach(func(key, data []byte) error {
+			blocked[string(key)] = string(data)
+			return nil
+		})
+	})
+	return blocked, err
+}
+
+// BlockImage holds imageID back from updates of a config entry, replacing the image blocked before
+func (s *Store) BlockImage(entry string, imageID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blockedBucket).Put([]byte(entry), []byte(imageID))
+	})
+}
+
+// prependUnique puts value in front of values, dropping other copies of it and of skip
+func prependUnique(values []string, value string, skip string) []string {
+	result := []string{value}
+	for _, v := range values {
+		if v != value && v != skip {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	store, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestPutContainerTracksPreviousImages(t *testing.T) {
+	store := openTestStore(t)
+
+	for _, imageID := range []string{"sha256:a", "sha256:b", "sha256:b", "sha256:c"} {
+		if err := store.PutContainer(ContainerRecord{Name: "nginx", ImageID: imageID}); err != nil {
+			t.Fatalf("Failed to store container: %v", err)
+		}
+	}
+
+	record, err := store.Container("nginx")
+	if err != nil {
+		t.Fatalf("Failed to read container: %v", err)
+	}
+	if record.ImageID != "sha256:c" {
+		t.Errorf("Expected current image sha256:c, got %s", record.ImageID)
+	}
+	if len(record.PreviousImages) != 2 || record.PreviousImages[0] != "sha256:b" || record.PreviousImages[1] != "sha256:a" {
+		t.Errorf("Expected previous images [sha256:b sha256:a], got %v", record.PreviousImages)
+	}
+}
+
+func TestPutContainerTracksPreviousDigests(t *testing.T) {
+	store := openTestStore(t)
+
+	for _, imageID := range []string{"sha256:a", "sha256:b"} {
+		record := ContainerRecord{Name: "nginx", ImageID: imageID, Digest: "nginx@" + imageID}
+		if err := store.PutContainer(record); err != nil {
+			t.Fatalf("Failed to store container: %v", err)
+		}
+	}
+
+	record, err := store.Container("nginx")
+	if err != nil {
+		t.Fatalf("Failed to read container: %v", err)
+	}
+	if record.PreviousDigests["sha256:a"] != "nginx@sha256:a" || len(record.PreviousDigests) != 1 {
+		t.Errorf("Expected previous digests {sha256:a: nginx@sha256:a}, got %v", record.PreviousDigests)
+	}
+}
+
+func TestRunsAreTrimmed(t *testing.T) {
+	store := openTestStore(t)
+
+	for i := 0; i < MaxRuns+5; i++ {
+		if err := store.AddRun(RunRecord{Error: string(rune('a' + i%26))}); err != nil {
+			t.Fatalf("Failed to add run: %v", err)
+		}
+	}
+
+	runs, err := store.Runs(0)
+	if err != nil {
+		t.Fatalf("Failed to read runs: %v", err)
+	}
+	if len(runs) != MaxRuns {
+		t.Errorf("Expected %d runs, got %d", MaxRuns, len(runs))
+	}
+}
+
+func TestMarkUnusedKeepsFirstTime(t *testing.T) {
+	store := openTestStore(t)
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.MarkUnused("volume:data", first); err != nil {
+		t.Fatalf("Failed to mark unused: %v", err)
+	}
+	if err := store.MarkUnused("volume:data", first.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to mark unused: %v", err)
+	}
+
+	unused, err := store.Unused()
+	if err != nil {
+		t.Fatalf("Failed to read unused: %v", err)
+	}
+	if !unused["volume:data"].Equal(first) {
+		t.Errorf("Expected unused since %v, got %v", first, unused["volume:data"])
+	}
+
+	if err := store.ClearUnused("volume:data"); err != nil {
+		t.Fatalf("Failed to clear unused: %v", err)
+	}
+	if unused, _ := store.Unused(); len(unused) != 0 {
+		t.Errorf("Expected no unused resources, got %v", unused)
+	}
+}
+
+func TestDeleteContainerRemovesImage(t *testing.T) {
+	store := openTestStore(t)
+
+	if err := store.PutContainer(ContainerRecord{Name: "web", ImageID: "sha256:a"}); err != nil {
+		t.Fatalf("Failed to put container: %v", err)
+	}
+	if err := store.PutImage(ImageRecord{Container: "web", ImageID: "sha256:a", Labels: map[string]string{"org.opencontainers.image.revision": "abc"}}); err != nil {
+		t.Fatalf("Failed to put image: %v", err)
+	}
+
+	record, err := store.Image("web")
+	if err != nil || record == nil {
+		t.Fatalf("Expected image record, got %v (%v)", record, err)
+	}
+	if record.Labels["org.opencontainers.image.revision"] != "abc" {
+		t.Errorf("Expected revision label abc, got %v", record.Labels)
+	}
+
+	if err := store.DeleteContainer("web"); err != nil {
+		t.Fatalf("Failed to delete container: %v", err)
+	}
+	if record, _ := store.Image("web"); record != nil {
+		t.Errorf("Expected image record to be removed, got %+v", record)
+	}
+}
+
+func TestBlockImage(t *testing.T) {
+	store := openTestStore(t)
+
+	for _, imageID := range []string{"sha256:a", "sha256:b"} {
+		if err := store.BlockImage("web", imageID); err != nil {
+			t.Fatalf("Failed to block image: %v", err)
+		}
+	}
+	blocked, err := store.BlockedImages()
+	if err != nil {
+		t.Fatalf("Failed to read blocked images: %v", err)
+	}
+	if len(blocked) != 1 || blocked["web"] != "sha256:b" {
+		t.Errorf("Expected sha256:b to be blocked for web, got %v", blocked)
+	}
+}
+
+func TestPins(t *testing.T) {
+	store := openTestStore(t)
+
+	if err := store.Pin("web", "nginx@sha256:a"); err != nil {
+		t.Fatalf("Failed to pin: %v", err)
+	}
+	pins, err := store.Pins()
+	if err != nil {
+		t.Fatalf("Failed to read pins: %v", err)
+	}
+	if pins["web"] != "nginx@sha256:a" {
+		t.Errorf("Expected web to be pinned to nginx@sha256:a, got %v", pins)
+	}
+
+	if err := store.Unpin("web"); err != nil {
+		t.Fatalf("Failed to unpin: %v", err)
+	}
+	if pins, _ := store.Pins(); len(pins) != 0 {
+		t.Errorf("Expected no pins, got %v", pins)
+	}
+}
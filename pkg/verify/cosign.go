@@ -0,0 +1,74 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Policy describes the signatures an image must carry, either signed with a public key or
+// keyless with a certificate identity issued by an OIDC issuer
+type Policy struct {
+	// Key is the path or KMS URI of the public key
+	Key string
+	// Identity is the certificate identity (e.g. an email or workflow URL) of keyless signatures
+	Identity string
+	// Issuer is the OIDC issuer of keyless signatures
+	Issuer string
+}
+
+// Enabled reports whether the policy requires a signature
+func (p Policy) Enabled() bool {
+	return p.Key != "" || p.Identity != ""
+}
+
+// DefaultCosign is the cosign binary used unless configured otherwise, looked up in PATH
+const DefaultCosign = "cosign"
+
+var (
+	cosign   = DefaultCosign
+	cosignMu sync.RWMutex
+)
+
+// SetCosign sets the cosign binary, an empty path restores DefaultCosign
+func SetCosign(path string) {
+	if path == "" {
+		path = DefaultCosign
+	}
+	cosignMu.Lock()
+	defer cosignMu.Unlock()
+	cosign = path
+}
+
+// Verify checks the signature of ref, which should be a digest reference so the verified
+// image is the one deployed
+func Verify(ctx context.Context, ref string, policy Policy) error {
+	args := []string{"verify"}
+	switch {
+	case policy.Key != "":
+		args = append(args, "--key", policy.Key)
+	case policy.Identity != "" && policy.Issuer != "":
+		args = append(args, "--certificate-identity", policy.Identity, "--certificate-oidc-issuer", policy.Issuer)
+	default:
+		return fmt.Errorf("signature policy needs a key, or an identity and an issuer")
+	}
+	args = append(args, ref)
+
+	cosignMu.RLock()
+	binary := cosign
+	cosignMu.RUnlock()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("signature verification of %s failed: %v: %s", ref, err, msg)
+		}
+		return fmt.Errorf("signature verification of %s failed: %v", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+// go build -ldflags "-X github.com/huxcrux/docker-manager/pkg/version.Version=v1.2.0 -X github.com/huxcrux/docker-manager/pkg/version.Commit=$(git rev-parse HEAD) -X github.com/huxcrux/docker-manager/pkg/version.Date=$(date -u +%FT%TZ)"
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running docker-manager build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info, the commit and date fall back to the VCS info Go embeds when
+// they weren't set at build time
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature means the signature is missing or doesn't match the payload
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired means the signed timestamp is missing or outside the allowed age
+	ErrExpired = errors.New("timestamp missing or too old")
+	// ErrReplayed means the same signature was accepted before
+	ErrReplayed = errors.New("request replayed")
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of payload
+func Sign(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature, hex encoded with an optional "sha256=" prefix, against body. With
+// a timestamp (unix seconds) the signed payload is "<timestamp>.<body>" and the timestamp must
+// be at most maxAge away from now.
+func Verify(secret []byte, signature string, timestamp string, body []byte, now time.Time, maxAge time.Duration) error {
+	payload := body
+	if timestamp != "" {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrExpired
+		}
+		if age := now.Sub(time.Unix(seconds, 0)); age > maxAge || age < -maxAge {
+			return ErrExpired
+		}
+		payload = append([]byte(timestamp+"."), body...)
+	}
+
+	given, err := DecodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	expected, _ := hex.DecodeString(Sign(secret, payload))
+	if !hmac.Equal(given, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// DecodeSignature returns the MAC of a hex encoded signature with an optional "sha256=" prefix.
+// Signatures differing only in prefix or case decode to the same MAC.
+func DecodeSignature(signature string) ([]byte, error) {
+	mac, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(mac) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	return mac, nil
+}
+
+// Replays remembers accepted signatures to reject requests sent again
+type Replays struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Seen records key, such as a source and its decoded MAC, and reports whether it was recorded
+// within maxAge before now. Older keys are forgotten.
+func (r *Replays) Seen(key string, now time.Time, maxAge time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen == nil {
+		r.seen = make(map[string]time.Time)
+	}
+	for k, seen := range r.seen {
+		if now.Sub(seen) > maxAge {
+			delete(r.seen, k)
+		}
+	}
+	if _, ok := r.seen[key]; ok {
+		return true
+	}
+	r.seen[key] = now
+	return false
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"action":"push"}`)
+	now := time.Unix(1700000000, 0)
+
+	if err := Verify(secret, "sha256="+Sign(secret, body), "", body, now, time.Minute); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	if err := Verify(secret, Sign([]byte("other"), body), "", body, now, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected an invalid signature, got %v", err)
+	}
+
+	signed := Sign(secret, append([]byte("1700000000."), body...))
+	if err := Verify(secret, signed, "1700000000", body, now.Add(30*time.Second), time.Minute); err != nil {
+		t.Errorf("Expected a valid timestamped signature, got %v", err)
+	}
+	if err := Verify(secret, signed, "1700000000", body, now.Add(2*time.Minute), time.Minute); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected an expired timestamp, got %v", err)
+	}
+	if err := Verify(secret, signed, "1700000001", body, now, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a changed timestamp to invalidate the signature, got %v", err)
+	}
+}
+
+func TestDecodeSignature(t *testing.T) {
+	mac, err := DecodeSignature("sha256=ABCDEF")
+	if err != nil {
+		t.Fatalf("Error decoding signature: %v", err)
+	}
+	if other, _ := DecodeSignature("abcdef"); string(other) != string(mac) {
+		t.Errorf("Expected the prefix and case to be ignored, got %x and %x", mac, other)
+	}
+	if _, err := DecodeSignature("sha256="); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected an empty signature to be invalid, got %v", err)
+	}
+}
+
+func TestReplays(t *testing.T) {
+	var replays Replays
+	now := time.Now()
+	if replays.Seen("a", now, time.Minute) {
+		t.Errorf("Expected a new key to be unseen")
+	}
+	if !replays.Seen("a", now.Add(time.Second), time.Minute) {
+		t.Errorf("Expected a repeated key to be seen")
+	}
+	if replays.Seen("a", now.Add(2*time.Minute), time.Minute) {
+		t.Errorf("Expected an expired key to be forgotten")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientIdleTimeout is how long a client's limiter is kept after its last request
+const clientIdleTimeout = 10 * time.Minute
+
+// clientLimiter is the token bucket of one caller
+type clientLimiter struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+var (
+	clientLimiters   = map[string]*clientLimiter{}
+	clientLimitersMu sync.Mutex
+	lastSweep        time.Time
+)
+
+// rateLimit limits requests to handler per client, identified by its valid API token or else
+// its IP address, to app_config.api.rate_limit. Every rate limited endpoint shares a client's
+// bucket, requests over the limit get 429 with a Retry-After header.
+func rateLimit(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := currentConfig().AppConfig.API.RateLimit
+		if limit.RequestsPerSecond <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		reservation := clientReservation(clientKey(r), rate.Limit(limit.RequestsPerSecond), max(limit.Burst, 1))
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			respondError(w, r, http.StatusTooManyRequests, codeTooManyRequests, "Too many requests, retry later")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// clientReservation reserves a request of the client key, adjusting its limiter to a changed
+// config and forgetting clients that have been idle
+func clientReservation(key string, limit rate.Limit, burst int) *rate.Reservation {
+	clientLimitersMu.Lock()
+	defer clientLimitersMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastSweep) > time.Minute {
+		for k, client := range clientLimiters {
+			if now.Sub(client.seen) > clientIdleTimeout {
+				delete(clientLimiters, k)
+			}
+		}
+		lastSweep = now
+	}
+
+	client, ok := clientLimiters[key]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(limit, burst)}
+		clientLimiters[key] = client
+	}
+	if client.limiter.Limit() != limit || client.limiter.Burst() != burst {
+		client.limiter.SetLimitAt(now, limit)
+		client.limiter.SetBurstAt(now, burst)
+	}
+	client.seen = now
+	return client.limiter.ReserveN(now, 1)
+}
+
+// clientKey identifies the caller of r by its token, or its IP address without a valid one.
+// Unverified tokens are ignored, a new random token per request would get a fresh bucket.
+func clientKey(r *http.Request) string {
+	cfgMu.RLock()
+	admin, readOnly := adminTokens, readOnlyTokens
+	cfgMu.RUnlock()
+	if token := requestToken(r); validToken(token, admin) || validToken(token, readOnly) {
+		return "token:" + token
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientKey(t *testing.T) {
+	cfgMu.Lock()
+	adminTokens, readOnlyTokens = []string{"admin"}, []string{"read"}
+	cfgMu.Unlock()
+	t.Cleanup(func() {
+		cfgMu.Lock()
+		adminTokens, readOnlyTokens = nil, nil
+		cfgMu.Unlock()
+	})
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"valid token", "read", "token:read"},
+		{"unknown token", "random", "ip:192.0.2.1"},
+		{"no token", "", "ip:192.0.2.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/update", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tc.token != "" {
+				req.Header.Set("X-API-Key", tc.token)
+			}
+			if key := clientKey(req); key != tc.want {
+				t.Errorf("Expected key %s, got %s", tc.want, key)
+			}
+		})
+	}
+}
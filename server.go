@@ -0,0 +1,296 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/huxcrux/docker-manager/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// serveMetrics serves /metrics on its own address, so the scrape endpoint can be firewalled
+// separately from the mutating API
+func serveMetrics(metricsConfig config.MetricsConfig, handler http.Handler, stateDir string) {
+	username, password, err := config.BasicAuthCredentials(metricsConfig.BasicAuth)
+	if err != nil {
+		log.Fatalf("Error reading metrics basic auth: %v", err)
+	}
+	if username != "" {
+		handler = basicAuth(handler, username, password)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	log.Infof("Serving metrics on %s", metricsConfig.ListenAddress)
+	server := &http.Server{Addr: metricsConfig.ListenAddress, Handler: mux}
+	if err := listen(server, metricsConfig.TLS, stateDir); err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+}
+
+// defaultPprofAddress only serves profiles on the host
+const defaultPprofAddress = "127.0.0.1:6060"
+
+// servePprof serves the runtime profiles and expvar variables on their own address, requiring
+// an admin token when tokens are configured. Heap dumps and the command line must not leak,
+// on other addresses than loopback ones admin tokens are always required.
+func servePprof(pprofConfig config.PprofConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	address := cmp.Or(pprofConfig.ListenAddress, defaultPprofAddress)
+	var handler http.Handler = mux
+	if !loopbackAddress(address) {
+		cfgMu.RLock()
+		admin := adminTokens
+		cfgMu.RUnlock()
+		if len(admin) == 0 {
+			log.Errorf("Not serving pprof on %s, addresses other than loopback ones require admin tokens in app_config.api.tokens", address)
+			return
+		}
+		handler = requireAdminTokens(handler)
+	}
+	log.Infof("Serving pprof on %s", address)
+	if err := http.ListenAndServe(address, requireToken(roleAdmin, handler)); err != nil {
+		log.Fatalf("Error serving pprof: %v", err)
+	}
+}
+
+// loopbackAddress reports whether the listen address only accepts connections from the host
+func loopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// normalizeBasePath turns base paths such as docker-manager/ into /docker-manager, the root
+// path into no prefix
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// withBasePath serves handler under basePath, stripping it from request paths. Requests
+// outside of it get 404.
+func withBasePath(basePath string, handler http.Handler) http.Handler {
+	if basePath == "" {
+		return handler
+	}
+	mux := http.NewServeMux()
+	mux.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+	return mux
+}
+
+// listen runs server, over TLS if a certificate is configured or a self-signed one is
+// requested, which is kept in stateDir
+func listen(server *http.Server, tlsConfig config.TLSConfig, stateDir string) error {
+	switch {
+	case tlsConfig.Cert != "":
+		return server.ListenAndServeTLS(tlsConfig.Cert, tlsConfig.Key)
+	case tlsConfig.SelfSigned:
+		cert, err := selfSignedCert(stateDir)
+		if err != nil {
+			return fmt.Errorf("error loading self-signed certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		return server.ListenAndServeTLS("", "")
+	default:
+		return server.ListenAndServe()
+	}
+}
+
+// defaultSocketMode lets the owner and group of the socket use the API
+const defaultSocketMode = 0o660
+
+// listenUnix listens on the unix socket of socketConfig, replacing a socket left behind by a
+// previous run
+func listenUnix(socketConfig config.UnixSocketConfig) (net.Listener, error) {
+	mode := os.FileMode(defaultSocketMode)
+	if socketConfig.Mode != "" {
+		parsed, err := strconv.ParseUint(socketConfig.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unix socket mode %q: %v", socketConfig.Mode, err)
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	if info, err := os.Lstat(socketConfig.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(socketConfig.Path); err != nil {
+			return nil, fmt.Errorf("error removing stale socket: %v", err)
+		}
+	}
+	listener, err := net.Listen("unix", socketConfig.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketConfig.Path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error setting socket mode: %v", err)
+	}
+	return listener, nil
+}
+
+// basicAuth requires the given credentials for every request to handler
+func basicAuth(handler http.Handler, username string, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="docker-manager"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// role is the access a request to an endpoint needs
+type role int
+
+const (
+	// roleRead allows endpoints that don't change anything, such as /status
+	roleRead role = iota
+	// roleAdmin allows every endpoint
+	roleAdmin
+)
+
+// requireToken rejects requests to handler without a token of the given role, sent as
+// "Authorization: Bearer <token>" or in the X-API-Key header. Admin tokens have every role. A
+// request without a token gets 401, one with a token lacking the role 403. Without
+// configured tokens every request is allowed.
+func requireToken(required role, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfgMu.RLock()
+		admin, readOnly := adminTokens, readOnlyTokens
+		cfgMu.RUnlock()
+		if len(admin) == 0 && len(readOnly) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		token := requestToken(r)
+		switch {
+		case validToken(token, admin):
+		case validToken(token, readOnly):
+			if required == roleAdmin {
+				respondError(w, r, http.StatusForbidden, codeForbidden, "Forbidden, the token is read-only")
+				return
+			}
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="docker-manager"`)
+			respondError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminTokens refuses requests to handler with 403 unless admin tokens are configured.
+// Wrapped in requireToken it guards endpoints too dangerous to serve without authentication,
+// such as exec.
+func requireAdminTokens(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfgMu.RLock()
+		admin := adminTokens
+		cfgMu.RUnlock()
+		if len(admin) == 0 {
+			respondError(w, r, http.StatusForbidden, codeForbidden, "Forbidden, this endpoint requires admin tokens in app_config.api.tokens")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	defaultCORSHeaders = []string{"Authorization", "X-API-Key", "Content-Type"}
+)
+
+// cors adds CORS headers for the origins in app_config.api.cors and answers preflight requests
+// before they reach authentication, browsers send them without credentials
+func cors(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		corsConfig := currentConfig().AppConfig.API.CORS
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(corsConfig.AllowedOrigins) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !slices.Contains(corsConfig.AllowedOrigins, "*") && !slices.Contains(corsConfig.AllowedOrigins, origin) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		methods, headers := corsConfig.AllowedMethods, corsConfig.AllowedHeaders
+		if len(methods) == 0 {
+			methods = defaultCORSMethods
+		}
+		if len(headers) == 0 {
+			headers = defaultCORSHeaders
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		if corsConfig.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsConfig.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// respondError answers with an error object on the JSON API and plain text elsewhere
+func respondError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	if wantsJSON(r) {
+		writeError(w, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// requestToken returns the bearer token or API key of r
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validToken compares token to every configured token in constant time
+func validToken(token string, tokens []string) bool {
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return token != "" && valid
+}
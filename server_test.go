@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminTokens(t *testing.T) {
+	handler := requireToken(roleAdmin, requireAdminTokens(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	for _, tc := range []struct {
+		name     string
+		admin    []string
+		readOnly []string
+		token    string
+		want     int
+	}{
+		{"no tokens configured", nil, nil, "", http.StatusForbidden},
+		{"read-only token", []string{"admin"}, []string{"read"}, "read", http.StatusForbidden},
+		{"admin token", []string{"admin"}, []string{"read"}, "admin", http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgMu.Lock()
+			adminTokens, readOnlyTokens = tc.admin, tc.readOnly
+			cfgMu.Unlock()
+			t.Cleanup(func() {
+				cfgMu.Lock()
+				adminTokens, readOnlyTokens = nil, nil
+				cfgMu.Unlock()
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/containers/web/exec", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("Expected %d, got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	for address, want := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"localhost:6060": true,
+		"[::1]:6060":     true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.5:6060":  false,
+	} {
+		if got := loopbackAddress(address); got != want {
+			t.Errorf("Expected loopback %v for %s, got %v", want, address, got)
+		}
+	}
+}
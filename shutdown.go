@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultShutdownTimeout is how long a shutdown waits for requests and reconciles
+	defaultShutdownTimeout = 30 * time.Second
+	// abortTimeout is how long a cancelled reconcile gets to stop at its next container
+	abortTimeout = 10 * time.Second
+	// otlpShutdownTimeout bounds the final OTLP export
+	otlpShutdownTimeout = 10 * time.Second
+)
+
+// abortCtx is cancelled when a shutdown runs out of time, reconciles then stop before their
+// next container instead of being killed while recreating one
+var abortCtx, abort = context.WithCancel(context.Background())
+
+// detach returns a context with the values of ctx that is only cancelled when a shutdown is
+// aborted, so neither a client disconnecting nor the start of a shutdown interrupts a reconcile
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(abortCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// applyLoop reconciles right away and then every reconcile_interval without serving the API,
+// until SIGINT or SIGTERM
+func applyLoop(reconciler *reconcile.Reconciler) error {
+	if currentConfig().AppConfig.ReconcileInterval <= 0 {
+		return fmt.Errorf("apply needs app_config.reconcile_interval to keep reconciling, use --once to reconcile a single time")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		reconcileCtx, cancel := detach(audit.WithRequester(ctx, "apply"))
+		defer cancel()
+		if report, err := reconciler.Reconcile(reconcileCtx, currentConfig()); err != nil {
+			log.Errorf("Error reconciling containers: %v", err)
+		} else {
+			fmt.Print(report.String())
+		}
+		reconcileLoop(ctx, reconciler)
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(reconciler)
+	log.Info("Shut down")
+	return nil
+}
+
+// shutdown stops accepting requests and waits up to app_config.shutdown_timeout for running
+// requests and reconciles to finish, then cancels what is left. No reconcile starts after it
+// returned.
+func shutdown(reconciler *reconcile.Reconciler, servers ...*http.Server) {
+	defer abort()
+	timeout := cmp.Or(currentConfig().AppConfig.ShutdownTimeout, defaultShutdownTimeout)
+	log.Infof("Shutting down, waiting up to %s for requests and reconciles to finish", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warnf("Error shutting down API server: %v", err)
+		}
+	}
+	if err := reconciler.Shutdown(ctx); err == nil {
+		return
+	}
+
+	log.Warnf("Reconcile still running after %s, cancelling it", timeout)
+	abort()
+	ctx, cancel = context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	if err := reconciler.Shutdown(ctx); err != nil {
+		log.Errorf("Reconcile did not stop after being cancelled: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/huxcrux/docker-manager/pkg/audit"
+	"github.com/huxcrux/docker-manager/pkg/config"
+	"github.com/huxcrux/docker-manager/pkg/reconcile"
+	"github.com/huxcrux/docker-manager/pkg/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxWebhookSize bounds webhook bodies, which are only read to verify their signature
+	maxWebhookSize = 1 << 20
+	// defaultSignatureHeader is the header GitHub, Gitea and many registries sign with
+	defaultSignatureHeader = "X-Hub-Signature-256"
+	// defaultWebhookMaxAge bounds timestamps and how long signatures are remembered
+	defaultWebhookMaxAge = 5 * time.Minute
+)
+
+// webhookReplays are the MACs accepted within their source's max age. Without a timestamp
+// header a request is only rejected as replayed within max age, after that it is accepted again.
+var webhookReplays webhook.Replays
+
+// inboundWebhook triggers a reconcile for a source of app_config.inbound_webhooks once the
+// HMAC signature of the request verified. It answers 202 right away, the reconcile runs in
+// the background.
+func inboundWebhook(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := r.PathValue("source")
+		webhookConfig, ok := currentConfig().AppConfig.InboundWebhooks[source]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Webhook source %s is not configured", source), http.StatusNotFound)
+			return
+		}
+		secret, err := config.WebhookSecret(webhookConfig)
+		if err != nil {
+			log.Errorf("Error reading secret of webhook source %s: %v", source, err)
+			http.Error(w, "Error reading webhook secret", http.StatusInternalServerError)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		maxAge := cmp.Or(webhookConfig.MaxAge, defaultWebhookMaxAge)
+		signature := r.Header.Get(cmp.Or(webhookConfig.SignatureHeader, defaultSignatureHeader))
+		var timestamp string
+		if webhookConfig.TimestampHeader != "" {
+			// an empty timestamp would skip the check
+			timestamp = cmp.Or(r.Header.Get(webhookConfig.TimestampHeader), "missing")
+		}
+		now := time.Now()
+		err = webhook.Verify([]byte(secret), signature, timestamp, body, now, maxAge)
+		if err == nil {
+			// keyed on the MAC, the header may be re-encoded without invalidating it
+			mac, _ := webhook.DecodeSignature(signature)
+			if webhookReplays.Seen(source+" "+string(mac), now, maxAge) {
+				err = webhook.ErrReplayed
+			}
+		}
+		if err != nil {
+			log.Warnf("Rejected webhook from %s for source %s: %v", r.RemoteAddr, source, err)
+			http.Error(w, fmt.Sprintf("Unauthorized, %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		if webhookConfig.Reload {
+			if err := updateConfig(); err != nil {
+				log.Errorf("Error reloading config for webhook source %s: %v", source, err)
+				http.Error(w, fmt.Sprintf("Error reloading config: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		log.Infof("Webhook from source %s accepted, reconciling", source)
+		go func() {
+			ctx, cancel := detach(audit.WithRequester(context.Background(), "webhook:"+source))
+			defer cancel()
+			report, err := reconciler.Reconcile(ctx, currentConfig())
+			switch {
+			case errors.Is(err, reconcile.ErrReconcileInProgress), errors.Is(err, reconcile.ErrPaused):
+				log.Infof("Reconcile for webhook source %s skipped: %v", source, err)
+			case err != nil:
+				log.Errorf("Error reconciling for webhook source %s: %v", source, err)
+			case len(report.Failed()) > 0:
+				log.Errorf("Reconcile for webhook source %s finished with errors: %v", source, report.Err())
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "Reconcile triggered\n")
+	}
+}